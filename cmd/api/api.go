@@ -13,6 +13,8 @@ import (
 	"github.com/go-chi/chi/v5/middleware"
 
 	"github.com/Brownei/api-generation-api/config"
+	appmiddleware "github.com/Brownei/api-generation-api/middleware"
+	"github.com/Brownei/api-generation-api/services"
 	"github.com/Brownei/api-generation-api/store"
 	"github.com/Brownei/api-generation-api/utils"
 	"go.uber.org/zap"
@@ -38,6 +40,11 @@ func NewApplication(logger *zap.SugaredLogger, cfg *config.AppConfig, db *gorm.D
 }
 
 func (a *Application) Run() error {
+	trustedProxies, err := appmiddleware.ParseTrustedProxies(a.cfg.TrustedProxies)
+	if err != nil {
+		return err
+	}
+
 	r := chi.NewRouter()
 
 	server := &http.Server{
@@ -47,14 +54,22 @@ func (a *Application) Run() error {
 		IdleTimeout:  time.Second * 10,
 		WriteTimeout: time.Second * 5,
 	}
+	if a.cfg.TLSEnabled() {
+		if server.TLSConfig, err = a.cfg.ServerTLSConfig(); err != nil {
+			return err
+		}
+	}
 
 	authController := a.store.AuthController
 	apiKeyController := a.store.APIKeyController
 	userController := a.store.UserController
+	auditLogController := a.store.AuditLogController
+	clientCertController := a.store.ClientCertController
+	oauthController := a.store.OAuthController
 
 	// A good base middleware stack
 	r.Use(middleware.RequestID)
-	r.Use(middleware.RealIP)
+	r.Use(appmiddleware.RealIP(trustedProxies))
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 
@@ -71,32 +86,113 @@ func (a *Application) Run() error {
 		utils.WriteJSON(w, http.StatusOK, []byte("Welcome to Brownson Esiti's Submission"))
 	})
 
+	r.Get("/.well-known/jwks.json", authController.JWKS)
+
 	r.Route("/v1", func(r chi.Router) {
 		r.Get("/api/health", func(w http.ResponseWriter, r *http.Request) {
 			utils.WriteJSON(w, http.StatusOK, map[string]string{"status": "ok"})
 		})
 
-		r.Post("/api/auth/register", authController.Register)
-		r.Post("/api/auth/login", authController.Login)
+		rateLimit := appmiddleware.RateLimit(a.store.RateLimiter)
+		// Failed credentials never reach rateLimit, so they are charged to
+		// the client IP ahead of authentication.
+		authFailureLimit := appmiddleware.RateLimitAuthFailures(a.store.RateLimiter)
+		userAuth := appmiddleware.Authenticate(a.store.JWTAuthenticator)
+		// A client certificate alone is tried last, so a key sent alongside
+		// one is checked against it instead.
+		anyAuth := appmiddleware.Authenticate(
+			a.store.SignatureAuthenticator,
+			a.store.APIKeyAuthenticator,
+			a.store.JWTAuthenticator,
+			a.store.ClientCertAuthenticator,
+		)
+		audit := appmiddleware.AuditLogMiddleware(a.store.AuditLogService)
+
+		r.With(rateLimit).Post("/api/auth/register", authController.Register)
+		r.With(rateLimit).Post("/api/auth/login", authController.Login)
+		r.With(rateLimit).Post("/api/auth/refresh", authController.Refresh)
+		r.With(rateLimit).Post("/api/auth/logout", authController.Logout)
+
+		// API keys trade their credentials for short-lived access tokens.
+		r.With(rateLimit).Post("/oauth/token", oauthController.Token)
+		// Gateways check credentials here; the handler also requires an admin.
+		r.With(authFailureLimit, anyAuth, audit, rateLimit, appmiddleware.RequireScope(services.ScopeTokensIntrospect)).Post("/oauth/introspect", oauthController.Introspect)
+
+		// Sessions belong to user logins, so API keys can't manage them.
+		r.With(authFailureLimit, userAuth, audit, rateLimit).Get("/api/auth/sessions", authController.ListSessions)
+		r.With(authFailureLimit, userAuth, audit, rateLimit).Delete("/api/auth/sessions/{id}", authController.RevokeSession)
+		r.With(authFailureLimit, userAuth, audit, rateLimit).Post("/api/auth/logout-all", authController.LogoutAll)
 
 		r.Route("/api", func(r chi.Router) {
-			r.Use(AuthMiddleware(a.cfg.JWTSecret))
-			// r.Use(appmiddleware.AuditLogMiddleware(a.store.AuditLogService))
-
-			r.Get("/users/{id}", userController.FindAUser)
+			// Every route accepts a user session or an API key; API keys
+			// are further limited by the scopes each route requires.
+			r.Use(authFailureLimit)
+			r.Use(anyAuth)
+			r.Use(audit)
+			r.Use(rateLimit)
+
+			r.With(appmiddleware.RequireScope(services.ScopeUsersRead)).Get("/users/{id}", userController.FindAUser)
+			r.With(appmiddleware.RequireScope(services.ScopeAuditRead)).Get("/audit-logs", auditLogController.ListAuditLogs)
+			r.With(appmiddleware.RequireScope(services.ScopeAuditRead)).Get("/audit-logs/verify", auditLogController.VerifyAuditChain)
+			r.With(appmiddleware.RequireScope(services.ScopeAuditRead)).Get("/audit-logs/export", auditLogController.ExportAuditLogs)
 
 			r.Route("/api-key", func(r chi.Router) {
-				r.Post("/", apiKeyController.CreateAPIKey)
-				r.Get("/", apiKeyController.ListAPIKeys)
-				r.Get("/{id}", apiKeyController.RevokeAPIKey)
+				r.With(appmiddleware.RequireScope(services.ScopeKeysWrite)).Post("/", apiKeyController.CreateAPIKey)
+				r.With(appmiddleware.RequireScope(services.ScopeKeysRead)).Get("/", apiKeyController.ListAPIKeys)
+				r.With(appmiddleware.RequireScope(services.ScopeKeysWrite)).Get("/{id}", apiKeyController.RevokeAPIKey)
+				r.With(appmiddleware.RequireScope(services.ScopeKeysWrite)).Post("/{id}/rotate", apiKeyController.RotateAPIKey)
+				r.With(appmiddleware.RequireScope(services.ScopeKeysWrite)).Put("/{id}/allowed-ips", apiKeyController.UpdateAllowedIPs)
+				r.With(appmiddleware.RequireScope(services.ScopeKeysRead)).Get("/{id}/usage", apiKeyController.GetAPIKeyUsage)
+			})
+
+			r.Route("/client-certificates", func(r chi.Router) {
+				r.With(appmiddleware.RequireScope(services.ScopeKeysWrite)).Post("/", clientCertController.RegisterClientCert)
+				r.With(appmiddleware.RequireScope(services.ScopeKeysRead)).Get("/", clientCertController.ListClientCerts)
+				r.With(appmiddleware.RequireScope(services.ScopeKeysWrite)).Delete("/{id}", clientCertController.DeleteClientCert)
+			})
+
+			r.Route("/admin/api-keys", func(r chi.Router) {
+				r.With(appmiddleware.RequireScope(services.ScopeKeysWrite)).Post("/{id}/suspend", apiKeyController.SuspendAPIKey)
+				r.With(appmiddleware.RequireScope(services.ScopeKeysWrite)).Post("/{id}/reactivate", apiKeyController.ReactivateAPIKey)
 			})
 		})
 	})
 
+	a.store.KeyRing.Start(a.cfg.JWTKeyRotationInterval, func(err error) {
+		a.logger.Errorf("JWT signing key rotation failed: %v", err)
+	})
+	defer a.store.KeyRing.Stop()
+
+	a.store.AuditLogService.StartRetention(a.cfg.AuditRetentionInterval, func(err error) {
+		a.logger.Errorf("Audit log archival failed: %v", err)
+	})
+	defer a.store.AuditLogService.StopRetention()
+
+	a.store.APIKeyService.StartLastUsedFlush(a.cfg.APIKeyLastUsedFlushInterval, func(err error) {
+		a.logger.Errorf("Flushing API key last-used times failed: %v", err)
+	})
+
+	a.store.APIKeyService.StartExpirySweeper(a.cfg.APIKeyExpirySweepInterval, func(err error) {
+		a.logger.Errorf("API key expiry sweep failed: %v", err)
+	})
+	defer a.store.APIKeyService.StopExpirySweeper()
+
+	a.store.UsageService.Start(a.cfg.UsageAggregationInterval, func(err error) {
+		a.logger.Errorf("API key usage aggregation failed: %v", err)
+	})
+	defer a.store.UsageService.Stop()
+
 	// Run the server in a goroutine so it doesn't block
 	go func() {
 		log.Printf("Running currently on %s", ":8080")
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if server.TLSConfig != nil {
+			// The certificate is already in TLSConfig.
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			log.Fatalf("ListenAndServe(): %v", err)
 		}
 	}()
@@ -120,6 +216,15 @@ func (a *Application) Run() error {
 		log.Fatalf("Server forced to shutdown: %v", err)
 	}
 
+	// In-flight requests are done, so nothing else will be queued.
+	if err := a.store.APIKeyService.StopLastUsedFlush(); err != nil {
+		a.logger.Errorf("Flushing API key last-used times failed: %v", err)
+	}
+	a.store.AuditLogService.Close()
+	if stats := a.store.AuditLogService.Stats(); stats.Dropped > 0 || stats.Failed > 0 {
+		a.logger.Warnf("Audit log lost %d dropped and %d failed entries", stats.Dropped, stats.Failed)
+	}
+
 	log.Printf("Server exiting")
 	return nil
 }
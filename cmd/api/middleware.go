@@ -2,97 +2,24 @@ package api
 
 import (
 	"context"
-	"fmt"
 	"net/http"
-	"strconv"
-	"strings"
 
 	"github.com/Brownei/api-generation-api/utils"
-	"github.com/golang-jwt/jwt/v5"
 )
 
-func AuthMiddleware(jwtSecret string) func(http.Handler) http.Handler {
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			authHeader := r.Header.Get("Authorization")
-			if authHeader == "" {
-				http.Error(w, "Authorization header required", http.StatusUnauthorized)
-				return
-			}
-
-			tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-			if tokenString == authHeader {
-				http.Error(w, "Invalid authorization header format", http.StatusUnauthorized)
-				return
-			}
-
-			token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-				if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-					return nil, jwt.ErrSignatureInvalid
-				}
-				return []byte(jwtSecret), nil
-			})
-
-			if err != nil || token.Valid == false {
-				fmt.Printf("Error: %v", err)
-
-				http.Error(w, "Invalid token", http.StatusUnauthorized)
-				return
-			}
-
-			claims, ok := token.Claims.(jwt.MapClaims)
-			if !ok {
-				http.Error(w, "Invalid token claims", http.StatusUnauthorized)
-				return
-			}
-
-			userIDValue, ok := claims["user_id"]
-			if !ok {
-				http.Error(w, "Invalid user ID in token", http.StatusUnauthorized)
-				return
-			}
-
-			// Type assertion to get the actual value
-			var userID uint
-
-			switch v := userIDValue.(type) {
-			case float64:
-				userID = uint(v) // JWT numbers are float64 by default
-			case int:
-				userID = uint(v)
-			case int64:
-				userID = uint(v)
-			case uint:
-				userID = v
-			case string:
-				id, _ := strconv.ParseUint(v, 10, 64)
-				userID = uint(id)
-			default:
-				http.Error(w, "user_id has invalid type", http.StatusInternalServerError)
-				return
-			}
-
-			// Add to context
-			fmt.Printf("User id from claims: %v and %v. End of story", claims, claims["user_id"])
-			ctx := context.WithValue(r.Context(), utils.UserIDKey, userID)
-			next.ServeHTTP(w, r.WithContext(ctx))
-		})
-	}
-}
-
 func GetUserID(ctx context.Context) uint {
-	userID, ok := ctx.Value(utils.UserIDKey).(uint)
+	principal, ok := utils.PrincipalFromContext(ctx)
 	if !ok {
 		return 0
 	}
-	return userID
+	return principal.UserID
 }
 
 func enableCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
 
 		// Handle preflight request
 		if r.Method == http.MethodOptions {
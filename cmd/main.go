@@ -1,13 +1,18 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
 	"log"
+	"os"
 
 	"github.com/Brownei/api-generation-api/cmd/api"
 	"github.com/Brownei/api-generation-api/config"
 	"github.com/Brownei/api-generation-api/db"
+	"github.com/Brownei/api-generation-api/services"
 	"github.com/Brownei/api-generation-api/store"
 	"go.uber.org/zap"
+	"gorm.io/gorm"
 )
 
 func main() {
@@ -20,11 +25,67 @@ func main() {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
 
+	if len(os.Args) > 1 && os.Args[1] == "verify-audit-log" {
+		os.Exit(verifyAuditLog(database, cfg, sugarLogger))
+	}
+
 	store := store.NewStore(database, cfg, sugarLogger)
+
+	migrated, err := store.APIKeyService.MigratePlaintextKeys()
+	if err != nil {
+		sugarLogger.Fatalf("Failed to migrate plaintext API keys: %v", err)
+	}
+	if migrated > 0 {
+		sugarLogger.Infof("Hashed %d plaintext API keys", migrated)
+	}
+
+	converted, err := store.APIKeyService.MigrateKeyStatus()
+	if err != nil {
+		sugarLogger.Fatalf("Failed to migrate API key status: %v", err)
+	}
+	if converted > 0 {
+		sugarLogger.Infof("Converted %d revoked or expired API keys to the status column", converted)
+	}
+
+	backfilled, err := store.APIKeyService.BackfillScopes()
+	if err != nil {
+		sugarLogger.Fatalf("Failed to backfill API key scopes: %v", err)
+	}
+	if backfilled > 0 {
+		sugarLogger.Infof("Granted full scope to %d API keys created before scopes", backfilled)
+	}
+
+	if err := store.KeyRing.Load(); err != nil {
+		sugarLogger.Fatalf("Failed to load JWT signing keys: %v", err)
+	}
+	if !store.KeyRing.Enabled() && cfg.JWTSecret == "your-secret-key" {
+		sugarLogger.Warn("JWT_SECRET is the built-in default; set it or switch JWT_SIGNING_ALGORITHM to an asymmetric algorithm")
+	}
+
 	application := api.NewApplication(sugarLogger, cfg, database, store)
 
 	if err := application.Run(); err != nil {
 		sugarLogger.Fatalf("Failed to run the application: %v", err)
 	}
+}
+
+// verifyAuditLog walks the access log hash chain and prints the report.
+// The exit code is 0 when the chain is intact, 1 when it is broken and 2
+// when it couldn't be checked.
+func verifyAuditLog(database *gorm.DB, cfg *config.AppConfig, logger *zap.SugaredLogger) int {
+	// Verifying must not checkpoint the head it is judging.
+	auditLogService := services.NewAuditLogReader(database, cfg)
+
+	report, err := auditLogService.VerifyChain()
+	if err != nil {
+		logger.Errorf("Failed to verify the audit log: %v", err)
+		return 2
+	}
 
+	output, _ := json.MarshalIndent(report, "", "  ")
+	fmt.Println(string(output))
+	if !report.Valid {
+		return 1
+	}
+	return 0
 }
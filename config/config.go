@@ -3,6 +3,9 @@ package config
 import (
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -17,17 +20,126 @@ type AppConfig struct {
 	DBName     string
 	JWTSecret  string
 	ServerPort string
+	// Mixed into API key hashes; changing it invalidates every issued key.
+	APIKeyPepper string
+	// API key last-used timestamps are buffered and written this often.
+	APIKeyLastUsedFlushInterval time.Duration
+	// Validated API keys are cached for APIKeyCacheTTL and unknown ones for
+	// APIKeyNegativeCacheTTL, up to APIKeyCacheSize entries in all.
+	APIKeyCacheTTL         time.Duration
+	APIKeyNegativeCacheTTL time.Duration
+	APIKeyCacheSize        int
+	// How often keys past their expiry are marked expired.
+	APIKeyExpirySweepInterval time.Duration
+	// Signed requests are accepted this far either side of the server clock,
+	// and their nonces are remembered for as long.
+	SignatureMaxSkew time.Duration
+
+	// Default request budget per client, overridable per API key.
+	RateLimitRequests int
+	RateLimitWindow   time.Duration
+
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+	// Lifetime of tokens API keys get from the client_credentials grant.
+	OAuthTokenTTL time.Duration
+	// How long token revocation lookups are cached per instance.
+	TokenRevocationCacheTTL time.Duration
+
+	// HS256 signs with JWTSecret; RS256, ES256 and EdDSA use rotating key
+	// pairs published at /.well-known/jwks.json.
+	JWTSigningAlgorithm    string
+	JWTKeyRotationInterval time.Duration
+	// Encrypts secrets stored in the database, such as private signing
+	// keys. Falls back to JWTSecret when empty.
+	EncryptionKey string
+
+	// Access log entries wait in a queue of AuditLogQueueSize and are
+	// inserted AuditLogBatchSize at a time, or every AuditLogFlushInterval.
+	AuditLogQueueSize     int
+	AuditLogBatchSize     int
+	AuditLogFlushInterval time.Duration
+	// Access logs are hash chained; a checkpoint signed with AuditSigningKey
+	// (falling back to EncryptionKey, then JWTSecret) is written this often.
+	AuditCheckpointInterval time.Duration
+	AuditSigningKey         string
+	// Access logs older than AuditLogRetention are moved to gzipped NDJSON
+	// files under AuditArchiveDir, checked every AuditRetentionInterval.
+	AuditLogRetention      time.Duration
+	AuditRetentionInterval time.Duration
+	AuditArchiveDir        string
+	// How client IPs and user agents are stored in access logs: "full",
+	// "truncate" or "hash".
+	AuditIPMode        string
+	AuditUserAgentMode string
+
+	// How often access logs are rolled up into per-key usage buckets.
+	UsageAggregationInterval time.Duration
+
+	// Proxies (IPs or CIDRs) whose X-Forwarded-For is believed when
+	// resolving the client IP. Empty trusts nobody.
+	TrustedProxies []string
+
+	// The server speaks TLS when TLSCertFile and TLSKeyFile are set. With
+	// TLSClientCAFile it also asks for client certificates, verified
+	// against that PEM bundle; clients without one can still use other
+	// credentials.
+	TLSCertFile     string
+	TLSKeyFile      string
+	TLSClientCAFile string
 }
 
 func LoadAppConfig() *AppConfig {
 	return &AppConfig{
-		DBHost:     getEnv("DB_HOST", "localhost"),
-		DBPort:     getEnv("DB_PORT", "5432"),
-		DBUser:     getEnv("DB_USER", "db"),
-		DBPassword: getEnv("DB_PASSWORD", "db"),
-		DBName:     getEnv("DB_NAME", "db"),
-		JWTSecret:  getEnv("JWT_SECRET", "your-secret-key"),
-		ServerPort: getEnv("SERVER_PORT", "8080"),
+		DBHost:       getEnv("DB_HOST", "localhost"),
+		DBPort:       getEnv("DB_PORT", "5432"),
+		DBUser:       getEnv("DB_USER", "db"),
+		DBPassword:   getEnv("DB_PASSWORD", "db"),
+		DBName:       getEnv("DB_NAME", "db"),
+		JWTSecret:    getEnv("JWT_SECRET", "your-secret-key"),
+		ServerPort:   getEnv("SERVER_PORT", "8080"),
+		APIKeyPepper: getEnv("API_KEY_PEPPER", ""),
+
+		APIKeyLastUsedFlushInterval: getEnvDuration("API_KEY_LAST_USED_FLUSH_INTERVAL", 30*time.Second),
+		APIKeyCacheTTL:              getEnvDuration("API_KEY_CACHE_TTL", 30*time.Second),
+		APIKeyNegativeCacheTTL:      getEnvDuration("API_KEY_NEGATIVE_CACHE_TTL", 10*time.Second),
+		APIKeyCacheSize:             getEnvInt("API_KEY_CACHE_SIZE", 10000),
+		APIKeyExpirySweepInterval:   getEnvDuration("API_KEY_EXPIRY_SWEEP_INTERVAL", time.Minute),
+		SignatureMaxSkew:            getEnvDuration("SIGNATURE_MAX_SKEW", 5*time.Minute),
+
+		RateLimitRequests: getEnvInt("RATE_LIMIT_REQUESTS", 60),
+		RateLimitWindow:   getEnvDuration("RATE_LIMIT_WINDOW", time.Minute),
+
+		AccessTokenTTL:  getEnvDuration("ACCESS_TOKEN_TTL", 15*time.Minute),
+		RefreshTokenTTL: getEnvDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour),
+		OAuthTokenTTL:   getEnvDuration("OAUTH_TOKEN_TTL", 5*time.Minute),
+
+		TokenRevocationCacheTTL: getEnvDuration("TOKEN_REVOCATION_CACHE_TTL", 30*time.Second),
+
+		JWTSigningAlgorithm:    getEnv("JWT_SIGNING_ALGORITHM", "HS256"),
+		JWTKeyRotationInterval: getEnvDuration("JWT_KEY_ROTATION_INTERVAL", 30*24*time.Hour),
+		EncryptionKey:          getEnv("ENCRYPTION_KEY", ""),
+
+		AuditLogQueueSize:     getEnvInt("AUDIT_LOG_QUEUE_SIZE", 10000),
+		AuditLogBatchSize:     getEnvInt("AUDIT_LOG_BATCH_SIZE", 100),
+		AuditLogFlushInterval: getEnvDuration("AUDIT_LOG_FLUSH_INTERVAL", time.Second),
+
+		AuditCheckpointInterval: getEnvDuration("AUDIT_CHECKPOINT_INTERVAL", time.Hour),
+		AuditSigningKey:         getEnv("AUDIT_SIGNING_KEY", ""),
+
+		AuditLogRetention:      getEnvDuration("AUDIT_LOG_RETENTION", 90*24*time.Hour),
+		AuditRetentionInterval: getEnvDuration("AUDIT_RETENTION_INTERVAL", time.Hour),
+		AuditArchiveDir:        getEnv("AUDIT_ARCHIVE_DIR", "./archive/audit"),
+		AuditIPMode:            getEnv("AUDIT_IP_MODE", "full"),
+		AuditUserAgentMode:     getEnv("AUDIT_USER_AGENT_MODE", "full"),
+
+		UsageAggregationInterval: getEnvDuration("USAGE_AGGREGATION_INTERVAL", time.Minute),
+
+		TrustedProxies: getEnvList("TRUSTED_PROXIES"),
+
+		TLSCertFile:     getEnv("TLS_CERT_FILE", ""),
+		TLSKeyFile:      getEnv("TLS_KEY_FILE", ""),
+		TLSClientCAFile: getEnv("TLS_CLIENT_CA_FILE", ""),
 	}
 }
 
@@ -38,6 +150,35 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+func getEnvInt(key string, defaultValue int) int {
+	if value, exists := os.LookupEnv(key); exists {
+		if parsed, err := strconv.Atoi(value); err == nil {
+			return parsed
+		}
+	}
+	return defaultValue
+}
+
+// getEnvList splits a comma separated variable, dropping empty items.
+func getEnvList(key string) []string {
+	var items []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value, exists := os.LookupEnv(key); exists {
+		if parsed, err := time.ParseDuration(value); err == nil {
+			return parsed
+		}
+	}
+	return defaultValue
+}
+
 func (c *AppConfig) GetDSN() string {
 	return fmt.Sprintf(
 		"host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
@@ -17,12 +17,16 @@ import (
 
 type APIKeyController struct {
 	apiKeyService *services.APIKeyService
+	usageService  *services.UsageService
+	authService   *services.AuthService
 	logger        *zap.SugaredLogger
 }
 
-func NewAPIKeyController(apiKeyService *services.APIKeyService, logger *zap.SugaredLogger) *APIKeyController {
+func NewAPIKeyController(apiKeyService *services.APIKeyService, usageService *services.UsageService, authService *services.AuthService, logger *zap.SugaredLogger) *APIKeyController {
 	return &APIKeyController{
 		apiKeyService: apiKeyService,
+		usageService:  usageService,
+		authService:   authService,
 		logger:        logger,
 	}
 }
@@ -48,8 +52,31 @@ func (h *APIKeyController) CreateAPIKey(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	apiKey, err := h.apiKeyService.GenerateAPIKey(userID, req.Name, req.ExpiresIn)
+	// A restricted caller may only mint keys with scopes it holds itself.
+	requested := req.Scopes
+	if len(requested) == 0 {
+		requested = services.DefaultAPIKeyScopes
+	}
+	if scope, ok := missingScope(r, requested); ok {
+		h.respondWithError(w, http.StatusForbidden, "Cannot grant a scope the caller does not have: "+scope)
+		return
+	}
+
+	apiKey, err := h.apiKeyService.CreateAPIKey(userID, services.APIKeyOptions{
+		Name:              req.Name,
+		ExpiresIn:         req.ExpiresIn,
+		Scopes:            req.Scopes,
+		RateLimit:         req.RateLimit,
+		RateLimitWindow:   req.RateLimitWindow,
+		AllowedIPs:        req.AllowedIPs,
+		Signing:           req.Signing,
+		RequireClientCert: req.RequireClientCert,
+	})
 	if err != nil {
+		if errors.Is(err, services.ErrInvalidScope) || errors.Is(err, services.ErrInvalidAllowedIP) {
+			h.respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
 		if errors.Is(err, services.ErrTooManyAPIKeys) {
 			h.respondWithError(w, http.StatusForbidden, "Maximum number of active API keys (3) reached")
 			return
@@ -60,11 +87,18 @@ func (h *APIKeyController) CreateAPIKey(w http.ResponseWriter, r *http.Request)
 	}
 
 	response := dto.CreateAPIKeyResponse{
-		ID:        apiKey.ID,
-		Key:       apiKey.Key,
-		Name:      apiKey.Name,
-		ExpiresAt: apiKey.ExpiresAt,
-		CreatedAt: *apiKey.CreatedAt,
+		ID:                apiKey.ID,
+		Key:               apiKey.Key,
+		SigningSecret:     apiKey.SigningSecret,
+		Prefix:            apiKey.Prefix,
+		Name:              apiKey.Name,
+		Scopes:            apiKey.ScopeList(),
+		RateLimit:         apiKey.RateLimit,
+		RateLimitWindow:   apiKey.RateLimitWindow,
+		AllowedIPs:        apiKey.AllowedIPList(),
+		ExpiresAt:         apiKey.ExpiresAt,
+		CreatedAt:         *apiKey.CreatedAt,
+		RequireClientCert: apiKey.RequireClientCert,
 	}
 
 	h.respondWithJSON(w, http.StatusCreated, response)
@@ -79,13 +113,6 @@ func (h *APIKeyController) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
 	userID := userIDContext.(uint)
 	fmt.Printf("userId: %v", userID)
 
-	// First, check and update expired keys
-	if err := h.checkAndUpdateExpiredKeys(userID); err != nil {
-		h.logger.Error("Failed to check expired keys: ", err)
-		// Continue anyway - don't return error to user for this background operation
-	}
-
-	// Get all keys (now with updated expiry status)
 	keys, err := h.apiKeyService.ListAPIKeys(userID)
 	if err != nil {
 		h.logger.Error("Failed to list API keys: ", err)
@@ -96,50 +123,104 @@ func (h *APIKeyController) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
 	var response []dto.APIKeyResponse
 	for _, key := range keys {
 		response = append(response, dto.APIKeyResponse{
-			ID:         key.ID,
-			Name:       key.Name,
-			IsRevoked:  key.IsRevoked,
-			ExpiresAt:  key.ExpiresAt,
-			LastUsedAt: key.LastUsedAt,
-			CreatedAt:  *key.CreatedAt,
-			UpdatedAt:  *key.UpdatedAt,
+			ID:                key.ID,
+			Prefix:            key.Prefix,
+			Name:              key.Name,
+			Scopes:            key.ScopeList(),
+			RateLimit:         key.RateLimit,
+			RateLimitWindow:   key.RateLimitWindow,
+			AllowedIPs:        key.AllowedIPList(),
+			Signing:           key.RequiresSignature(),
+			RequireClientCert: key.RequireClientCert,
+			SuccessorID:       key.SuccessorID,
+			GraceExpiresAt:    key.GraceExpiresAt,
+			Status:            key.Status,
+			RevokedAt:         key.RevokedAt,
+			RevokedBy:         key.RevokedBy,
+			Reason:            key.RevocationReason,
+			ExpiresAt:         key.ExpiresAt,
+			LastUsedAt:        key.LastUsedAt,
+			CreatedAt:         *key.CreatedAt,
+			UpdatedAt:         *key.UpdatedAt,
 		})
 	}
 
 	utils.WriteJSON(w, http.StatusOK, response)
 }
 
-// Helper method to check and update expired keys
-func (h *APIKeyController) checkAndUpdateExpiredKeys(userID uint) error {
-	// Get all keys including expired ones
-	allKeys, err := h.apiKeyService.ListAPIKeys(userID) // You might need to add this method
+func (h *APIKeyController) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(utils.UserIDKey).(uint)
+
+	keyID, err := strconv.ParseUint(r.PathValue("id"), 10, 32)
 	if err != nil {
-		return err
+		h.respondWithError(w, http.StatusBadRequest, "Invalid API key ID")
+		return
 	}
 
-	now := time.Now()
-	var expiredKeys []uint
+	req, ok := h.parseRevokeRequest(w, r)
+	if !ok {
+		return
+	}
 
-	for _, key := range allKeys {
-		// Check if key is expired but not revoked
-		if key.ExpiresAt != nil && key.ExpiresAt.Before(now) && !key.IsRevoked {
-			expiredKeys = append(expiredKeys, key.ID)
+	err = h.apiKeyService.RevokeAPIKey(userID, uint(keyID), req.Reason)
+	if err != nil {
+		if errors.Is(err, services.ErrAPIKeyNotFound) {
+			h.respondWithError(w, http.StatusNotFound, "API key not found")
+			return
+		}
+		if errors.Is(err, services.ErrAPIKeyNotActive) {
+			h.respondWithError(w, http.StatusConflict, err.Error())
+			return
 		}
+		h.logger.Error("Failed to revoke API key: ", err)
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to revoke API key")
+		return
+	}
+
+	h.respondWithJSON(w, http.StatusOK, map[string]string{"message": "API key revoked successfully"})
+}
+
+// SuspendAPIKey disables any user's key until it is reactivated. Admins only.
+func (h *APIKeyController) SuspendAPIKey(w http.ResponseWriter, r *http.Request) {
+	adminID, ok := h.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	keyID, err := strconv.ParseUint(r.PathValue("id"), 10, 32)
+	if err != nil {
+		h.respondWithError(w, http.StatusBadRequest, "Invalid API key ID")
+		return
+	}
+
+	req, ok := h.parseRevokeRequest(w, r)
+	if !ok {
+		return
 	}
 
-	// Update expired keys if any found
-	if len(expiredKeys) > 0 {
-		if err := h.apiKeyService.RevokeExpiredKeys(expiredKeys); err != nil {
-			return err
+	err = h.apiKeyService.SuspendAPIKey(adminID, uint(keyID), req.Reason)
+	if err != nil {
+		if errors.Is(err, services.ErrAPIKeyNotFound) {
+			h.respondWithError(w, http.StatusNotFound, "API key not found")
+			return
 		}
-		h.logger.Infof("Revoked %d expired keys for user %d", len(expiredKeys), userID)
+		if errors.Is(err, services.ErrAPIKeyNotActive) {
+			h.respondWithError(w, http.StatusConflict, err.Error())
+			return
+		}
+		h.logger.Error("Failed to suspend API key: ", err)
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to suspend API key")
+		return
 	}
 
-	return nil
+	h.respondWithJSON(w, http.StatusOK, map[string]string{"message": "API key suspended successfully"})
 }
 
-func (h *APIKeyController) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
-	userID := r.Context().Value(utils.UserIDKey).(uint)
+// ReactivateAPIKey lifts a suspension. Admins only.
+func (h *APIKeyController) ReactivateAPIKey(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.requireAdmin(w, r); !ok {
+		return
+	}
 
 	keyID, err := strconv.ParseUint(r.PathValue("id"), 10, 32)
 	if err != nil {
@@ -147,18 +228,56 @@ func (h *APIKeyController) RevokeAPIKey(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = h.apiKeyService.RevokeAPIKey(userID, uint(keyID))
+	err = h.apiKeyService.ReactivateAPIKey(uint(keyID))
 	if err != nil {
 		if errors.Is(err, services.ErrAPIKeyNotFound) {
 			h.respondWithError(w, http.StatusNotFound, "API key not found")
 			return
 		}
-		h.logger.Error("Failed to revoke API key: ", err)
-		h.respondWithError(w, http.StatusInternalServerError, "Failed to revoke API key")
+		if errors.Is(err, services.ErrAPIKeyNotSuspended) || errors.Is(err, services.ErrTooManyAPIKeys) {
+			h.respondWithError(w, http.StatusConflict, err.Error())
+			return
+		}
+		h.logger.Error("Failed to reactivate API key: ", err)
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to reactivate API key")
 		return
 	}
 
-	h.respondWithJSON(w, http.StatusOK, map[string]string{"message": "API key revoked successfully"})
+	h.respondWithJSON(w, http.StatusOK, map[string]string{"message": "API key reactivated successfully"})
+}
+
+// parseRevokeRequest reads the optional reason from the body or, since the
+// revoke route is a GET, the reason query parameter.
+func (h *APIKeyController) parseRevokeRequest(w http.ResponseWriter, r *http.Request) (dto.RevokeAPIKeyRequest, bool) {
+	req := dto.RevokeAPIKeyRequest{Reason: r.URL.Query().Get("reason")}
+	if r.ContentLength != 0 {
+		if err := utils.ParseJSON(r, &req); err != nil {
+			h.respondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return req, false
+		}
+	}
+
+	if details := validation.ValidateStruct(req); len(details) > 0 {
+		h.respondWithValidationError(w, details)
+		return req, false
+	}
+	return req, true
+}
+
+func (h *APIKeyController) requireAdmin(w http.ResponseWriter, r *http.Request) (uint, bool) {
+	userID := r.Context().Value(utils.UserIDKey).(uint)
+
+	user, err := h.authService.GetUserByID(userID)
+	if err != nil {
+		h.logger.Error("Failed to load user for API key administration: ", err)
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to load user")
+		return 0, false
+	}
+	if !user.IsAdmin {
+		h.respondWithError(w, http.StatusForbidden, "Admin access required")
+		return 0, false
+	}
+	return userID, true
 }
 
 func (h *APIKeyController) RotateAPIKey(w http.ResponseWriter, r *http.Request) {
@@ -170,7 +289,22 @@ func (h *APIKeyController) RotateAPIKey(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	apiKey, err := h.apiKeyService.RotateAPIKey(userID, uint(keyID))
+	var req dto.RotateAPIKeyRequest
+	if r.ContentLength != 0 {
+		if err := utils.ParseJSON(r, &req); err != nil {
+			h.respondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+
+	if details := validation.ValidateStruct(req); len(details) > 0 {
+		h.respondWithValidationError(w, details)
+		return
+	}
+
+	// The successor's plaintext is returned, so as when creating a key the
+	// caller must hold every scope it gets.
+	current, err := h.apiKeyService.GetAPIKey(userID, uint(keyID))
 	if err != nil {
 		if errors.Is(err, services.ErrAPIKeyNotFound) {
 			h.respondWithError(w, http.StatusNotFound, "API key not found")
@@ -180,18 +314,154 @@ func (h *APIKeyController) RotateAPIKey(w http.ResponseWriter, r *http.Request)
 		h.respondWithError(w, http.StatusInternalServerError, "Failed to rotate API key")
 		return
 	}
+	if scope, ok := missingScope(r, current.ScopeList()); ok {
+		h.respondWithError(w, http.StatusForbidden, "Cannot grant a scope the caller does not have: "+scope)
+		return
+	}
 
-	response := dto.CreateAPIKeyResponse{
-		ID:        apiKey.ID,
-		Key:       apiKey.Key,
-		Name:      apiKey.Name,
-		ExpiresAt: apiKey.ExpiresAt,
-		CreatedAt: *apiKey.CreatedAt,
+	gracePeriod := time.Duration(req.GracePeriod) * time.Second
+	apiKey, err := h.apiKeyService.RotateAPIKey(userID, uint(keyID), gracePeriod)
+	if err != nil {
+		if errors.Is(err, services.ErrAPIKeyNotFound) {
+			h.respondWithError(w, http.StatusNotFound, "API key not found")
+			return
+		}
+		if errors.Is(err, services.ErrAPIKeyRevoked) || errors.Is(err, services.ErrAPIKeyExpired) ||
+			errors.Is(err, services.ErrAPIKeySuspended) || errors.Is(err, services.ErrAPIKeyAlreadyRotated) {
+			h.respondWithError(w, http.StatusConflict, err.Error())
+			return
+		}
+		h.logger.Error("Failed to rotate API key: ", err)
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to rotate API key")
+		return
+	}
+
+	previousKeyExpiresAt := time.Now().Add(gracePeriod)
+	response := dto.RotateAPIKeyResponse{
+		CreateAPIKeyResponse: dto.CreateAPIKeyResponse{
+			ID:                apiKey.ID,
+			Key:               apiKey.Key,
+			SigningSecret:     apiKey.SigningSecret,
+			Prefix:            apiKey.Prefix,
+			Name:              apiKey.Name,
+			Scopes:            apiKey.ScopeList(),
+			RateLimit:         apiKey.RateLimit,
+			RateLimitWindow:   apiKey.RateLimitWindow,
+			AllowedIPs:        apiKey.AllowedIPList(),
+			ExpiresAt:         apiKey.ExpiresAt,
+			CreatedAt:         *apiKey.CreatedAt,
+			RequireClientCert: apiKey.RequireClientCert,
+		},
+		RotatedFromID:        uint(keyID),
+		PreviousKeyExpiresAt: &previousKeyExpiresAt,
 	}
 
 	h.respondWithJSON(w, http.StatusOK, response)
 }
 
+// UpdateAllowedIPs replaces the IP allowlist of a key; the key itself is
+// unchanged.
+func (h *APIKeyController) UpdateAllowedIPs(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(utils.UserIDKey).(uint)
+
+	keyID, err := strconv.ParseUint(r.PathValue("id"), 10, 32)
+	if err != nil {
+		h.respondWithError(w, http.StatusBadRequest, "Invalid API key ID")
+		return
+	}
+
+	var req dto.UpdateAllowedIPsRequest
+	if err := utils.ParseJSON(r, &req); err != nil {
+		h.respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if details := validation.ValidateStruct(req); len(details) > 0 {
+		h.respondWithValidationError(w, details)
+		return
+	}
+
+	apiKey, err := h.apiKeyService.SetAllowedIPs(userID, uint(keyID), req.AllowedIPs)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidAllowedIP) {
+			h.respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if errors.Is(err, services.ErrAPIKeyNotFound) {
+			h.respondWithError(w, http.StatusNotFound, "API key not found")
+			return
+		}
+		if errors.Is(err, services.ErrAPIKeyRevoked) || errors.Is(err, services.ErrAPIKeyExpired) ||
+			errors.Is(err, services.ErrAPIKeySuspended) {
+			h.respondWithError(w, http.StatusConflict, err.Error())
+			return
+		}
+		h.logger.Error("Failed to update API key allowed IPs: ", err)
+		h.respondWithError(w, http.StatusInternalServerError, "Failed to update API key allowed IPs")
+		return
+	}
+
+	h.respondWithJSON(w, http.StatusOK, map[string][]string{"allowed_ips": apiKey.AllowedIPList()})
+}
+
+// GetAPIKeyUsage reports a key's request counts, errors, latency
+// percentiles and busiest endpoints. Without a range it covers the last day
+// hourly or the last 30 days daily.
+func (h *APIKeyController) GetAPIKeyUsage(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(utils.UserIDKey).(uint)
+
+	keyID, err := strconv.ParseUint(r.PathValue("id"), 10, 32)
+	if err != nil {
+		h.respondWithError(w, http.StatusBadRequest, "Invalid API key ID")
+		return
+	}
+
+	values := r.URL.Query()
+	granularity := values.Get("granularity")
+	if granularity == "" {
+		granularity = services.UsageGranularityHour
+	}
+	from, err := parseOptionalTime(values, "from")
+	if err != nil {
+		h.respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	to, err := parseOptionalTime(values, "to")
+	if err != nil {
+		h.respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if to == nil {
+		now := time.Now()
+		to = &now
+	}
+	if from == nil {
+		start := to.Add(-24 * time.Hour)
+		if granularity == services.UsageGranularityDay {
+			start = to.Add(-30 * 24 * time.Hour)
+		}
+		from = &start
+	}
+
+	report, err := h.usageService.Usage(userID, uint(keyID), *from, *to, granularity)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrAPIKeyNotFound):
+			h.respondWithError(w, http.StatusNotFound, "API key not found")
+		case errors.Is(err, services.ErrInvalidUsageGranularity),
+			errors.Is(err, services.ErrInvalidUsageRange),
+			errors.Is(err, services.ErrUsageRangeTooLarge):
+			h.respondWithError(w, http.StatusBadRequest, err.Error())
+		default:
+			h.logger.Error("Failed to load API key usage: ", err)
+			h.respondWithError(w, http.StatusInternalServerError, "Failed to load API key usage")
+		}
+		return
+	}
+
+	h.respondWithJSON(w, http.StatusOK, report)
+}
+
 func (h *APIKeyController) respondWithError(w http.ResponseWriter, code int, message string) {
 	utils.WriteJSON(w, code, dto.ErrorResponse{Error: "error", Message: message})
 }
@@ -210,3 +480,18 @@ func (h *APIKeyController) respondWithJSON(w http.ResponseWriter, code int, payl
 		json.NewEncoder(w).Encode(payload)
 	}
 }
+
+// missingScope returns the first of scopes the request's principal lacks,
+// if it is restricted at all.
+func missingScope(r *http.Request, scopes []string) (string, bool) {
+	caller, ok := utils.PrincipalFromContext(r.Context())
+	if !ok {
+		return "", false
+	}
+	for _, scope := range scopes {
+		if !caller.HasScope(scope) {
+			return scope, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,297 @@
+package controllers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/Brownei/api-generation-api/db"
+	"github.com/Brownei/api-generation-api/dto"
+	"github.com/Brownei/api-generation-api/services"
+	"github.com/Brownei/api-generation-api/utils"
+	"go.uber.org/zap"
+)
+
+type AuditLogController struct {
+	auditLogService *services.AuditLogService
+	authService     *services.AuthService
+	logger          *zap.SugaredLogger
+}
+
+func NewAuditLogController(auditLogService *services.AuditLogService, authService *services.AuthService, logger *zap.SugaredLogger) *AuditLogController {
+	return &AuditLogController{
+		auditLogService: auditLogService,
+		authService:     authService,
+		logger:          logger,
+	}
+}
+
+// ListAuditLogs returns the caller's access log entries, or everyone's for
+// admins, filtered by the query string and paginated by cursor.
+func (h *AuditLogController) ListAuditLogs(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(utils.UserIDKey).(uint)
+
+	user, err := h.authService.GetUserByID(userID)
+	if err != nil {
+		h.logger.Error("Failed to load user for audit query: ", err)
+		utils.WriteError(w, http.StatusInternalServerError, errors.New("Failed to list audit logs"))
+		return
+	}
+
+	query, err := parseAuditLogQuery(r.URL.Query())
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if !user.IsAdmin {
+		query.UserID = &userID
+	}
+
+	logs, next, err := h.auditLogService.QueryLogs(query)
+	if err != nil {
+		h.logger.Error("Failed to query audit logs: ", err)
+		utils.WriteError(w, http.StatusInternalServerError, errors.New("Failed to list audit logs"))
+		return
+	}
+
+	response := dto.AuditLogListResponse{Data: make([]dto.AuditLogResponse, 0, len(logs))}
+	for i := range logs {
+		response.Data = append(response.Data, auditLogResponse(&logs[i]))
+	}
+	if next != 0 {
+		response.NextCursor = strconv.FormatUint(uint64(next), 10)
+	}
+
+	utils.WriteJSON(w, http.StatusOK, response)
+}
+
+// ExportAuditLogs streams the entries matching the same filters as
+// ListAuditLogs, oldest first, as CSV or NDJSON (the default).
+func (h *AuditLogController) ExportAuditLogs(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(utils.UserIDKey).(uint)
+
+	user, err := h.authService.GetUserByID(userID)
+	if err != nil {
+		h.logger.Error("Failed to load user for audit export: ", err)
+		utils.WriteError(w, http.StatusInternalServerError, errors.New("Failed to export audit logs"))
+		return
+	}
+
+	query, err := parseAuditLogQuery(r.URL.Query())
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if !user.IsAdmin {
+		query.UserID = &userID
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "ndjson"
+	}
+	var contentType string
+	switch format {
+	case "csv":
+		contentType = "text/csv"
+	case "ndjson":
+		contentType = "application/x-ndjson"
+	default:
+		utils.WriteError(w, http.StatusBadRequest, errors.New("format must be csv or ndjson"))
+		return
+	}
+
+	// Large exports outlast the server's write timeout.
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		h.logger.Warn("Failed to lift write deadline for audit export: ", err)
+	}
+
+	filename := fmt.Sprintf("audit-logs-%s.%s", time.Now().UTC().Format("20060102T150405Z"), format)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.WriteHeader(http.StatusOK)
+
+	var write func(*db.AccessLogs) error
+	var flush func() error
+	if format == "csv" {
+		writer := csv.NewWriter(w)
+		writer.Write(auditLogCSVHeader)
+		write = func(log *db.AccessLogs) error {
+			return writer.Write(auditLogCSVRecord(log))
+		}
+		flush = func() error {
+			writer.Flush()
+			return writer.Error()
+		}
+	} else {
+		encoder := json.NewEncoder(w)
+		write = func(log *db.AccessLogs) error {
+			response := auditLogResponse(log)
+			return encoder.Encode(&response)
+		}
+		flush = func() error { return nil }
+	}
+
+	// The status is already sent, so a failure can only cut the body short.
+	if err := h.auditLogService.StreamLogs(query, write); err != nil {
+		h.logger.Error("Audit log export failed: ", err)
+	}
+	if err := flush(); err != nil {
+		h.logger.Error("Audit log export failed: ", err)
+	}
+}
+
+// VerifyAuditChain walks the access log hash chain. Admins only.
+func (h *AuditLogController) VerifyAuditChain(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(utils.UserIDKey).(uint)
+
+	user, err := h.authService.GetUserByID(userID)
+	if err != nil {
+		h.logger.Error("Failed to load user for audit verification: ", err)
+		utils.WriteError(w, http.StatusInternalServerError, errors.New("Failed to verify audit logs"))
+		return
+	}
+	if !user.IsAdmin {
+		utils.WriteError(w, http.StatusForbidden, errors.New("Only admins can verify the audit log"))
+		return
+	}
+
+	report, err := h.auditLogService.VerifyChain()
+	if err != nil {
+		h.logger.Error("Failed to verify audit chain: ", err)
+		utils.WriteError(w, http.StatusInternalServerError, errors.New("Failed to verify audit logs"))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, report)
+}
+
+var auditLogCSVHeader = []string{
+	"id", "timestamp", "user_id", "auth_method", "api_key_id", "request_id",
+	"method", "path", "route", "status_code", "response_size", "ip_address",
+	"user_agent", "duration",
+}
+
+func auditLogCSVRecord(log *db.AccessLogs) []string {
+	apiKeyID := ""
+	if log.APIKeyID != nil {
+		apiKeyID = strconv.FormatUint(uint64(*log.APIKeyID), 10)
+	}
+	return []string{
+		strconv.FormatUint(uint64(log.ID), 10),
+		log.Timestamp.UTC().Format(time.RFC3339Nano),
+		strconv.FormatUint(uint64(log.UserID), 10),
+		log.AuthMethod,
+		apiKeyID,
+		log.RequestID,
+		log.Method,
+		log.Path,
+		log.Route,
+		strconv.Itoa(log.StatusCode),
+		strconv.FormatInt(log.ResponseSize, 10),
+		log.IPAddress,
+		log.UserAgent,
+		strconv.FormatInt(log.Duration, 10),
+	}
+}
+
+func auditLogResponse(log *db.AccessLogs) dto.AuditLogResponse {
+	return dto.AuditLogResponse{
+		ID:           log.ID,
+		UserID:       log.UserID,
+		AuthMethod:   log.AuthMethod,
+		APIKeyID:     log.APIKeyID,
+		RequestID:    log.RequestID,
+		Method:       log.Method,
+		Path:         log.Path,
+		Route:        log.Route,
+		StatusCode:   log.StatusCode,
+		ResponseSize: log.ResponseSize,
+		IPAddress:    log.IPAddress,
+		UserAgent:    log.UserAgent,
+		Duration:     log.Duration,
+		Timestamp:    log.Timestamp,
+	}
+}
+
+func parseAuditLogQuery(values url.Values) (services.AuditLogQuery, error) {
+	query := services.AuditLogQuery{
+		RequestID:  values.Get("request_id"),
+		Method:     values.Get("method"),
+		PathPrefix: values.Get("path_prefix"),
+	}
+
+	var err error
+	if query.UserID, err = parseOptionalUint(values, "user_id"); err != nil {
+		return query, err
+	}
+	if query.APIKeyID, err = parseOptionalUint(values, "api_key_id"); err != nil {
+		return query, err
+	}
+	if query.StatusMin, err = parseOptionalInt(values, "status_min"); err != nil {
+		return query, err
+	}
+	if query.StatusMax, err = parseOptionalInt(values, "status_max"); err != nil {
+		return query, err
+	}
+	if query.Limit, err = parseOptionalInt(values, "limit"); err != nil {
+		return query, err
+	}
+	if query.From, err = parseOptionalTime(values, "from"); err != nil {
+		return query, err
+	}
+	if query.To, err = parseOptionalTime(values, "to"); err != nil {
+		return query, err
+	}
+	cursor, err := parseOptionalUint(values, "cursor")
+	if err != nil {
+		return query, err
+	}
+	if cursor != nil {
+		query.Cursor = *cursor
+	}
+
+	return query, nil
+}
+
+func parseOptionalUint(values url.Values, name string) (*uint, error) {
+	raw := values.Get(name)
+	if raw == "" {
+		return nil, nil
+	}
+	parsed, err := strconv.ParseUint(raw, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s", name)
+	}
+	value := uint(parsed)
+	return &value, nil
+}
+
+func parseOptionalInt(values url.Values, name string) (int, error) {
+	raw := values.Get(name)
+	if raw == "" {
+		return 0, nil
+	}
+	parsed, err := strconv.Atoi(raw)
+	if err != nil || parsed < 0 {
+		return 0, fmt.Errorf("invalid %s", name)
+	}
+	return parsed, nil
+}
+
+func parseOptionalTime(values url.Values, name string) (*time.Time, error) {
+	raw := values.Get(name)
+	if raw == "" {
+		return nil, nil
+	}
+	parsed, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s, expected RFC 3339", name)
+	}
+	return &parsed, nil
+}
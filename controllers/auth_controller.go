@@ -4,11 +4,14 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/Brownei/api-generation-api/dto"
 	"github.com/Brownei/api-generation-api/services"
 	"github.com/Brownei/api-generation-api/types"
 	"github.com/Brownei/api-generation-api/utils"
+	"github.com/Brownei/api-generation-api/validation"
 	"go.uber.org/zap"
 )
 
@@ -56,6 +59,13 @@ func (a *AuthController) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	_, refreshToken, err := a.authService.CreateSession(existingUser.ID, r.UserAgent(), utils.ClientIP(r))
+	if err != nil {
+		a.logger.Error("Failed to create session: ", err)
+		utils.WriteError(w, http.StatusInternalServerError, errors.New("Failed to create session"))
+		return
+	}
+
 	// cookie := &http.Cookie{
 	// 	Name:     "auth_user_token",
 	// 	Value:    token,
@@ -68,7 +78,163 @@ func (a *AuthController) Login(w http.ResponseWriter, r *http.Request) {
 	//
 	// http.SetCookie(w, cookie)
 	//
-	utils.WriteJSON(w, 200, token)
+	utils.WriteJSON(w, 200, a.tokenResponse(token, refreshToken))
+}
+
+func (a *AuthController) Refresh(w http.ResponseWriter, r *http.Request) {
+	var req dto.RefreshTokenRequest
+	if err := utils.ParseJSON(r, &req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, errors.New("Cannot parse the data correctly"))
+		return
+	}
+	if details := validation.ValidateStruct(req); len(details) > 0 {
+		utils.WriteJSON(w, http.StatusBadRequest, validation.ValidationErrorResponse{Error: "validation error", Details: details})
+		return
+	}
+
+	session, refreshToken, err := a.authService.RefreshSession(req.RefreshToken)
+	if err != nil {
+		if a.isSessionError(err) {
+			utils.WriteError(w, http.StatusUnauthorized, err)
+			return
+		}
+		a.logger.Error("Failed to refresh session: ", err)
+		utils.WriteError(w, http.StatusInternalServerError, errors.New("Failed to refresh session"))
+		return
+	}
+
+	user, err := a.authService.GetUserByID(session.UserID)
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	token, err := a.authService.GenerateToken(user.ID, user.Email)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, a.tokenResponse(token, refreshToken))
+}
+
+func (a *AuthController) Logout(w http.ResponseWriter, r *http.Request) {
+	var req dto.RefreshTokenRequest
+	if err := utils.ParseJSON(r, &req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, errors.New("Cannot parse the data correctly"))
+		return
+	}
+	if details := validation.ValidateStruct(req); len(details) > 0 {
+		utils.WriteJSON(w, http.StatusBadRequest, validation.ValidationErrorResponse{Error: "validation error", Details: details})
+		return
+	}
+
+	if err := a.authService.RevokeSessionByToken(req.RefreshToken); err != nil {
+		if a.isSessionError(err) {
+			utils.WriteError(w, http.StatusUnauthorized, err)
+			return
+		}
+		a.logger.Error("Failed to log out: ", err)
+		utils.WriteError(w, http.StatusInternalServerError, errors.New("Failed to log out"))
+		return
+	}
+
+	// Also kill the access token the client is holding, if it sent one.
+	if accessToken, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); found {
+		if claims, err := a.authService.ValidateToken(accessToken); err == nil {
+			if err := a.authService.RevokeToken(claims); err != nil {
+				a.logger.Error("Failed to revoke access token: ", err)
+			}
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *AuthController) LogoutAll(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(utils.UserIDKey).(uint)
+
+	if err := a.authService.RevokeAllTokens(userID); err != nil {
+		if errors.Is(err, types.ErrUserNotFound) {
+			utils.WriteError(w, http.StatusNotFound, err)
+			return
+		}
+		a.logger.Error("Failed to revoke tokens: ", err)
+		utils.WriteError(w, http.StatusInternalServerError, errors.New("Failed to log out everywhere"))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *AuthController) ListSessions(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(utils.UserIDKey).(uint)
+
+	sessions, err := a.authService.ListSessions(userID)
+	if err != nil {
+		a.logger.Error("Failed to list sessions: ", err)
+		utils.WriteError(w, http.StatusInternalServerError, errors.New("Failed to list sessions"))
+		return
+	}
+
+	response := make([]dto.SessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		response = append(response, dto.SessionResponse{
+			ID:         session.ID,
+			UserAgent:  session.UserAgent,
+			IPAddress:  session.IPAddress,
+			ExpiresAt:  session.ExpiresAt,
+			LastUsedAt: session.LastUsedAt,
+			CreatedAt:  session.CreatedAt,
+		})
+	}
+
+	utils.WriteJSON(w, http.StatusOK, response)
+}
+
+func (a *AuthController) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(utils.UserIDKey).(uint)
+
+	sessionID, err := strconv.ParseUint(r.PathValue("id"), 10, 32)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, errors.New("Invalid session ID"))
+		return
+	}
+
+	if err := a.authService.RevokeSession(userID, uint(sessionID)); err != nil {
+		if errors.Is(err, services.ErrSessionNotFound) {
+			utils.WriteError(w, http.StatusNotFound, err)
+			return
+		}
+		a.logger.Error("Failed to revoke session: ", err)
+		utils.WriteError(w, http.StatusInternalServerError, errors.New("Failed to revoke session"))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// JWKS publishes the public signing keys so other services can verify our
+// tokens. The list is empty while tokens are signed with HS256.
+func (a *AuthController) JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	utils.WriteJSON(w, http.StatusOK, dto.JWKSResponse{Keys: a.authService.KeyRing().JWKS()})
+}
+
+func (a *AuthController) tokenResponse(accessToken, refreshToken string) dto.TokenResponse {
+	return dto.TokenResponse{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(a.authService.AccessTokenTTL().Seconds()),
+	}
+}
+
+func (a *AuthController) isSessionError(err error) bool {
+	return errors.Is(err, services.ErrInvalidRefreshToken) ||
+		errors.Is(err, services.ErrRefreshTokenReused) ||
+		errors.Is(err, services.ErrSessionRevoked) ||
+		errors.Is(err, services.ErrSessionExpired)
 }
 
 func (a *AuthController) Register(w http.ResponseWriter, r *http.Request) {
@@ -112,6 +278,13 @@ func (a *AuthController) Register(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 
+			_, refreshToken, err := a.authService.CreateSession(newUser.ID, r.UserAgent(), utils.ClientIP(r))
+			if err != nil {
+				a.logger.Error("Failed to create session: ", err)
+				utils.WriteError(w, http.StatusInternalServerError, errors.New("Failed to create session"))
+				return
+			}
+
 			// cookie := &http.Cookie{
 			// 	Name:     "auth_user_token",
 			// 	Value:    token,
@@ -124,7 +297,7 @@ func (a *AuthController) Register(w http.ResponseWriter, r *http.Request) {
 			//
 			// http.SetCookie(w, cookie)
 
-			utils.WriteJSON(w, 200, []byte(token))
+			utils.WriteJSON(w, 200, a.tokenResponse(token, refreshToken))
 			return
 		}
 
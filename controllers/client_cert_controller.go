@@ -0,0 +1,150 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/Brownei/api-generation-api/db"
+	"github.com/Brownei/api-generation-api/dto"
+	"github.com/Brownei/api-generation-api/middleware"
+	"github.com/Brownei/api-generation-api/services"
+	"github.com/Brownei/api-generation-api/utils"
+	"github.com/Brownei/api-generation-api/validation"
+	"go.uber.org/zap"
+)
+
+type ClientCertController struct {
+	clientCertService *services.ClientCertService
+	apiKeyService     *services.APIKeyService
+	logger            *zap.SugaredLogger
+}
+
+func NewClientCertController(clientCertService *services.ClientCertService, apiKeyService *services.APIKeyService, logger *zap.SugaredLogger) *ClientCertController {
+	return &ClientCertController{
+		clientCertService: clientCertService,
+		apiKeyService:     apiKeyService,
+		logger:            logger,
+	}
+}
+
+// RegisterClientCert registers the TLS client certificate presented on
+// this request's connection for the caller, optionally bound to one of
+// their API keys.
+func (h *ClientCertController) RegisterClientCert(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(utils.UserIDKey).(uint)
+
+	var req dto.RegisterClientCertRequest
+	if err := utils.ParseJSON(r, &req); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, errors.New("Invalid request body"))
+		return
+	}
+
+	if details := validation.ValidateStruct(req); len(details) > 0 {
+		utils.WriteJSON(w, http.StatusBadRequest, validation.ValidationErrorResponse{
+			Error:   "validation error",
+			Details: details,
+		})
+		return
+	}
+
+	// As with keys, a restricted caller may only grant scopes it holds,
+	// including those of a key the certificate will authenticate as.
+	requested := req.Scopes
+	if len(requested) == 0 {
+		requested = services.DefaultAPIKeyScopes
+	}
+	if req.APIKeyID != nil {
+		apiKey, err := h.apiKeyService.GetAPIKey(userID, *req.APIKeyID)
+		if err != nil {
+			if errors.Is(err, services.ErrAPIKeyNotFound) {
+				utils.WriteError(w, http.StatusNotFound, err)
+				return
+			}
+			h.logger.Error("Failed to register client certificate: ", err)
+			utils.WriteError(w, http.StatusInternalServerError, errors.New("Failed to register client certificate"))
+			return
+		}
+		requested = apiKey.ScopeList()
+	}
+	if scope, ok := missingScope(r, requested); ok {
+		utils.WriteError(w, http.StatusForbidden, errors.New("Cannot grant a scope the caller does not have: "+scope))
+		return
+	}
+
+	cert, err := h.clientCertService.Register(userID, services.ClientCertOptions{
+		Name:        req.Name,
+		Certificate: middleware.ClientCertificate(r),
+		APIKeyID:    req.APIKeyID,
+		Scopes:      req.Scopes,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrClientCertNotPresented), errors.Is(err, services.ErrInvalidScope):
+			utils.WriteError(w, http.StatusBadRequest, err)
+		case errors.Is(err, services.ErrAPIKeyNotFound):
+			utils.WriteError(w, http.StatusNotFound, err)
+		case errors.Is(err, services.ErrClientCertExists):
+			utils.WriteError(w, http.StatusConflict, err)
+		default:
+			h.logger.Error("Failed to register client certificate: ", err)
+			utils.WriteError(w, http.StatusInternalServerError, errors.New("Failed to register client certificate"))
+		}
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, clientCertResponse(cert))
+}
+
+func (h *ClientCertController) ListClientCerts(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(utils.UserIDKey).(uint)
+
+	certs, err := h.clientCertService.List(userID)
+	if err != nil {
+		h.logger.Error("Failed to list client certificates: ", err)
+		utils.WriteError(w, http.StatusInternalServerError, errors.New("Failed to list client certificates"))
+		return
+	}
+
+	response := make([]dto.ClientCertResponse, 0, len(certs))
+	for i := range certs {
+		response = append(response, clientCertResponse(&certs[i]))
+	}
+	utils.WriteJSON(w, http.StatusOK, response)
+}
+
+func (h *ClientCertController) DeleteClientCert(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(utils.UserIDKey).(uint)
+
+	certID, err := strconv.ParseUint(r.PathValue("id"), 10, 32)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, errors.New("Invalid client certificate ID"))
+		return
+	}
+
+	if err := h.clientCertService.Delete(userID, uint(certID)); err != nil {
+		if errors.Is(err, services.ErrClientCertNotFound) {
+			utils.WriteError(w, http.StatusNotFound, err)
+			return
+		}
+		h.logger.Error("Failed to delete client certificate: ", err)
+		utils.WriteError(w, http.StatusInternalServerError, errors.New("Failed to delete client certificate"))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]string{"message": "Client certificate deleted successfully"})
+}
+
+func clientCertResponse(cert *db.ClientCertificate) dto.ClientCertResponse {
+	return dto.ClientCertResponse{
+		ID:          cert.ID,
+		Name:        cert.Name,
+		Fingerprint: cert.Fingerprint,
+		Subject:     cert.Subject,
+		APIKeyID:    cert.APIKeyID,
+		Scopes:      cert.ScopeList(),
+		NotAfter:    cert.NotAfter,
+		LastUsedAt:  cert.LastUsedAt,
+		CreatedAt:   cert.CreatedAt,
+	}
+}
@@ -0,0 +1,163 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/Brownei/api-generation-api/dto"
+	"github.com/Brownei/api-generation-api/middleware"
+	"github.com/Brownei/api-generation-api/services"
+	"github.com/Brownei/api-generation-api/utils"
+	"go.uber.org/zap"
+)
+
+type OAuthController struct {
+	authService          *services.AuthService
+	apiKeyService        *services.APIKeyService
+	introspectionService *services.IntrospectionService
+	logger               *zap.SugaredLogger
+}
+
+func NewOAuthController(authService *services.AuthService, apiKeyService *services.APIKeyService, introspectionService *services.IntrospectionService, logger *zap.SugaredLogger) *OAuthController {
+	return &OAuthController{
+		authService:          authService,
+		apiKeyService:        apiKeyService,
+		introspectionService: introspectionService,
+		logger:               logger,
+	}
+}
+
+// Token implements the client_credentials grant of RFC 6749, section 4.4.
+// The client authenticates with an API key, as HTTP Basic credentials or as
+// client_id and client_secret form fields, and gets a short-lived access
+// token that acts as the key.
+func (h *OAuthController) Token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return
+	}
+
+	grantType := r.PostForm.Get("grant_type")
+	if grantType == "" {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "grant_type is required")
+		return
+	}
+	if grantType != services.GrantTypeClientCredentials {
+		writeOAuthError(w, http.StatusBadRequest, "unsupported_grant_type", "Only the client_credentials grant is supported")
+		return
+	}
+
+	clientID, clientSecret, basic := r.BasicAuth()
+	if basic {
+		// RFC 6749 form-encodes Basic credentials before base64.
+		var errID, errSecret error
+		clientID, errID = url.QueryUnescape(clientID)
+		clientSecret, errSecret = url.QueryUnescape(clientSecret)
+		if errID != nil || errSecret != nil {
+			writeOAuthError(w, http.StatusBadRequest, "invalid_request", "Malformed client credentials")
+			return
+		}
+	} else {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+
+	apiKey, err := h.apiKeyService.AuthenticateClient(clientID, clientSecret, utils.ClientIP(r))
+	if err == nil {
+		err = h.apiKeyService.CheckClientCert(apiKey, middleware.ClientCertFingerprint(r))
+	}
+	if err != nil {
+		if !services.IsCredentialError(err) {
+			h.logger.Error("Failed to authenticate OAuth client: ", err)
+			writeOAuthError(w, http.StatusInternalServerError, "server_error", "Failed to authenticate client")
+			return
+		}
+		if basic {
+			w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+		}
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "Client authentication failed")
+		return
+	}
+
+	scopes, err := services.NarrowScopes(apiKey, r.PostForm.Get("scope"))
+	if err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_scope", "Requested scope exceeds the API key's scopes")
+		return
+	}
+
+	token, ttl, err := h.authService.GenerateClientToken(apiKey, scopes)
+	if err != nil {
+		h.logger.Error("Failed to issue client token: ", err)
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "Failed to issue access token")
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	utils.WriteJSON(w, http.StatusOK, dto.ClientTokenResponse{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(ttl.Seconds()),
+		Scope:       services.JoinScopes(scopes),
+	})
+}
+
+// Introspect implements RFC 7662 for access tokens and API keys alike. Only
+// admins may call it. Gateways pass the address the credential was used
+// from as client_ip, so keys restricted to an IP allowlist can be checked.
+func (h *OAuthController) Introspect(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(utils.UserIDKey).(uint)
+
+	user, err := h.authService.GetUserByID(userID)
+	if err != nil {
+		h.logger.Error("Failed to load user for introspection: ", err)
+		utils.WriteError(w, http.StatusInternalServerError, errors.New("Failed to introspect token"))
+		return
+	}
+	if !user.IsAdmin {
+		utils.WriteError(w, http.StatusForbidden, errors.New("Admin access required"))
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return
+	}
+	token := r.PostForm.Get("token")
+	if token == "" {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "token is required")
+		return
+	}
+
+	result, err := h.introspectionService.Introspect(token, r.PostForm.Get("token_type_hint"), r.PostForm.Get("client_ip"))
+	if err != nil {
+		h.logger.Error("Failed to introspect token: ", err)
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "Failed to introspect token")
+		return
+	}
+
+	response := dto.IntrospectionResponse{
+		Active:    result.Active,
+		TokenType: result.TokenType,
+		Subject:   result.Subject,
+		Scope:     result.Scope,
+		ClientID:  result.ClientID,
+		JTI:       result.TokenID,
+		APIKeyID:  result.APIKeyID,
+		KeyStatus: result.KeyStatus,
+	}
+	if result.ExpiresAt != nil {
+		response.ExpiresAt = result.ExpiresAt.Unix()
+	}
+	if result.IssuedAt != nil {
+		response.IssuedAt = result.IssuedAt.Unix()
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	utils.WriteJSON(w, http.StatusOK, response)
+}
+
+func writeOAuthError(w http.ResponseWriter, status int, code, description string) {
+	w.Header().Set("Cache-Control", "no-store")
+	utils.WriteJSON(w, status, dto.OAuthErrorResponse{Error: code, ErrorDescription: description})
+}
@@ -30,6 +30,15 @@ func Connect(cfg *config.AppConfig) (*gorm.DB, error) {
 		&User{},
 		&APIKey{},
 		&AccessLogs{},
+		&Session{},
+		&RevokedToken{},
+		&SigningKey{},
+		&AuditChainHead{},
+		&AuditCheckpoint{},
+		&AuditArchive{},
+		&APIKeyUsage{},
+		&APIKeyUsageCursor{},
+		&ClientCertificate{},
 	); err != nil {
 		return nil, fmt.Errorf("failed to migrate database: %w", err)
 	}
@@ -1,46 +1,277 @@
 package db
 
 import (
+	"strings"
 	"time"
 )
 
+// User.TokenVersion is embedded in every access token; bumping it
+// invalidates all tokens issued before.
 type User struct {
-	ID        uint      `gorm:"primaryKey" json:"id"`
-	Name      string    `gorm:"type:varchar(255);not null" json:"name"`
-	Email     string    `gorm:"type:varchar(255);uniqueIndex;not null" json:"email"`
-	Password  string    `gorm:"type:varchar(255);not null" json:"-"`
-	CreatedAt time.Time `gorm:"type:timestamp" json:"createdAt"`
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	Name         string    `gorm:"type:varchar(255);not null" json:"name"`
+	Email        string    `gorm:"type:varchar(255);uniqueIndex;not null" json:"email"`
+	Password     string    `gorm:"type:varchar(255);not null" json:"-"`
+	TokenVersion int       `gorm:"not null;default:0" json:"-"`
+	IsAdmin      bool      `gorm:"not null;default:false" json:"isAdmin"`
+	CreatedAt    time.Time `gorm:"type:timestamp" json:"createdAt"`
 }
 
+// APIKey only persists a lookup prefix and a hash of the secret. Key carries
+// the full plaintext key on the value returned when a key is issued and is
+// never written to the database. RateLimit requests per RateLimitWindow
+// seconds override the configured default when set. A rotated key points at
+// its SuccessorID and keeps validating until GraceExpiresAt.
 type APIKey struct {
-	ID         uint       `gorm:"primaryKey" json:"id"`
-	Key        string     `gorm:"type:varchar(255);not null" json:"key"`
-	UserID     uint       `gorm:"not null" json:"user_id"`
-	User       User       `gorm:"foreignKey:UserID;references:ID" json:"user"`
-	IsRevoked  bool       `gorm:"default:false" json:"is_revoked"`
-	ExpiresAt  *time.Time `gorm:"type:timestamp" json:"expires_at"`
-	Name       string     `gorm:"type:varchar(255)" json:"name"`
-	LastUsedAt *time.Time `gorm:"type:timestamp" json:"last_used_at"`
-	CreatedAt  *time.Time `gorm:"type:timestamp" json:"created_at"`
-	UpdatedAt  *time.Time `gorm:"type:timestamp" json:"updated_at"`
+	ID              uint       `gorm:"primaryKey" json:"id"`
+	Key             string     `gorm:"-" json:"-"`
+	Prefix          string     `gorm:"type:varchar(32);index" json:"prefix"`
+	KeyHash         string     `gorm:"type:varchar(64)" json:"-"`
+	UserID          uint       `gorm:"not null" json:"user_id"`
+	User            User       `gorm:"foreignKey:UserID;references:ID" json:"user"`
+	Status          string     `gorm:"type:varchar(20);not null;default:active;index" json:"status"`
+	ExpiresAt       *time.Time `gorm:"type:timestamp" json:"expires_at"`
+	Name            string     `gorm:"type:varchar(255)" json:"name"`
+	Scopes          string     `gorm:"type:varchar(500)" json:"scopes"`
+	RateLimit       int        `gorm:"default:0" json:"rate_limit"`
+	RateLimitWindow int        `gorm:"default:0" json:"rate_limit_window"`
+	AllowedIPs      string     `gorm:"type:text" json:"allowed_ips"`
+	SuccessorID     *uint      `gorm:"index" json:"successor_id"`
+	GraceExpiresAt  *time.Time `gorm:"type:timestamp" json:"grace_expires_at"`
+	LastUsedAt      *time.Time `gorm:"type:timestamp" json:"last_used_at"`
+	CreatedAt       *time.Time `gorm:"type:timestamp" json:"created_at"`
+	UpdatedAt       *time.Time `gorm:"type:timestamp" json:"updated_at"`
+
+	// Set when the key stops being active by revocation, rotation or
+	// suspension; RevokedBy is the user who did it.
+	RevokedAt        *time.Time `gorm:"type:timestamp" json:"revoked_at"`
+	RevokedBy        *uint      `json:"revoked_by"`
+	RevocationReason string     `gorm:"type:varchar(500)" json:"revocation_reason"`
+
+	// Keys in signing mode sign requests with SigningSecret instead of
+	// sending the key. Like Key it is only set when issued; the stored copy
+	// is encrypted.
+	SigningSecret       string `gorm:"-" json:"-"`
+	SealedSigningSecret string `gorm:"type:varchar(255)" json:"-"`
+
+	// Only accepted alongside a client certificate registered to the key.
+	RequireClientCert bool `gorm:"default:false" json:"require_client_cert"`
 }
 
 func (APIKey) TableName() string {
 	return "api_keys"
 }
 
+// API key lifecycle states. Only active keys validate; suspended keys can
+// be reactivated by an admin, the others are final.
+const (
+	APIKeyStatusActive    = "active"
+	APIKeyStatusExpired   = "expired"
+	APIKeyStatusRevoked   = "revoked"
+	APIKeyStatusRotated   = "rotated"
+	APIKeyStatusSuspended = "suspended"
+)
+
+// ScopeList splits the space separated Scopes column.
+func (k APIKey) ScopeList() []string {
+	return strings.Fields(k.Scopes)
+}
+
+func (k APIKey) RequiresSignature() bool {
+	return k.SealedSigningSecret != ""
+}
+
+// AllowedIPList splits the space separated AllowedIPs column. An empty list
+// allows every address.
+func (k APIKey) AllowedIPList() []string {
+	return strings.Fields(k.AllowedIPs)
+}
+
+func (k APIKey) HasScope(scope string) bool {
+	for _, s := range k.ScopeList() {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientCertificate registers a TLS client certificate by the SHA-256 of its
+// DER encoding. Bound to an API key it authenticates as that key, otherwise
+// as its user with its own Scopes.
+type ClientCertificate struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	Fingerprint string     `gorm:"type:varchar(64);uniqueIndex;not null" json:"fingerprint"`
+	UserID      uint       `gorm:"not null;index" json:"user_id"`
+	User        User       `gorm:"foreignKey:UserID;references:ID" json:"-"`
+	APIKeyID    *uint      `gorm:"index" json:"api_key_id"`
+	Name        string     `gorm:"type:varchar(100)" json:"name"`
+	Subject     string     `gorm:"type:varchar(500)" json:"subject"`
+	Scopes      string     `gorm:"type:varchar(500)" json:"scopes"`
+	NotAfter    *time.Time `gorm:"type:timestamp" json:"not_after"`
+	LastUsedAt  *time.Time `gorm:"type:timestamp" json:"last_used_at"`
+	CreatedAt   time.Time  `gorm:"type:timestamp" json:"created_at"`
+}
+
+func (ClientCertificate) TableName() string {
+	return "client_certificates"
+}
+
+// ScopeList splits the space separated Scopes column.
+func (c ClientCertificate) ScopeList() []string {
+	return strings.Fields(c.Scopes)
+}
+
+// Session is a refresh token family. Only a hash of the current refresh
+// token is stored; presenting an older token of the same session means it
+// was stolen and replayed.
+type Session struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	PublicID   string     `gorm:"type:varchar(32);uniqueIndex;not null" json:"-"`
+	UserID     uint       `gorm:"not null;index" json:"user_id"`
+	User       User       `gorm:"foreignKey:UserID;references:ID" json:"-"`
+	TokenHash  string     `gorm:"type:varchar(64);not null" json:"-"`
+	UserAgent  string     `gorm:"type:varchar(500)" json:"user_agent"`
+	IPAddress  string     `gorm:"type:varchar(45)" json:"ip_address"`
+	ExpiresAt  time.Time  `gorm:"type:timestamp;not null" json:"expires_at"`
+	LastUsedAt *time.Time `gorm:"type:timestamp" json:"last_used_at"`
+	RevokedAt  *time.Time `gorm:"type:timestamp" json:"revoked_at"`
+	CreatedAt  time.Time  `gorm:"type:timestamp" json:"created_at"`
+}
+
+func (Session) TableName() string {
+	return "sessions"
+}
+
+// RevokedToken blocks a single access token by its jti until it would have
+// expired anyway.
+type RevokedToken struct {
+	JTI       string    `gorm:"type:varchar(64);primaryKey" json:"jti"`
+	UserID    uint      `gorm:"not null;index" json:"user_id"`
+	ExpiresAt time.Time `gorm:"type:timestamp;not null;index" json:"expires_at"`
+	CreatedAt time.Time `gorm:"type:timestamp" json:"created_at"`
+}
+
+func (RevokedToken) TableName() string {
+	return "revoked_tokens"
+}
+
 type AccessLogs struct {
-	ID         uint      `gorm:"primaryKey" json:"id"`
-	UserID     uint      `gorm:"not null" json:"user_id"`
-	Method     string    `gorm:"type:varchar(10);not null" json:"method"`
-	Path       string    `gorm:"type:varchar(500);not null" json:"path"`
-	StatusCode int       `gorm:"not null" json:"status_code"`
-	IPAddress  string    `gorm:"type:varchar(45)" json:"ip_address"`
-	UserAgent  string    `gorm:"type:varchar(500)" json:"user_agent"`
-	Duration   int64     `gorm:"not null" json:"duration"`
-	Timestamp  time.Time `gorm:"type:timestamp;not null" json:"timestamp"`
+	ID         uint   `gorm:"primaryKey" json:"id"`
+	UserID     uint   `gorm:"not null;index" json:"user_id"`
+	AuthMethod string `gorm:"type:varchar(20)" json:"auth_method"`
+	APIKeyID   *uint  `gorm:"index" json:"api_key_id"`
+	RequestID  string `gorm:"type:varchar(100);index" json:"request_id"`
+	Method     string `gorm:"type:varchar(10);not null" json:"method"`
+	Path       string `gorm:"type:varchar(500);not null" json:"path"`
+	// Route is the matched route pattern, e.g. /v1/api/users/{id}.
+	Route        string    `gorm:"type:varchar(500)" json:"route"`
+	StatusCode   int       `gorm:"not null" json:"status_code"`
+	ResponseSize int64     `gorm:"not null;default:0" json:"response_size"`
+	IPAddress    string    `gorm:"type:varchar(45)" json:"ip_address"`
+	UserAgent    string    `gorm:"type:varchar(500)" json:"user_agent"`
+	Duration     int64     `gorm:"not null" json:"duration"`
+	Timestamp    time.Time `gorm:"type:timestamp;not null;index" json:"timestamp"`
+	PrevHash     string    `gorm:"type:varchar(64)" json:"prev_hash"`
+	Hash         string    `gorm:"type:varchar(64)" json:"hash"`
 }
 
 func (AccessLogs) TableName() string {
 	return "access_logs"
 }
+
+// AuditChainHead is the single row holding the newest access log hash.
+// Writers swap it with a compare-and-set so concurrent instances extend one
+// chain instead of forking it.
+type AuditChainHead struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	LastLogID uint      `gorm:"not null;default:0" json:"last_log_id"`
+	Hash      string    `gorm:"type:varchar(64);not null;default:''" json:"hash"`
+	UpdatedAt time.Time `gorm:"type:timestamp" json:"updated_at"`
+}
+
+func (AuditChainHead) TableName() string {
+	return "audit_chain_heads"
+}
+
+// AuditCheckpoint vouches for the chain up to LastLogID with an HMAC that
+// can't be recomputed by someone who only has database access.
+type AuditCheckpoint struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	LastLogID uint      `gorm:"not null;index" json:"last_log_id"`
+	Hash      string    `gorm:"type:varchar(64);not null" json:"hash"`
+	Signature string    `gorm:"type:varchar(64);not null" json:"signature"`
+	CreatedAt time.Time `gorm:"type:timestamp" json:"created_at"`
+}
+
+func (AuditCheckpoint) TableName() string {
+	return "audit_checkpoints"
+}
+
+// AuditArchive records access logs moved out of the database by retention.
+// LastHash is signed like a checkpoint so the chain remaining in the
+// database can still be verified from where the archive left off, and
+// FirstPrevHash along with it so each archive is known to follow the last.
+type AuditArchive struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	FirstLogID    uint      `gorm:"not null" json:"first_log_id"`
+	LastLogID     uint      `gorm:"not null;index" json:"last_log_id"`
+	FirstPrevHash string    `gorm:"type:varchar(64)" json:"first_prev_hash"`
+	LastHash      string    `gorm:"type:varchar(64)" json:"last_hash"`
+	Count         int       `gorm:"not null" json:"count"`
+	Location      string    `gorm:"type:varchar(500);not null" json:"location"`
+	Signature     string    `gorm:"type:varchar(64);not null" json:"signature"`
+	CreatedAt     time.Time `gorm:"type:timestamp" json:"created_at"`
+}
+
+func (AuditArchive) TableName() string {
+	return "audit_archives"
+}
+
+// APIKeyUsage rolls up one hour of an API key's requests to one endpoint.
+// LatencyHistogram holds space separated request counts per latency bucket.
+type APIKeyUsage struct {
+	ID               uint      `gorm:"primaryKey" json:"id"`
+	APIKeyID         uint      `gorm:"not null;uniqueIndex:idx_api_key_usage_bucket" json:"api_key_id"`
+	BucketStart      time.Time `gorm:"type:timestamp;not null;uniqueIndex:idx_api_key_usage_bucket" json:"bucket_start"`
+	Method           string    `gorm:"type:varchar(10);not null;uniqueIndex:idx_api_key_usage_bucket" json:"method"`
+	Route            string    `gorm:"type:varchar(500);not null;uniqueIndex:idx_api_key_usage_bucket" json:"route"`
+	RequestCount     int64     `gorm:"not null;default:0" json:"request_count"`
+	ErrorCount       int64     `gorm:"not null;default:0" json:"error_count"`
+	TotalDuration    int64     `gorm:"not null;default:0" json:"total_duration"`
+	MaxDuration      int64     `gorm:"not null;default:0" json:"max_duration"`
+	LatencyHistogram string    `gorm:"type:varchar(200)" json:"latency_histogram"`
+}
+
+func (APIKeyUsage) TableName() string {
+	return "api_key_usage"
+}
+
+// APIKeyUsageCursor is the single row holding the last access log folded
+// into api_key_usage.
+type APIKeyUsageCursor struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	LastLogID uint      `gorm:"not null;default:0" json:"last_log_id"`
+	UpdatedAt time.Time `gorm:"type:timestamp" json:"updated_at"`
+}
+
+func (APIKeyUsageCursor) TableName() string {
+	return "api_key_usage_cursor"
+}
+
+// SigningKey is an asymmetric JWT signing key. The private key is stored
+// encrypted; retired keys stop signing but still verify until the tokens
+// they signed have expired.
+type SigningKey struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	KID        string     `gorm:"column:kid;type:varchar(64);uniqueIndex;not null" json:"kid"`
+	Algorithm  string     `gorm:"type:varchar(16);not null" json:"algorithm"`
+	PrivateKey string     `gorm:"type:text;not null" json:"-"`
+	PublicKey  string     `gorm:"type:text;not null" json:"public_key"`
+	RetiredAt  *time.Time `gorm:"type:timestamp;index" json:"retired_at"`
+	CreatedAt  time.Time  `gorm:"type:timestamp" json:"created_at"`
+}
+
+func (SigningKey) TableName() string {
+	return "signing_keys"
+}
@@ -5,34 +5,76 @@ import (
 )
 
 type CreateAPIKeyRequest struct {
-	Name      string `json:"name" validate:"required,max=100"`
-	ExpiresIn *int   `json:"expires_in" validate:"omitempty,min=1"`
+	Name              string   `json:"name" validate:"required,max=100"`
+	ExpiresIn         *int     `json:"expires_in" validate:"omitempty,min=1"`
+	Scopes            []string `json:"scopes" validate:"omitempty,dive,required"`
+	RateLimit         int      `json:"rate_limit" validate:"omitempty,min=1,max=100000"`
+	RateLimitWindow   int      `json:"rate_limit_window" validate:"omitempty,min=1,max=86400"`
+	AllowedIPs        []string `json:"allowed_ips" validate:"omitempty,max=100,dive,required"`
+	Signing           bool     `json:"signing"`
+	RequireClientCert bool     `json:"require_client_cert"`
 }
 
+// CreateAPIKeyResponse is the only response that carries the full key and,
+// in signing mode, the signing secret.
 type CreateAPIKeyResponse struct {
-	ID        uint       `json:"id"`
-	Key       string     `json:"key"`
-	Name      string     `json:"name"`
-	ExpiresAt *time.Time `json:"expires_at"`
-	CreatedAt time.Time  `json:"created_at"`
+	ID                uint       `json:"id"`
+	Key               string     `json:"key"`
+	SigningSecret     string     `json:"signing_secret,omitempty"`
+	Prefix            string     `json:"prefix"`
+	Name              string     `json:"name"`
+	Scopes            []string   `json:"scopes"`
+	RateLimit         int        `json:"rate_limit"`
+	RateLimitWindow   int        `json:"rate_limit_window"`
+	AllowedIPs        []string   `json:"allowed_ips"`
+	ExpiresAt         *time.Time `json:"expires_at"`
+	CreatedAt         time.Time  `json:"created_at"`
+	RequireClientCert bool       `json:"require_client_cert"`
 }
 
 type APIKeyResponse struct {
-	ID         uint       `json:"id"`
-	Name       string     `json:"name"`
-	IsRevoked  bool       `json:"is_revoked"`
-	ExpiresAt  *time.Time `json:"expires_at"`
-	LastUsedAt *time.Time `json:"last_used_at"`
-	CreatedAt  time.Time  `json:"created_at"`
-	UpdatedAt  time.Time  `json:"updated_at"`
+	ID                uint       `json:"id"`
+	Prefix            string     `json:"prefix"`
+	Name              string     `json:"name"`
+	Scopes            []string   `json:"scopes"`
+	RateLimit         int        `json:"rate_limit"`
+	RateLimitWindow   int        `json:"rate_limit_window"`
+	AllowedIPs        []string   `json:"allowed_ips"`
+	Signing           bool       `json:"signing"`
+	RequireClientCert bool       `json:"require_client_cert"`
+	SuccessorID       *uint      `json:"successor_id"`
+	GraceExpiresAt    *time.Time `json:"grace_expires_at"`
+	Status            string     `json:"status"`
+	RevokedAt         *time.Time `json:"revoked_at,omitempty"`
+	RevokedBy         *uint      `json:"revoked_by,omitempty"`
+	Reason            string     `json:"revocation_reason,omitempty"`
+	ExpiresAt         *time.Time `json:"expires_at"`
+	LastUsedAt        *time.Time `json:"last_used_at"`
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
 }
 
+// RevokeAPIKeyRequest is optional and also used to suspend a key.
 type RevokeAPIKeyRequest struct {
-	KeyID uint `json:"key_id" validate:"required,min=1"`
+	Reason string `json:"reason" validate:"max=500"`
 }
 
+// UpdateAllowedIPsRequest replaces a key's IP allowlist; an empty list
+// allows any address.
+type UpdateAllowedIPsRequest struct {
+	AllowedIPs []string `json:"allowed_ips" validate:"max=100,dive,required"`
+}
+
+// RotateAPIKeyRequest is optional; GracePeriod is in seconds and keeps the
+// old key valid alongside the new one for that long.
 type RotateAPIKeyRequest struct {
-	KeyID uint `json:"key_id" validate:"required,min=1"`
+	GracePeriod int `json:"grace_period" validate:"min=0,max=2592000"`
+}
+
+type RotateAPIKeyResponse struct {
+	CreateAPIKeyResponse
+	RotatedFromID        uint       `json:"rotated_from_id"`
+	PreviousKeyExpiresAt *time.Time `json:"previous_key_expires_at"`
 }
 
 type ErrorResponse struct {
@@ -0,0 +1,25 @@
+package dto
+
+import "time"
+
+type AuditLogResponse struct {
+	ID           uint      `json:"id"`
+	UserID       uint      `json:"user_id"`
+	AuthMethod   string    `json:"auth_method,omitempty"`
+	APIKeyID     *uint     `json:"api_key_id"`
+	RequestID    string    `json:"request_id,omitempty"`
+	Method       string    `json:"method"`
+	Path         string    `json:"path"`
+	Route        string    `json:"route,omitempty"`
+	StatusCode   int       `json:"status_code"`
+	ResponseSize int64     `json:"response_size"`
+	IPAddress    string    `json:"ip_address"`
+	UserAgent    string    `json:"user_agent"`
+	Duration     int64     `json:"duration"`
+	Timestamp    time.Time `json:"timestamp"`
+}
+
+type AuditLogListResponse struct {
+	Data       []AuditLogResponse `json:"data"`
+	NextCursor string             `json:"next_cursor,omitempty"`
+}
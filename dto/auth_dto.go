@@ -1,6 +1,36 @@
 package dto
 
+import (
+	"time"
+
+	"github.com/Brownei/api-generation-api/services"
+)
+
 type AuthDto struct {
 	Email   string `json:"email"`
 	Pasword string `json:"password"`
 }
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+type SessionResponse struct {
+	ID         uint       `json:"id"`
+	UserAgent  string     `json:"user_agent"`
+	IPAddress  string     `json:"ip_address"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+type JWKSResponse struct {
+	Keys []services.JWK `json:"keys"`
+}
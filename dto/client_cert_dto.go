@@ -0,0 +1,24 @@
+package dto
+
+import "time"
+
+// RegisterClientCertRequest names the certificate presented on the
+// request's own TLS connection. Scopes are ignored for certificates bound
+// to a key, which act with the key's scopes.
+type RegisterClientCertRequest struct {
+	Name     string   `json:"name" validate:"required,max=100"`
+	APIKeyID *uint    `json:"api_key_id" validate:"omitempty,min=1"`
+	Scopes   []string `json:"scopes" validate:"omitempty,dive,required"`
+}
+
+type ClientCertResponse struct {
+	ID          uint       `json:"id"`
+	Name        string     `json:"name"`
+	Fingerprint string     `json:"fingerprint"`
+	Subject     string     `json:"subject,omitempty"`
+	APIKeyID    *uint      `json:"api_key_id"`
+	Scopes      []string   `json:"scopes"`
+	NotAfter    *time.Time `json:"not_after"`
+	LastUsedAt  *time.Time `json:"last_used_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
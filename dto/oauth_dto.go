@@ -0,0 +1,33 @@
+package dto
+
+// ClientTokenResponse is the RFC 6749 access token response. Unlike a
+// login, the client_credentials grant issues no refresh token.
+type ClientTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+	Scope       string `json:"scope"`
+}
+
+// OAuthErrorResponse is the RFC 6749 error response; Error is one of the
+// codes the RFC defines, such as "invalid_client".
+type OAuthErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// IntrospectionResponse is the RFC 7662 introspection response. Inactive
+// credentials only carry Active and, for a key that can't be used any more,
+// KeyStatus.
+type IntrospectionResponse struct {
+	Active    bool   `json:"active"`
+	TokenType string `json:"token_type,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	JTI       string `json:"jti,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	APIKeyID  uint   `json:"api_key_id,omitempty"`
+	KeyStatus string `json:"key_status,omitempty"`
+}
@@ -0,0 +1,96 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Brownei/api-generation-api/db"
+	"github.com/Brownei/api-generation-api/services"
+	"github.com/Brownei/api-generation-api/types"
+	"github.com/Brownei/api-generation-api/utils"
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
+)
+
+const (
+	APIKeyHeader     = "X-API-Key"
+	APIKeyAuthScheme = "ApiKey"
+)
+
+// APIKeyAuthenticator accepts an API key in the X-API-Key header or as
+// "Authorization: ApiKey <key>". Keys used from outside their IP allowlist
+// are rejected before the audit middleware runs, so those attempts are
+// logged here; auditService may be nil.
+type APIKeyAuthenticator struct {
+	apiKeyService *services.APIKeyService
+	auditService  *services.AuditLogService
+}
+
+func NewAPIKeyAuthenticator(apiKeyService *services.APIKeyService, auditService *services.AuditLogService) *APIKeyAuthenticator {
+	return &APIKeyAuthenticator{apiKeyService: apiKeyService, auditService: auditService}
+}
+
+func (a *APIKeyAuthenticator) Authenticate(r *http.Request) (*types.Principal, error) {
+	key, ok := extractAPIKey(r)
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+
+	apiKey, err := a.apiKeyService.ValidateAPIKey(key, utils.ClientIP(r))
+	if err != nil {
+		auditIPRejection(a.auditService, r, types.AuthMethodAPIKey, err)
+		return nil, err
+	}
+	if err := a.apiKeyService.CheckClientCert(apiKey, ClientCertFingerprint(r)); err != nil {
+		return nil, err
+	}
+
+	return apiKeyPrincipal(apiKey, types.AuthMethodAPIKey), nil
+}
+
+func apiKeyPrincipal(apiKey *db.APIKey, method types.AuthMethod) *types.Principal {
+	return &types.Principal{
+		UserID:          apiKey.UserID,
+		Method:          method,
+		APIKeyID:        apiKey.ID,
+		Scopes:          append([]string{}, apiKey.ScopeList()...),
+		RateLimit:       apiKey.RateLimit,
+		RateLimitWindow: time.Duration(apiKey.RateLimitWindow) * time.Second,
+	}
+}
+
+// auditIPRejection logs a key used from outside its IP allowlist against
+// the key's owner, since the audit middleware never sees the request.
+func auditIPRejection(auditService *services.AuditLogService, r *http.Request, method types.AuthMethod, err error) {
+	var ipErr *services.IPNotAllowedError
+	if auditService == nil || !errors.As(err, &ipErr) {
+		return
+	}
+	auditService.LogRequest(services.AuditLogEntry{
+		UserID:     ipErr.UserID,
+		AuthMethod: string(method),
+		APIKeyID:   &ipErr.APIKeyID,
+		RequestID:  chimiddleware.GetReqID(r.Context()),
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		StatusCode: http.StatusForbidden,
+		IPAddress:  ipErr.IP,
+		UserAgent:  r.UserAgent(),
+	})
+}
+
+func extractAPIKey(r *http.Request) (string, bool) {
+	if key := strings.TrimSpace(r.Header.Get(APIKeyHeader)); key != "" {
+		return key, true
+	}
+
+	parts := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+	if len(parts) == 2 && strings.EqualFold(parts[0], APIKeyAuthScheme) {
+		if key := strings.TrimSpace(parts[1]); key != "" {
+			return key, true
+		}
+	}
+
+	return "", false
+}
@@ -1,24 +1,32 @@
 package middleware
 
 import (
-	"fmt"
 	"net/http"
 	"time"
 
 	"github.com/Brownei/api-generation-api/services"
 	"github.com/Brownei/api-generation-api/utils"
+	"github.com/go-chi/chi/v5"
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
 )
 
 func AuditLogMiddleware(auditService *services.AuditLogService) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			userID := r.Context().Value(utils.UserIDKey)
-			if userID == nil {
+			userID, ok := r.Context().Value(utils.UserIDKey).(uint)
+			if !ok {
 				next.ServeHTTP(w, r)
 				return
 			}
 
-			fmt.Printf("user id: %v", userID)
+			var authMethod string
+			var apiKeyID *uint
+			if principal, ok := utils.PrincipalFromContext(r.Context()); ok {
+				authMethod = string(principal.Method)
+				if principal.APIKeyID != 0 {
+					apiKeyID = &principal.APIKeyID
+				}
+			}
 
 			start := time.Now()
 			uw := &statusWriter{ResponseWriter: w, statusCode: http.StatusOK}
@@ -27,14 +35,25 @@ func AuditLogMiddleware(auditService *services.AuditLogService) func(http.Handle
 
 			duration := time.Since(start).Milliseconds()
 
+			// The pattern is only complete once routing has finished.
+			var route string
+			if rctx := chi.RouteContext(r.Context()); rctx != nil {
+				route = rctx.RoutePattern()
+			}
+
 			auditService.LogRequest(services.AuditLogEntry{
-				UserID:     userID.(uint),
-				Method:     r.Method,
-				Path:       r.URL.Path,
-				StatusCode: uw.statusCode,
-				IPAddress:  r.RemoteAddr,
-				UserAgent:  r.UserAgent(),
-				Duration:   duration,
+				UserID:       userID,
+				AuthMethod:   authMethod,
+				APIKeyID:     apiKeyID,
+				RequestID:    chimiddleware.GetReqID(r.Context()),
+				Method:       r.Method,
+				Path:         r.URL.Path,
+				Route:        route,
+				StatusCode:   uw.statusCode,
+				ResponseSize: uw.size,
+				IPAddress:    utils.ClientIP(r),
+				UserAgent:    r.UserAgent(),
+				Duration:     duration,
 			})
 		})
 	}
@@ -43,9 +62,21 @@ func AuditLogMiddleware(auditService *services.AuditLogService) func(http.Handle
 type statusWriter struct {
 	http.ResponseWriter
 	statusCode int
+	size       int64
 }
 
 func (sw *statusWriter) WriteHeader(code int) {
 	sw.statusCode = code
 	sw.ResponseWriter.WriteHeader(code)
 }
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	n, err := sw.ResponseWriter.Write(b)
+	sw.size += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
@@ -1,7 +1,6 @@
 package middleware
 
 import (
-	"context"
 	"errors"
 	"net/http"
 	"strings"
@@ -9,42 +8,70 @@ import (
 	"github.com/Brownei/api-generation-api/services"
 	"github.com/Brownei/api-generation-api/types"
 	"github.com/Brownei/api-generation-api/utils"
+	"github.com/golang-jwt/jwt/v5"
 )
 
-type AuthMiddleware struct {
-	authService *services.AuthService
+// JWTAuthenticator accepts "Authorization: Bearer <access token>". Tokens
+// issued to an API key act as that key, which must still be usable;
+// apiKeyService may be nil where only user tokens are accepted.
+type JWTAuthenticator struct {
+	authService   *services.AuthService
+	apiKeyService *services.APIKeyService
+	auditService  *services.AuditLogService
 }
 
-func NewAuthMiddleware(authService *services.AuthService) *AuthMiddleware {
-	return &AuthMiddleware{authService: authService}
+func NewJWTAuthenticator(authService *services.AuthService, apiKeyService *services.APIKeyService, auditService *services.AuditLogService) *JWTAuthenticator {
+	return &JWTAuthenticator{authService: authService, apiKeyService: apiKeyService, auditService: auditService}
 }
 
-func (m *AuthMiddleware) Authenticate(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			http.Error(w, `{"error": "authorization header required"}`, http.StatusUnauthorized)
-			return
-		}
+func (a *JWTAuthenticator) Authenticate(r *http.Request) (*types.Principal, error) {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return nil, ErrNoCredentials
+	}
 
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			http.Error(w, `{"error": "invalid authorization header format"}`, http.StatusUnauthorized)
-			return
+	claims, err := a.authService.ValidateToken(strings.TrimSpace(token))
+	if err != nil {
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			return nil, types.ErrTokenExpired
 		}
+		return nil, types.ErrInvalidToken
+	}
 
-		claims, err := m.authService.ValidateToken(parts[1])
-		if err != nil {
-			if errors.Is(err, types.ErrTokenExpired) {
-				http.Error(w, `{"error": "token expired"}`, http.StatusUnauthorized)
-				return
-			}
-			http.Error(w, `{"error": "invalid token"}`, http.StatusUnauthorized)
-			return
-		}
+	if err := a.authService.CheckTokenRevocation(claims); err != nil {
+		return nil, err
+	}
+
+	if claims.APIKeyID != 0 {
+		return a.keyPrincipal(r, claims)
+	}
+
+	return &types.Principal{
+		UserID:  claims.UserID,
+		Email:   claims.Email,
+		Method:  types.AuthMethodJWT,
+		TokenID: claims.ID,
+	}, nil
+}
+
+// keyPrincipal authenticates a client_credentials token as its key, limited
+// to the scopes the token was granted.
+func (a *JWTAuthenticator) keyPrincipal(r *http.Request, claims *services.Claims) (*types.Principal, error) {
+	if a.apiKeyService == nil {
+		return nil, types.ErrInvalidToken
+	}
+
+	apiKey, err := a.apiKeyService.CheckTokenKey(claims.APIKeyID, utils.ClientIP(r))
+	if err != nil {
+		auditIPRejection(a.auditService, r, types.AuthMethodJWT, err)
+		return nil, err
+	}
+	if err := a.apiKeyService.CheckClientCert(apiKey, ClientCertFingerprint(r)); err != nil {
+		return nil, err
+	}
 
-		ctx := context.WithValue(r.Context(), utils.UserIDKey, claims.UserID)
-		ctx = context.WithValue(ctx, "user_email", claims.Email)
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
+	principal := apiKeyPrincipal(apiKey, types.AuthMethodJWT)
+	principal.TokenID = claims.ID
+	principal.Scopes = services.GrantedScopes(apiKey, claims.Scope)
+	return principal, nil
 }
@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Brownei/api-generation-api/services"
+	"github.com/Brownei/api-generation-api/types"
+	"github.com/Brownei/api-generation-api/utils"
+)
+
+// ErrNoCredentials is returned by an Authenticator when the request carries
+// none of the credentials it understands, so the next one gets a turn.
+var ErrNoCredentials = errors.New("authentication required")
+
+// Authenticator resolves the credentials on a request to a principal.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*types.Principal, error)
+}
+
+// Authenticate runs the authenticators in order. The first one that finds
+// its credentials decides the outcome; on success the principal and user ID
+// are stored in the request context.
+func Authenticate(authenticators ...Authenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, authenticator := range authenticators {
+				principal, err := authenticator.Authenticate(r)
+				if errors.Is(err, ErrNoCredentials) {
+					continue
+				}
+				if err != nil {
+					writeAuthError(w, err)
+					return
+				}
+
+				next.ServeHTTP(w, r.WithContext(utils.WithPrincipal(r.Context(), principal)))
+				return
+			}
+
+			writeAuthError(w, ErrNoCredentials)
+		})
+	}
+}
+
+// writeAuthError gives every authentication method the same JSON error
+// shape. Anything unrecognised is a server-side failure.
+func writeAuthError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrNoCredentials),
+		errors.Is(err, types.ErrTokenExpired),
+		errors.Is(err, types.ErrTokenRevoked),
+		errors.Is(err, types.ErrInvalidToken),
+		errors.Is(err, services.ErrAPIKeyRevoked),
+		errors.Is(err, services.ErrAPIKeyExpired),
+		errors.Is(err, services.ErrAPIKeySuspended),
+		errors.Is(err, services.ErrSignatureRequired),
+		errors.Is(err, services.ErrInvalidSignature),
+		errors.Is(err, services.ErrSignatureExpired),
+		errors.Is(err, services.ErrNonceReused),
+		errors.Is(err, services.ErrClientCertRequired),
+		errors.Is(err, services.ErrInvalidAPIKey):
+		utils.WriteError(w, http.StatusUnauthorized, err)
+	case errors.Is(err, services.ErrAPIKeyIPNotAllowed):
+		utils.WriteError(w, http.StatusForbidden, err)
+	default:
+		utils.WriteError(w, http.StatusInternalServerError, errors.New("failed to authenticate request"))
+	}
+}
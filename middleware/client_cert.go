@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"crypto/x509"
+	"errors"
+	"net/http"
+
+	"github.com/Brownei/api-generation-api/services"
+	"github.com/Brownei/api-generation-api/types"
+	"github.com/Brownei/api-generation-api/utils"
+)
+
+// ClientCertAuthenticator accepts a verified TLS client certificate that is
+// registered on its own. Certificates the server didn't verify, or that
+// aren't registered, are treated as no credentials, leaving the request to
+// the other authenticators.
+type ClientCertAuthenticator struct {
+	clientCertService *services.ClientCertService
+	auditService      *services.AuditLogService
+}
+
+func NewClientCertAuthenticator(clientCertService *services.ClientCertService, auditService *services.AuditLogService) *ClientCertAuthenticator {
+	return &ClientCertAuthenticator{clientCertService: clientCertService, auditService: auditService}
+}
+
+func (a *ClientCertAuthenticator) Authenticate(r *http.Request) (*types.Principal, error) {
+	fingerprint := ClientCertFingerprint(r)
+	if fingerprint == "" {
+		return nil, ErrNoCredentials
+	}
+
+	cert, apiKey, err := a.clientCertService.Authenticate(fingerprint, utils.ClientIP(r))
+	if errors.Is(err, services.ErrClientCertNotFound) || errors.Is(err, services.ErrClientCertNeedsKey) {
+		return nil, ErrNoCredentials
+	}
+	if err != nil {
+		auditIPRejection(a.auditService, r, types.AuthMethodClientCert, err)
+		return nil, err
+	}
+
+	if apiKey != nil {
+		return apiKeyPrincipal(apiKey, types.AuthMethodClientCert), nil
+	}
+	return &types.Principal{
+		UserID: cert.UserID,
+		Method: types.AuthMethodClientCert,
+		Scopes: cert.ScopeList(),
+	}, nil
+}
+
+// ClientCertificate returns the client certificate the TLS handshake
+// verified, or nil when there is none.
+func ClientCertificate(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0][0]
+}
+
+// ClientCertFingerprint returns the fingerprint of the client certificate
+// the TLS handshake verified, or "" when there is none.
+func ClientCertFingerprint(r *http.Request) string {
+	cert := ClientCertificate(r)
+	if cert == nil {
+		return ""
+	}
+	return services.CertificateFingerprint(cert)
+}
@@ -0,0 +1,99 @@
+package middleware
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/Brownei/api-generation-api/services"
+	"github.com/Brownei/api-generation-api/types"
+	"github.com/Brownei/api-generation-api/utils"
+)
+
+// RateLimit limits requests per API key, falling back to the authenticated
+// user and then the client IP. Mount it after authentication so the
+// principal is known. A non-positive limit disables limiting.
+func RateLimit(limiter *services.RateLimiter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, _ := utils.PrincipalFromContext(r.Context())
+			policy := limiter.PolicyFor(principal)
+			if policy.Limit <= 0 || policy.Window <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			result, err := limiter.Allow(rateLimitKey(r, principal), policy)
+			if err != nil {
+				// Fail open: an unavailable limiter backend shouldn't take
+				// the API down with it.
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if !writeRateLimitHeaders(w, result) {
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RateLimitAuthFailures charges requests that fail authentication to the
+// client IP's budget, which RateLimit never sees because authentication
+// answers them first. Once that budget is spent the IP gets 429 before its
+// credentials are even checked. Mount it ahead of authentication.
+func RateLimitAuthFailures(limiter *services.RateLimiter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			policy := limiter.PolicyFor(nil)
+			if policy.Limit <= 0 || policy.Window <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			key := rateLimitKey(r, nil)
+			if result, err := limiter.Check(key, policy); err == nil && !result.Allowed {
+				writeRateLimitHeaders(w, result)
+				return
+			}
+
+			sw := &statusWriter{ResponseWriter: w, statusCode: http.StatusOK}
+			next.ServeHTTP(sw, r)
+
+			if sw.statusCode == http.StatusUnauthorized {
+				limiter.Allow(key, policy)
+			}
+		})
+	}
+}
+
+// writeRateLimitHeaders sets the quota headers and, when the request is
+// over the limit, answers 429. It reports whether the request may proceed.
+func writeRateLimitHeaders(w http.ResponseWriter, result services.RateLimitResult) bool {
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(result.ResetAt.Unix(), 10))
+
+	if !result.Allowed {
+		retryAfter := int(math.Ceil(result.RetryAfter.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		utils.WriteJSON(w, http.StatusTooManyRequests, map[string]string{
+			"error": fmt.Sprintf("rate limit exceeded, retry in %d seconds", retryAfter),
+		})
+		return false
+	}
+	return true
+}
+
+func rateLimitKey(r *http.Request, principal *types.Principal) string {
+	if principal != nil && principal.APIKeyID != 0 {
+		return fmt.Sprintf("key:%d", principal.APIKeyID)
+	}
+	if principal != nil {
+		return fmt.Sprintf("user:%d", principal.UserID)
+	}
+	return "ip:" + utils.ClientIP(r)
+}
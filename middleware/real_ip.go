@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/Brownei/api-generation-api/utils"
+)
+
+// ParseTrustedProxies reads proxy addresses given as single IPs or CIDRs.
+func ParseTrustedProxies(proxies []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(proxies))
+	for _, proxy := range proxies {
+		if strings.Contains(proxy, "/") {
+			prefix, err := netip.ParsePrefix(proxy)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", proxy, err)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", proxy, err)
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// RealIP replaces RemoteAddr with the client's IP. X-Forwarded-For is only
+// believed when the connection comes from a trusted proxy, and then read
+// right to left up to the first hop that isn't one, so a client can't
+// spoof its address by sending the header itself. The port is dropped.
+func RealIP(trustedProxies []netip.Prefix) func(http.Handler) http.Handler {
+	trusted := func(addr netip.Addr) bool {
+		for _, prefix := range trustedProxies {
+			if prefix.Contains(addr) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			addr, err := netip.ParseAddr(utils.ClientIP(r))
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			addr = addr.Unmap()
+
+			if trusted(addr) {
+				hops := forwardedFor(r.Header)
+				for i := len(hops) - 1; i >= 0; i-- {
+					hop, err := netip.ParseAddr(hops[i])
+					if err != nil {
+						break
+					}
+					addr = hop.Unmap()
+					if !trusted(addr) {
+						break
+					}
+				}
+			}
+
+			r.RemoteAddr = addr.String()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// forwardedFor returns every X-Forwarded-For hop, across repeated headers,
+// in the order they were added.
+func forwardedFor(header http.Header) []string {
+	var hops []string
+	for _, value := range header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(value, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	return hops
+}
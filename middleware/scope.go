@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/Brownei/api-generation-api/utils"
+)
+
+// RequireScope rejects principals lacking any of the given scopes. User
+// sessions are unrestricted and always pass. Mount it after Authenticate.
+func RequireScope(scopes ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, ok := utils.PrincipalFromContext(r.Context())
+			if !ok {
+				writeAuthError(w, ErrNoCredentials)
+				return
+			}
+
+			for _, scope := range scopes {
+				if !principal.HasScope(scope) {
+					utils.WriteJSON(w, http.StatusForbidden, map[string]string{
+						"error":    "insufficient scope",
+						"required": scope,
+					})
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -1,17 +1,30 @@
 package services
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/Brownei/api-generation-api/config"
 	"github.com/Brownei/api-generation-api/db"
 	"gorm.io/gorm"
 )
 
 const MaxActiveAPIKeys = 3
 
+// Issued keys look like "<prefix>_<secret>". The prefix is stored in the clear
+// for lookups and display; only a hash of the secret is persisted.
+const (
+	apiKeyPrefixBytes   = 6
+	apiKeySeparator     = "_"
+	legacyKeyPrefixSize = 12
+)
+
 var (
 	ErrTooManyAPIKeys = errors.New("maximum number of active API keys reached")
 	ErrAPIKeyNotFound = errors.New("API key not found")
@@ -19,45 +32,151 @@ var (
 	ErrAPIKeyRevoked  = errors.New("API key has been revoked")
 	ErrAPIKeyExpired  = errors.New("API key has expired")
 	ErrInvalidAPIKey  = errors.New("invalid API key")
+
+	ErrAPIKeySuspended      = errors.New("API key has been suspended")
+	ErrAPIKeyAlreadyRotated = errors.New("API key has already been rotated")
+	ErrAPIKeyNotSuspended   = errors.New("API key is not suspended")
 )
 
 type APIKeyService struct {
-	db *gorm.DB
+	db  *gorm.DB
+	cfg *config.AppConfig
+
+	cache           *apiKeyCache
+	invalidationBus APIKeyInvalidationBus
+
+	// Encrypts signing secrets; nonces catches replayed signed requests.
+	box    *secretBox
+	nonces NonceStore
+
+	// Last-used timestamps waiting to be flushed; nil while unbuffered.
+	lastUsedMu sync.Mutex
+	lastUsed   map[uint]time.Time
+	flushStop  chan struct{}
+	flushDone  chan struct{}
+
+	sweepStop chan struct{}
+	sweepDone chan struct{}
+}
+
+func NewAPIKeyService(db *gorm.DB, cfg *config.AppConfig) *APIKeyService {
+	return &APIKeyService{
+		db:     db,
+		cfg:    cfg,
+		cache:  newAPIKeyCache(cfg.APIKeyCacheTTL, cfg.APIKeyNegativeCacheTTL, cfg.APIKeyCacheSize),
+		box:    newSecretBox(cfg),
+		nonces: NewInMemoryNonceStore(),
+	}
 }
 
-func NewAPIKeyService(db *gorm.DB) *APIKeyService {
-	return &APIKeyService{db: db}
+// APIKeyOptions describes a key to be issued by CreateAPIKey. RateLimit is
+// in requests per RateLimitWindow seconds; zero keeps the configured default.
+type APIKeyOptions struct {
+	Name            string
+	ExpiresIn       *int
+	Scopes          []string
+	RateLimit       int
+	RateLimitWindow int
+	// IPs and CIDRs the key may be used from; empty allows any address.
+	AllowedIPs []string
+	// Signing issues a signing secret; the key then only authenticates
+	// signed requests.
+	Signing bool
+	// RequireClientCert only accepts the key alongside a client
+	// certificate registered to it.
+	RequireClientCert bool
+
+	// expiresAt is an absolute expiry taking precedence over ExpiresIn, so
+	// a successor keeps the expiry of the key it replaces.
+	expiresAt *time.Time
 }
 
 func (s *APIKeyService) GenerateAPIKey(userID uint, name string, expiresIn *int) (*db.APIKey, error) {
+	return s.CreateAPIKey(userID, APIKeyOptions{Name: name, ExpiresIn: expiresIn})
+}
+
+func (s *APIKeyService) CreateAPIKey(userID uint, opts APIKeyOptions) (*db.APIKey, error) {
+	if err := s.checkActiveKeyLimit(userID); err != nil {
+		return nil, err
+	}
+
+	return s.insertAPIKey(s.db, userID, opts)
+}
+
+// checkActiveKeyLimit fails with ErrTooManyAPIKeys when the user already
+// has MaxActiveAPIKeys active keys. Keys being rotated out don't count:
+// they are on their way to revocation. Neither do keys past their expiry
+// that haven't been swept yet.
+func (s *APIKeyService) checkActiveKeyLimit(userID uint) error {
 	var count int64
-	s.db.Model(&db.APIKey{}).Where("user_id = ? AND is_revoked = ?", userID, false).Count(&count)
+	if err := s.db.Model(&db.APIKey{}).
+		Where("user_id = ? AND status = ? AND successor_id IS NULL", userID, db.APIKeyStatusActive).
+		Where("expires_at IS NULL OR expires_at > ?", time.Now()).
+		Count(&count).Error; err != nil {
+		return err
+	}
 	if count >= MaxActiveAPIKeys {
-		return nil, ErrTooManyAPIKeys
+		return ErrTooManyAPIKeys
 	}
+	return nil
+}
 
-	key, err := generateRandomKey()
+func (s *APIKeyService) insertAPIKey(tx *gorm.DB, userID uint, opts APIKeyOptions) (*db.APIKey, error) {
+	scopes := opts.Scopes
+	if len(scopes) == 0 {
+		scopes = DefaultAPIKeyScopes
+	}
+	if err := ValidateScopes(scopes); err != nil {
+		return nil, err
+	}
+	allowedIPs, err := joinAllowedIPs(opts.AllowedIPs)
 	if err != nil {
 		return nil, err
 	}
 
-	var expiresAt *time.Time
-	if expiresIn != nil && *expiresIn > 0 {
-		t := time.Now().Add(time.Duration(*expiresIn) * 24 * time.Hour)
+	prefix, secret, err := generateKeyParts()
+	if err != nil {
+		return nil, err
+	}
+
+	var signingSecret, sealedSigningSecret string
+	if opts.Signing {
+		if signingSecret, err = generateRandomKey(); err != nil {
+			return nil, err
+		}
+		if sealedSigningSecret, err = s.box.Seal([]byte(signingSecret)); err != nil {
+			return nil, err
+		}
+	}
+
+	expiresAt := opts.expiresAt
+	if expiresAt == nil && opts.ExpiresIn != nil && *opts.ExpiresIn > 0 {
+		t := time.Now().Add(time.Duration(*opts.ExpiresIn) * 24 * time.Hour)
 		expiresAt = &t
 	}
 
 	apiKey := &db.APIKey{
-		Key:       key,
-		UserID:    userID,
-		Name:      name,
-		ExpiresAt: expiresAt,
+		Prefix:          prefix,
+		KeyHash:         s.hashSecret(secret),
+		UserID:          userID,
+		Name:            opts.Name,
+		Scopes:          JoinScopes(scopes),
+		RateLimit:       opts.RateLimit,
+		RateLimitWindow: opts.RateLimitWindow,
+		AllowedIPs:      allowedIPs,
+		Status:          db.APIKeyStatusActive,
+		ExpiresAt:       expiresAt,
+
+		SealedSigningSecret: sealedSigningSecret,
+		RequireClientCert:   opts.RequireClientCert,
 	}
 
-	if err := s.db.Create(apiKey).Error; err != nil {
+	if err := tx.Create(apiKey).Error; err != nil {
 		return nil, err
 	}
 
+	apiKey.Key = prefix + apiKeySeparator + secret
+	apiKey.SigningSecret = signingSecret
 	return apiKey, nil
 }
 
@@ -69,18 +188,12 @@ func (s *APIKeyService) ListAPIKeys(userID uint) ([]db.APIKey, error) {
 	return keys, nil
 }
 
-func (s *APIKeyService) RevokeAPIKey(userID, keyID uint) error {
-	result := s.db.Model(&db.APIKey{}).Where("id = ? AND user_id = ?", keyID, userID).Update("is_revoked", true)
-	if result.Error != nil {
-		return result.Error
-	}
-	if result.RowsAffected == 0 {
-		return ErrAPIKeyNotFound
-	}
-	return nil
-}
-
-func (s *APIKeyService) RotateAPIKey(userID, keyID uint) (*db.APIKey, error) {
+// RotateAPIKey issues a successor with the same name, scopes, limits, expiry,
+// IP allowlist and signing mode, with a new signing secret, moves the key's
+// client certificates to it and links it from the old key. With a zero
+// grace period the old key is marked rotated at once; otherwise both keys
+// validate until the grace period ends.
+func (s *APIKeyService) RotateAPIKey(userID, keyID uint, gracePeriod time.Duration) (*db.APIKey, error) {
 	var apiKey db.APIKey
 	if err := s.db.Where("id = ? AND user_id = ?", keyID, userID).First(&apiKey).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -89,45 +202,253 @@ func (s *APIKeyService) RotateAPIKey(userID, keyID uint) (*db.APIKey, error) {
 		return nil, err
 	}
 
-	s.db.Model(&db.APIKey{}).Where("id = ?", keyID).Update("is_revoked", true)
+	if err := apiKeyStatusError(apiKey.Status); err != nil {
+		return nil, err
+	}
+	// The sweeper may not have marked it yet.
+	if apiKey.ExpiresAt != nil && time.Now().After(*apiKey.ExpiresAt) {
+		return nil, ErrAPIKeyExpired
+	}
+	if apiKey.SuccessorID != nil {
+		return nil, ErrAPIKeyAlreadyRotated
+	}
+
+	var successor *db.APIKey
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		successor, err = s.insertAPIKey(tx, userID, APIKeyOptions{
+			Name:              apiKey.Name,
+			Scopes:            apiKey.ScopeList(),
+			RateLimit:         apiKey.RateLimit,
+			RateLimitWindow:   apiKey.RateLimitWindow,
+			AllowedIPs:        apiKey.AllowedIPList(),
+			Signing:           apiKey.RequiresSignature(),
+			RequireClientCert: apiKey.RequireClientCert,
+			expiresAt:         apiKey.ExpiresAt,
+		})
+		if err != nil {
+			return err
+		}
 
-	return s.GenerateAPIKey(userID, apiKey.Name, nil)
+		err = tx.Model(&db.ClientCertificate{}).Where("api_key_id = ?", keyID).Update("api_key_id", successor.ID).Error
+		if err != nil {
+			return err
+		}
+
+		updates := map[string]interface{}{"successor_id": successor.ID}
+		if gracePeriod > 0 {
+			updates["grace_expires_at"] = time.Now().Add(gracePeriod)
+		} else {
+			updates["status"] = db.APIKeyStatusRotated
+			updates["revoked_at"] = time.Now()
+			updates["revoked_by"] = userID
+		}
+		return tx.Model(&db.APIKey{}).Where("id = ?", keyID).Updates(updates).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.invalidate(keyID)
+
+	return successor, nil
 }
 
-func (s *APIKeyService) ValidateAPIKey(key string) (*db.APIKey, error) {
-	var apiKey db.APIKey
-	if err := s.db.Where("key = ?", key).First(&apiKey).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
+// ValidateAPIKey resolves a presented key. clientIP is the resolved address
+// of the caller, checked against the key's allowlist.
+func (s *APIKeyService) ValidateAPIKey(key, clientIP string) (*db.APIKey, error) {
+	prefix, secret, ok := splitAPIKey(key)
+	if !ok {
+		return nil, ErrInvalidAPIKey
+	}
+
+	hash := s.hashSecret(secret)
+	cacheKey := prefix + apiKeySeparator + hash
+	apiKey, found := s.cache.Get(cacheKey)
+	if !found {
+		generation := s.cache.Generation()
+		var candidates []db.APIKey
+		if err := s.db.Where("prefix = ?", prefix).Find(&candidates).Error; err != nil {
+			return nil, err
+		}
+		for i := range candidates {
+			if hmac.Equal([]byte(candidates[i].KeyHash), []byte(hash)) {
+				apiKey = &candidates[i]
+				break
+			}
+		}
+		if apiKey == nil {
+			s.cache.SetUnknown(cacheKey, generation)
 			return nil, ErrInvalidAPIKey
 		}
+		s.cache.Set(cacheKey, apiKey, generation)
+	} else if apiKey == nil {
+		return nil, ErrInvalidAPIKey
+	}
+
+	if apiKey.RequiresSignature() {
+		return nil, ErrSignatureRequired
+	}
+	if err := s.checkUsable(apiKey, clientIP); err != nil {
 		return nil, err
 	}
 
-	if apiKey.IsRevoked {
-		return nil, ErrAPIKeyRevoked
+	s.recordUse(apiKey.ID, time.Now())
+
+	return apiKey, nil
+}
+
+// checkUsable rejects a key that is not active, whose rotation grace period
+// is over, that is past its expiry or that is used from outside its IP
+// allowlist.
+func (s *APIKeyService) checkUsable(apiKey *db.APIKey, clientIP string) error {
+	if err := apiKeyStatusError(apiKey.Status); err != nil {
+		return err
+	}
+
+	if apiKey.GraceExpiresAt != nil && time.Now().After(*apiKey.GraceExpiresAt) {
+		s.db.Model(&db.APIKey{}).Where("id = ? AND status = ?", apiKey.ID, db.APIKeyStatusActive).Updates(map[string]interface{}{
+			"status":     db.APIKeyStatusRotated,
+			"revoked_at": *apiKey.GraceExpiresAt,
+		})
+		s.invalidate(apiKey.ID)
+		return ErrAPIKeyRevoked
 	}
 
 	if apiKey.ExpiresAt != nil && time.Now().After(*apiKey.ExpiresAt) {
-		return nil, ErrAPIKeyExpired
+		return ErrAPIKeyExpired
 	}
 
-	s.db.Model(&db.APIKey{}).Where("id = ?", apiKey.ID).Update("last_used_at", time.Now())
+	if !ipAllowed(apiKey, clientIP) {
+		return &IPNotAllowedError{APIKeyID: apiKey.ID, UserID: apiKey.UserID, IP: clientIP}
+	}
+	return nil
+}
 
-	return &apiKey, nil
+// MigratePlaintextKeys hashes keys that were stored in the legacy plaintext
+// "key" column and then drops that column. Legacy keys keep working: their
+// first characters become the lookup prefix and the whole key is the secret.
+func (s *APIKeyService) MigratePlaintextKeys() (int, error) {
+	// Key is not a mapped field any more, so look the column up by name.
+	table := db.APIKey{}.TableName()
+	columns, err := s.db.Migrator().ColumnTypes(table)
+	if err != nil {
+		return 0, err
+	}
+	hasPlaintext := false
+	for _, column := range columns {
+		if column.Name() == "key" {
+			hasPlaintext = true
+			break
+		}
+	}
+	if !hasPlaintext {
+		return 0, nil
+	}
+
+	type legacyKey struct {
+		ID  uint
+		Key string
+	}
+
+	var legacy []legacyKey
+	if err := s.db.Table(table).
+		Select("id", "key").
+		Where("key_hash IS NULL OR key_hash = ''").
+		Find(&legacy).Error; err != nil {
+		return 0, err
+	}
+
+	migrated := 0
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		for _, k := range legacy {
+			prefix, secret, ok := splitAPIKey(k.Key)
+			if !ok {
+				continue
+			}
+			if err := tx.Model(&db.APIKey{}).Where("id = ?", k.ID).Updates(map[string]interface{}{
+				"prefix":   prefix,
+				"key_hash": s.hashSecret(secret),
+			}).Error; err != nil {
+				return err
+			}
+			migrated++
+		}
+		return tx.Exec("ALTER TABLE api_keys DROP COLUMN key").Error
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return migrated, nil
+}
+
+// BackfillScopes grants every registered scope to keys created before scopes
+// existed, so they keep the access they had.
+func (s *APIKeyService) BackfillScopes() (int64, error) {
+	result := s.db.Model(&db.APIKey{}).
+		Where("scopes IS NULL OR scopes = ''").
+		Update("scopes", JoinScopes(AllScopes()))
+	return result.RowsAffected, result.Error
+}
+
+func (s *APIKeyService) hashSecret(secret string) string {
+	if s.cfg.APIKeyPepper == "" {
+		sum := sha256.Sum256([]byte(secret))
+		return hex.EncodeToString(sum[:])
+	}
+
+	mac := hmac.New(sha256.New, []byte(s.cfg.APIKeyPepper))
+	mac.Write([]byte(secret))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// splitAPIKey returns the lookup prefix and the secret of a presented key.
+// Keys issued before hashing was introduced have no separator; they are
+// looked up by their leading characters and hashed whole.
+func splitAPIKey(key string) (string, string, bool) {
+	if prefix, secret, found := strings.Cut(key, apiKeySeparator); found {
+		if prefix == "" || secret == "" {
+			return "", "", false
+		}
+		return prefix, secret, true
+	}
+
+	if len(key) < legacyKeyPrefixSize {
+		return "", "", false
+	}
+	return key[:legacyKeyPrefixSize], key, true
+}
+
+func generateKeyParts() (string, string, error) {
+	prefix, err := generateRandomHex(apiKeyPrefixBytes)
+	if err != nil {
+		return "", "", err
+	}
+
+	secret, err := generateRandomKey()
+	if err != nil {
+		return "", "", err
+	}
+
+	return prefix, secret, nil
 }
 
 func generateRandomKey() (string, error) {
-	bytes := make([]byte, 32)
+	return generateRandomHex(32)
+}
+
+func generateRandomHex(n int) (string, error) {
+	bytes := make([]byte, n)
 	if _, err := rand.Read(bytes); err != nil {
 		return "", err
 	}
 	return hex.EncodeToString(bytes), nil
 }
 
-func (s *APIKeyService) GetAPIKeyThroughItsName(userID uint, name string) (*db.APIKey, error) {
+func (s *APIKeyService) GetAPIKey(userID, keyID uint) (*db.APIKey, error) {
 	var apiKey db.APIKey
 
-	if err := s.db.Where("name = ? AND user_id = ?", name, userID).First(&apiKey).Error; err != nil {
+	if err := s.db.Where("id = ? AND user_id = ?", keyID, userID).First(&apiKey).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, ErrAPIKeyNotFound
 		}
@@ -137,15 +458,15 @@ func (s *APIKeyService) GetAPIKeyThroughItsName(userID uint, name string) (*db.A
 	return &apiKey, nil
 }
 
-func (s *APIKeyService) RevokeExpiredKeys(keyIDs []uint) error {
-	if len(keyIDs) == 0 {
-		return nil
-	}
+func (s *APIKeyService) GetAPIKeyThroughItsName(userID uint, name string) (*db.APIKey, error) {
+	var apiKey db.APIKey
 
-	// Update expired keys to revoked
-	result := s.db.Model(&db.APIKey{}).
-		Where("id IN ?", keyIDs).
-		Update("is_revoked", true)
+	if err := s.db.Where("name = ? AND user_id = ?", name, userID).First(&apiKey).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAPIKeyNotFound
+		}
+		return nil, err
+	}
 
-	return result.Error
+	return &apiKey, nil
 }
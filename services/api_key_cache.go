@@ -0,0 +1,194 @@
+package services
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/Brownei/api-generation-api/db"
+)
+
+// apiKeyCache is an LRU of validated keys, indexed by prefix and secret
+// hash so the plaintext key is never held. It also remembers keys that
+// don't exist, for a shorter time, so scans for valid keys don't reach the
+// database on every guess.
+type apiKeyCache struct {
+	mu          sync.Mutex
+	ttl         time.Duration
+	negativeTTL time.Duration
+	maxEntries  int
+	entries     map[string]*list.Element
+	byID        map[uint]string
+	// Most recently used at the front.
+	order *list.List
+	// Bumped by every eviction, so a lookup that raced one doesn't put back
+	// what was just evicted.
+	generation uint64
+}
+
+type apiKeyCacheEntry struct {
+	cacheKey string
+	// nil for a key that doesn't exist.
+	apiKey    *db.APIKey
+	expiresAt time.Time
+}
+
+func newAPIKeyCache(ttl, negativeTTL time.Duration, maxEntries int) *apiKeyCache {
+	return &apiKeyCache{
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		maxEntries:  max(maxEntries, 1),
+		entries:     make(map[string]*list.Element),
+		byID:        make(map[uint]string),
+		order:       list.New(),
+	}
+}
+
+// Get returns a copy of the cached key; found with a nil key means the key
+// is known not to exist.
+func (c *apiKeyCache) Get(cacheKey string) (apiKey *db.APIKey, found bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[cacheKey]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*apiKeyCacheEntry)
+	if time.Now().After(entry.expiresAt) {
+		c.remove(element)
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+
+	if entry.apiKey == nil {
+		return nil, true
+	}
+	copied := *entry.apiKey
+	return &copied, true
+}
+
+// Generation is read before looking a key up in the database and passed to
+// Set with the result.
+func (c *apiKeyCache) Generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation
+}
+
+// Set caches a key read from the database, unless an eviction has happened
+// since generation was read: the key may have been revoked after the read.
+func (c *apiKeyCache) Set(cacheKey string, apiKey *db.APIKey, generation uint64) {
+	copied := *apiKey
+	c.put(cacheKey, &copied, c.ttl, generation)
+}
+
+func (c *apiKeyCache) SetUnknown(cacheKey string, generation uint64) {
+	c.put(cacheKey, nil, c.negativeTTL, generation)
+}
+
+// Evict drops the entries of the given keys.
+func (c *apiKeyCache) Evict(keyIDs ...uint) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	for _, keyID := range keyIDs {
+		if cacheKey, ok := c.byID[keyID]; ok {
+			c.remove(c.entries[cacheKey])
+		}
+	}
+}
+
+func (c *apiKeyCache) put(cacheKey string, apiKey *db.APIKey, ttl time.Duration, generation uint64) {
+	if ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.generation != generation {
+		return
+	}
+
+	if element, ok := c.entries[cacheKey]; ok {
+		c.remove(element)
+	}
+	for c.order.Len() >= c.maxEntries {
+		c.remove(c.order.Back())
+	}
+
+	entry := &apiKeyCacheEntry{cacheKey: cacheKey, apiKey: apiKey, expiresAt: time.Now().Add(ttl)}
+	c.entries[cacheKey] = c.order.PushFront(entry)
+	if apiKey != nil {
+		c.byID[apiKey.ID] = cacheKey
+	}
+}
+
+func (c *apiKeyCache) remove(element *list.Element) {
+	entry := element.Value.(*apiKeyCacheEntry)
+	c.order.Remove(element)
+	delete(c.entries, entry.cacheKey)
+	if entry.apiKey != nil {
+		delete(c.byID, entry.apiKey.ID)
+	}
+}
+
+// APIKeyInvalidationBus tells other instances which keys changed so they
+// drop them from their caches. Without one, other instances notice a
+// revocation only once their cached entry expires.
+type APIKeyInvalidationBus interface {
+	Publish(keyIDs []uint) error
+	// Subscribe registers a handler for keys published by anyone,
+	// including the subscriber itself.
+	Subscribe(handler func(keyIDs []uint))
+}
+
+// InMemoryInvalidationBus delivers invalidations between services in the
+// same process.
+type InMemoryInvalidationBus struct {
+	mu       sync.RWMutex
+	handlers []func(keyIDs []uint)
+}
+
+func NewInMemoryInvalidationBus() *InMemoryInvalidationBus {
+	return &InMemoryInvalidationBus{}
+}
+
+func (b *InMemoryInvalidationBus) Publish(keyIDs []uint) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, handler := range b.handlers {
+		handler(keyIDs)
+	}
+	return nil
+}
+
+func (b *InMemoryInvalidationBus) Subscribe(handler func(keyIDs []uint)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers = append(b.handlers, handler)
+}
+
+// UseInvalidationBus shares cache invalidations with other instances
+// subscribed to bus.
+func (s *APIKeyService) UseInvalidationBus(bus APIKeyInvalidationBus) {
+	s.invalidationBus = bus
+	bus.Subscribe(func(keyIDs []uint) {
+		s.cache.Evict(keyIDs...)
+	})
+}
+
+// invalidate evicts changed keys here and, through the bus, everywhere
+// else. The database change has already happened, so a failed publish only
+// leaves other instances stale until their entries expire.
+func (s *APIKeyService) invalidate(keyIDs ...uint) {
+	if len(keyIDs) == 0 {
+		return
+	}
+	s.cache.Evict(keyIDs...)
+	if s.invalidationBus != nil {
+		s.invalidationBus.Publish(keyIDs)
+	}
+}
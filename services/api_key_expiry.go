@@ -0,0 +1,97 @@
+package services
+
+import (
+	"time"
+
+	"github.com/Brownei/api-generation-api/db"
+)
+
+// KeySweepResult counts the keys changed by one sweep.
+type KeySweepResult struct {
+	Expired int
+	// Keys whose rotation grace period ended.
+	Rotated int
+}
+
+// SweepExpiredKeys marks every active key past its expiry as expired and
+// finishes rotations whose grace period is over, across all users.
+func (s *APIKeyService) SweepExpiredKeys(now time.Time) (KeySweepResult, error) {
+	var result KeySweepResult
+
+	var expired []uint
+	err := s.db.Model(&db.APIKey{}).
+		Where("status = ? AND expires_at IS NOT NULL AND expires_at <= ?", db.APIKeyStatusActive, now).
+		Pluck("id", &expired).Error
+	if err != nil {
+		return result, err
+	}
+	if len(expired) > 0 {
+		err := s.db.Model(&db.APIKey{}).
+			Where("id IN ? AND status = ?", expired, db.APIKeyStatusActive).
+			Update("status", db.APIKeyStatusExpired).Error
+		if err != nil {
+			return result, err
+		}
+		s.invalidate(expired...)
+		result.Expired = len(expired)
+	}
+
+	var graceOver []uint
+	err = s.db.Model(&db.APIKey{}).
+		Where("status = ? AND grace_expires_at IS NOT NULL AND grace_expires_at <= ?", db.APIKeyStatusActive, now).
+		Pluck("id", &graceOver).Error
+	if err != nil {
+		return result, err
+	}
+	if len(graceOver) > 0 {
+		err := s.db.Model(&db.APIKey{}).
+			Where("id IN ? AND status = ?", graceOver, db.APIKeyStatusActive).
+			Updates(map[string]interface{}{
+				"status":     db.APIKeyStatusRotated,
+				"revoked_at": now,
+			}).Error
+		if err != nil {
+			return result, err
+		}
+		s.invalidate(graceOver...)
+		result.Rotated = len(graceOver)
+	}
+
+	return result, nil
+}
+
+// StartExpirySweeper sweeps expired keys every interval in the background.
+// It does nothing for a non-positive interval.
+func (s *APIKeyService) StartExpirySweeper(interval time.Duration, onError func(error)) {
+	if interval <= 0 || s.sweepStop != nil {
+		return
+	}
+	s.sweepStop = make(chan struct{})
+	s.sweepDone = make(chan struct{})
+
+	go func() {
+		defer close(s.sweepDone)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.sweepStop:
+				return
+			case <-ticker.C:
+				if _, err := s.SweepExpiredKeys(time.Now()); err != nil && onError != nil {
+					onError(err)
+				}
+			}
+		}
+	}()
+}
+
+func (s *APIKeyService) StopExpirySweeper() {
+	if s.sweepStop == nil {
+		return
+	}
+	close(s.sweepStop)
+	<-s.sweepDone
+	s.sweepStop = nil
+}
@@ -0,0 +1,124 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/Brownei/api-generation-api/db"
+	"gorm.io/gorm"
+)
+
+// MaxAllowedIPs caps the entries in one key's IP allowlist.
+const MaxAllowedIPs = 100
+
+var (
+	ErrAPIKeyIPNotAllowed = errors.New("API key may not be used from this IP address")
+	ErrInvalidAllowedIP   = errors.New("invalid allowed IP")
+)
+
+// IPNotAllowedError is returned by ValidateAPIKey for a valid key presented
+// from outside its allowlist. It carries the key so the attempt can be
+// audited against its owner.
+type IPNotAllowedError struct {
+	APIKeyID uint
+	UserID   uint
+	IP       string
+}
+
+func (e *IPNotAllowedError) Error() string {
+	return ErrAPIKeyIPNotAllowed.Error()
+}
+
+func (e *IPNotAllowedError) Unwrap() error {
+	return ErrAPIKeyIPNotAllowed
+}
+
+// joinAllowedIPs normalises IPs and CIDRs into the space separated form
+// stored on a key. Single addresses become /32 or /128 prefixes and host
+// bits are cleared, so every entry is a canonical prefix.
+func joinAllowedIPs(entries []string) (string, error) {
+	if len(entries) > MaxAllowedIPs {
+		return "", fmt.Errorf("%w: at most %d entries", ErrInvalidAllowedIP, MaxAllowedIPs)
+	}
+
+	seen := make(map[netip.Prefix]bool, len(entries))
+	prefixes := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		var prefix netip.Prefix
+		if strings.Contains(entry, "/") {
+			parsed, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return "", fmt.Errorf("%w: %q", ErrInvalidAllowedIP, entry)
+			}
+			prefix = parsed.Masked()
+		} else {
+			addr, err := netip.ParseAddr(entry)
+			if err != nil || addr.Zone() != "" {
+				return "", fmt.Errorf("%w: %q", ErrInvalidAllowedIP, entry)
+			}
+			addr = addr.Unmap()
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+
+		if seen[prefix] {
+			continue
+		}
+		seen[prefix] = true
+		prefixes = append(prefixes, prefix.String())
+	}
+	return strings.Join(prefixes, " "), nil
+}
+
+// ipAllowed reports whether clientIP falls in the key's allowlist. A key
+// without one accepts any address; a key with one rejects an address that
+// can't be parsed.
+func ipAllowed(apiKey *db.APIKey, clientIP string) bool {
+	allowed := apiKey.AllowedIPList()
+	if len(allowed) == 0 {
+		return true
+	}
+
+	addr, err := netip.ParseAddr(clientIP)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap().WithZone("")
+	for _, entry := range allowed {
+		prefix, err := netip.ParsePrefix(entry)
+		if err == nil && prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetAllowedIPs replaces the IP allowlist of one of the user's keys without
+// changing the key itself. An empty list lifts the restriction.
+func (s *APIKeyService) SetAllowedIPs(userID, keyID uint, entries []string) (*db.APIKey, error) {
+	allowedIPs, err := joinAllowedIPs(entries)
+	if err != nil {
+		return nil, err
+	}
+
+	var apiKey db.APIKey
+	if err := s.db.Where("id = ? AND user_id = ?", keyID, userID).First(&apiKey).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAPIKeyNotFound
+		}
+		return nil, err
+	}
+	if err := apiKeyStatusError(apiKey.Status); err != nil {
+		return nil, err
+	}
+
+	if err := s.db.Model(&db.APIKey{}).Where("id = ?", apiKey.ID).Update("allowed_ips", allowedIPs).Error; err != nil {
+		return nil, err
+	}
+	s.invalidate(apiKey.ID)
+	apiKey.AllowedIPs = allowedIPs
+
+	return &apiKey, nil
+}
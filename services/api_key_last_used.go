@@ -0,0 +1,119 @@
+package services
+
+import (
+	"time"
+
+	"github.com/Brownei/api-generation-api/db"
+	"gorm.io/gorm"
+)
+
+// recordUse notes that a key was just used. Once StartLastUsedFlush has run
+// the timestamp is only kept in memory until the next flush; before that it
+// is written straight away.
+func (s *APIKeyService) recordUse(keyID uint, at time.Time) {
+	s.lastUsedMu.Lock()
+	if s.lastUsed != nil {
+		if at.After(s.lastUsed[keyID]) {
+			s.lastUsed[keyID] = at
+		}
+		s.lastUsedMu.Unlock()
+		return
+	}
+	s.lastUsedMu.Unlock()
+
+	s.db.Model(&db.APIKey{}).Where("id = ?", keyID).Update("last_used_at", at)
+}
+
+// FlushLastUsed writes every buffered last-used timestamp in one
+// transaction. Timestamps never move backwards, so instances flushing the
+// same key in any order agree. On failure the timestamps are kept for the
+// next flush.
+func (s *APIKeyService) FlushLastUsed() error {
+	s.lastUsedMu.Lock()
+	pending := s.lastUsed
+	if len(pending) == 0 {
+		s.lastUsedMu.Unlock()
+		return nil
+	}
+	s.lastUsed = make(map[uint]time.Time, len(pending))
+	s.lastUsedMu.Unlock()
+
+	err := s.writeLastUsed(pending)
+	if err != nil {
+		s.lastUsedMu.Lock()
+		if s.lastUsed != nil {
+			for keyID, at := range pending {
+				if at.After(s.lastUsed[keyID]) {
+					s.lastUsed[keyID] = at
+				}
+			}
+		}
+		s.lastUsedMu.Unlock()
+	}
+	return err
+}
+
+func (s *APIKeyService) writeLastUsed(pending map[uint]time.Time) error {
+	if len(pending) == 0 {
+		return nil
+	}
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		for keyID, at := range pending {
+			err := tx.Model(&db.APIKey{}).
+				Where("id = ? AND (last_used_at IS NULL OR last_used_at < ?)", keyID, at).
+				Update("last_used_at", at).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// StartLastUsedFlush buffers last-used timestamps and flushes them every
+// interval in the background. It does nothing for a non-positive interval,
+// leaving every use written as it happens.
+func (s *APIKeyService) StartLastUsedFlush(interval time.Duration, onError func(error)) {
+	if interval <= 0 || s.flushStop != nil {
+		return
+	}
+	s.lastUsedMu.Lock()
+	s.lastUsed = make(map[uint]time.Time)
+	s.lastUsedMu.Unlock()
+	s.flushStop = make(chan struct{})
+	s.flushDone = make(chan struct{})
+
+	go func() {
+		defer close(s.flushDone)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.flushStop:
+				return
+			case <-ticker.C:
+				if err := s.FlushLastUsed(); err != nil && onError != nil {
+					onError(err)
+				}
+			}
+		}
+	}()
+}
+
+// StopLastUsedFlush stops the background flush and writes what is still
+// buffered. Later uses are written as they happen again.
+func (s *APIKeyService) StopLastUsedFlush() error {
+	if s.flushStop == nil {
+		return nil
+	}
+	close(s.flushStop)
+	<-s.flushDone
+	s.flushStop = nil
+
+	s.lastUsedMu.Lock()
+	pending := s.lastUsed
+	s.lastUsed = nil
+	s.lastUsedMu.Unlock()
+	return s.writeLastUsed(pending)
+}
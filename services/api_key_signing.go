@@ -0,0 +1,164 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Brownei/api-generation-api/db"
+)
+
+const maxNonceLength = 128
+
+var (
+	ErrSignatureRequired = errors.New("API key requires signed requests")
+	ErrInvalidSignature  = errors.New("invalid request signature")
+	ErrSignatureExpired  = errors.New("request timestamp is outside the allowed window")
+	ErrNonceReused       = errors.New("request nonce has already been used")
+)
+
+// SignedRequest is what a client in signing mode sends instead of its key.
+// KeyID is the key's prefix, Timestamp is in Unix seconds and BodyHash is
+// the hex SHA-256 of the request body.
+type SignedRequest struct {
+	KeyID     string
+	Method    string
+	Path      string
+	Timestamp string
+	BodyHash  string
+	Nonce     string
+	Signature string
+}
+
+// StringToSign joins the signed parts of a request, one per line.
+func (r SignedRequest) StringToSign() string {
+	return strings.Join([]string{strings.ToUpper(r.Method), r.Path, r.Timestamp, r.BodyHash, r.Nonce}, "\n")
+}
+
+// SignRequest returns the hex HMAC-SHA256 of the request under secret, as a
+// client puts it in X-Signature.
+func SignRequest(secret string, r SignedRequest) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(r.StringToSign()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// HashBody returns the hex SHA-256 of a request body.
+func HashBody(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// NonceStore remembers the nonces of signed requests until they expire. The
+// in-memory store is per instance; a shared store lets instances behind the
+// same load balancer catch each other's replays.
+type NonceStore interface {
+	// Use records the nonce and reports whether it was unseen.
+	Use(keyID uint, nonce string, expiresAt time.Time) (bool, error)
+}
+
+type InMemoryNonceStore struct {
+	mu          sync.Mutex
+	nonces      map[string]time.Time
+	lastCleanup time.Time
+}
+
+func NewInMemoryNonceStore() *InMemoryNonceStore {
+	return &InMemoryNonceStore{nonces: make(map[string]time.Time)}
+}
+
+func (s *InMemoryNonceStore) Use(keyID uint, nonce string, expiresAt time.Time) (bool, error) {
+	now := time.Now()
+	key := strconv.FormatUint(uint64(keyID), 10) + ":" + nonce
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cleanup(now)
+	if seenUntil, ok := s.nonces[key]; ok && now.Before(seenUntil) {
+		return false, nil
+	}
+	s.nonces[key] = expiresAt
+	return true, nil
+}
+
+func (s *InMemoryNonceStore) cleanup(now time.Time) {
+	if now.Sub(s.lastCleanup) < time.Minute {
+		return
+	}
+	s.lastCleanup = now
+
+	for key, expiresAt := range s.nonces {
+		if !now.Before(expiresAt) {
+			delete(s.nonces, key)
+		}
+	}
+}
+
+// UseNonceStore replaces the in-memory nonce store, e.g. with one shared by
+// every instance.
+func (s *APIKeyService) UseNonceStore(store NonceStore) {
+	s.nonces = store
+}
+
+// VerifySignedRequest resolves the key a signed request was made with. The
+// nonce is only recorded once the signature checks out, so a forged request
+// can't burn a nonce the real client is about to use.
+func (s *APIKeyService) VerifySignedRequest(req SignedRequest, clientIP string) (*db.APIKey, error) {
+	unix, err := strconv.ParseInt(req.Timestamp, 10, 64)
+	if err != nil || req.KeyID == "" || req.Nonce == "" || len(req.Nonce) > maxNonceLength {
+		return nil, ErrInvalidSignature
+	}
+	signedAt := time.Unix(unix, 0)
+	now := time.Now()
+	if signedAt.Before(now.Add(-s.cfg.SignatureMaxSkew)) || signedAt.After(now.Add(s.cfg.SignatureMaxSkew)) {
+		return nil, ErrSignatureExpired
+	}
+
+	signature, err := hex.DecodeString(req.Signature)
+	if err != nil {
+		return nil, ErrInvalidSignature
+	}
+
+	var candidates []db.APIKey
+	err = s.db.Where("prefix = ? AND sealed_signing_secret <> ''", req.KeyID).Find(&candidates).Error
+	if err != nil {
+		return nil, err
+	}
+	var apiKey *db.APIKey
+	for i := range candidates {
+		secret, err := s.box.Open(candidates[i].SealedSigningSecret)
+		if err != nil {
+			return nil, err
+		}
+		expected, _ := hex.DecodeString(SignRequest(string(secret), req))
+		if hmac.Equal(expected, signature) {
+			apiKey = &candidates[i]
+			break
+		}
+	}
+	if apiKey == nil {
+		return nil, ErrInvalidSignature
+	}
+
+	if err := s.checkUsable(apiKey, clientIP); err != nil {
+		return nil, err
+	}
+
+	fresh, err := s.nonces.Use(apiKey.ID, req.Nonce, signedAt.Add(s.cfg.SignatureMaxSkew))
+	if err != nil {
+		return nil, err
+	}
+	if !fresh {
+		return nil, ErrNonceReused
+	}
+
+	s.recordUse(apiKey.ID, now)
+
+	return apiKey, nil
+}
@@ -0,0 +1,182 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"github.com/Brownei/api-generation-api/db"
+	"gorm.io/gorm"
+)
+
+var ErrAPIKeyNotActive = errors.New("API key is not active")
+
+// apiKeyStatusError is why a key in the given state can't be used.
+func apiKeyStatusError(status string) error {
+	switch status {
+	case db.APIKeyStatusActive:
+		return nil
+	case db.APIKeyStatusExpired:
+		return ErrAPIKeyExpired
+	case db.APIKeyStatusSuspended:
+		return ErrAPIKeySuspended
+	default:
+		return ErrAPIKeyRevoked
+	}
+}
+
+// RevokeAPIKey permanently revokes one of the user's keys. Revoking a key
+// that is already revoked or rotated out leaves its original record alone.
+func (s *APIKeyService) RevokeAPIKey(userID, keyID uint, reason string) error {
+	var apiKey db.APIKey
+	if err := s.db.Where("id = ? AND user_id = ?", keyID, userID).First(&apiKey).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrAPIKeyNotFound
+		}
+		return err
+	}
+	if apiKey.Status == db.APIKeyStatusRevoked || apiKey.Status == db.APIKeyStatusRotated {
+		return nil
+	}
+
+	return s.setStatus(&apiKey, db.APIKeyStatusRevoked, userID, reason)
+}
+
+// SuspendAPIKey disables any user's active key until an admin reactivates
+// it. The caller must have checked that adminID is an admin.
+func (s *APIKeyService) SuspendAPIKey(adminID, keyID uint, reason string) error {
+	var apiKey db.APIKey
+	if err := s.db.First(&apiKey, keyID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrAPIKeyNotFound
+		}
+		return err
+	}
+	if apiKey.Status == db.APIKeyStatusSuspended {
+		return nil
+	}
+	if apiKey.Status != db.APIKeyStatusActive {
+		return ErrAPIKeyNotActive
+	}
+
+	return s.setStatus(&apiKey, db.APIKeyStatusSuspended, adminID, reason)
+}
+
+// ReactivateAPIKey makes a suspended key active again, as long as that
+// doesn't take its owner past MaxActiveAPIKeys. A key that expired while
+// suspended is swept as expired afterwards.
+func (s *APIKeyService) ReactivateAPIKey(keyID uint) error {
+	var apiKey db.APIKey
+	if err := s.db.First(&apiKey, keyID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrAPIKeyNotFound
+		}
+		return err
+	}
+	if apiKey.Status != db.APIKeyStatusSuspended {
+		return ErrAPIKeyNotSuspended
+	}
+	// The owner may have replaced the key while it was suspended.
+	if err := s.checkActiveKeyLimit(apiKey.UserID); err != nil {
+		return err
+	}
+
+	result := s.db.Model(&db.APIKey{}).
+		Where("id = ? AND status = ?", keyID, db.APIKeyStatusSuspended).
+		Updates(map[string]interface{}{
+			"status":            db.APIKeyStatusActive,
+			"revoked_at":        nil,
+			"revoked_by":        nil,
+			"revocation_reason": "",
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrAPIKeyNotSuspended
+	}
+
+	s.invalidate(keyID)
+	return nil
+}
+
+// setStatus moves the key out of its current state, failing if someone
+// else changed the state first.
+func (s *APIKeyService) setStatus(apiKey *db.APIKey, status string, actorID uint, reason string) error {
+	result := s.db.Model(&db.APIKey{}).
+		Where("id = ? AND status = ?", apiKey.ID, apiKey.Status).
+		Updates(map[string]interface{}{
+			"status":            status,
+			"revoked_at":        time.Now(),
+			"revoked_by":        actorID,
+			"revocation_reason": reason,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrAPIKeyNotActive
+	}
+
+	s.invalidate(apiKey.ID)
+	return nil
+}
+
+// MigrateKeyStatus converts the is_revoked and is_expired flags that
+// preceded the status column and then drops them. Revoked keys with a
+// successor were rotated out, and revoked keys already past their expiry
+// were revoked by the old list-time expiry check, so they count as expired.
+func (s *APIKeyService) MigrateKeyStatus() (int64, error) {
+	// The flags aren't mapped fields any more, so look the columns up by name.
+	migrator := s.db.Migrator()
+	table := db.APIKey{}.TableName()
+	hasRevoked := migrator.HasColumn(table, "is_revoked")
+	hasExpired := migrator.HasColumn(table, "is_expired")
+	if !hasRevoked && !hasExpired {
+		return 0, nil
+	}
+
+	var migrated int64
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		type conversion struct {
+			where  string
+			status string
+		}
+		var conversions []conversion
+		if hasRevoked {
+			conversions = append(conversions,
+				conversion{"is_revoked = true AND successor_id IS NOT NULL", db.APIKeyStatusRotated},
+				conversion{"is_revoked = true AND expires_at IS NOT NULL AND expires_at <= updated_at", db.APIKeyStatusExpired},
+				conversion{"is_revoked = true", db.APIKeyStatusRevoked},
+			)
+		}
+		if hasExpired {
+			conversions = append(conversions, conversion{"is_expired = true", db.APIKeyStatusExpired})
+		}
+
+		// Earlier conversions win: each only touches keys still active.
+		for _, c := range conversions {
+			result := tx.Table(table).
+				Where("status = ? OR status IS NULL", db.APIKeyStatusActive).
+				Where(c.where).
+				Update("status", c.status)
+			if result.Error != nil {
+				return result.Error
+			}
+			migrated += result.RowsAffected
+		}
+
+		if hasRevoked {
+			if err := tx.Exec("ALTER TABLE api_keys DROP COLUMN is_revoked").Error; err != nil {
+				return err
+			}
+		}
+		if hasExpired {
+			return tx.Exec("ALTER TABLE api_keys DROP COLUMN is_expired").Error
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return migrated, nil
+}
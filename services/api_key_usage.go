@@ -0,0 +1,416 @@
+package services
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Brownei/api-generation-api/db"
+	"gorm.io/gorm"
+)
+
+const (
+	UsageGranularityHour = "hour"
+	UsageGranularityDay  = "day"
+)
+
+const (
+	usageCursorID       = 1
+	usageAggregatePage  = 1000
+	usageTopEndpoints   = 10
+	maxUsageHourBuckets = 31 * 24
+	maxUsageDayBuckets  = 366
+)
+
+// Upper bounds, in milliseconds, of the latency histogram buckets. One more
+// bucket counts everything slower.
+var usageLatencyBounds = []int64{5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+var (
+	ErrInvalidUsageGranularity = errors.New("granularity must be hour or day")
+	ErrInvalidUsageRange       = errors.New("from must be before to")
+	ErrUsageRangeTooLarge      = errors.New("range covers too many buckets; at most 31 days hourly or 366 days daily")
+
+	errUsageCursorMoved = errors.New("usage cursor moved")
+)
+
+// UsageService folds access logs of API key requests into hourly per-key,
+// per-endpoint rollups and reports on them. The rollups outlive the access
+// logs they were built from, so usage history survives retention.
+type UsageService struct {
+	db *gorm.DB
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewUsageService(database *gorm.DB) *UsageService {
+	return &UsageService{db: database}
+}
+
+// UsageStats summarises a set of requests. Errors are responses with a
+// status of 400 or above; latencies are in milliseconds and are the upper
+// bound of the histogram bucket the percentile falls in.
+type UsageStats struct {
+	Requests   int64 `json:"requests"`
+	Errors     int64 `json:"errors"`
+	LatencyP50 int64 `json:"latency_p50_ms"`
+	LatencyP95 int64 `json:"latency_p95_ms"`
+	LatencyP99 int64 `json:"latency_p99_ms"`
+}
+
+type UsageBucket struct {
+	Start time.Time `json:"start"`
+	UsageStats
+}
+
+type EndpointUsage struct {
+	Method   string `json:"method"`
+	Route    string `json:"route"`
+	Requests int64  `json:"requests"`
+	Errors   int64  `json:"errors"`
+}
+
+// APIKeyUsageReport covers [From, To). Buckets has an entry for every
+// bucket in the range, including empty ones.
+type APIKeyUsageReport struct {
+	APIKeyID     uint            `json:"api_key_id"`
+	Granularity  string          `json:"granularity"`
+	From         time.Time       `json:"from"`
+	To           time.Time       `json:"to"`
+	Totals       UsageStats      `json:"totals"`
+	Buckets      []UsageBucket   `json:"buckets"`
+	TopEndpoints []EndpointUsage `json:"top_endpoints"`
+}
+
+// Aggregate folds every access log written since the last run into the
+// rollups and returns how many were read.
+func (s *UsageService) Aggregate() (int, error) {
+	total := 0
+	for {
+		read, err := s.aggregatePage()
+		total += read
+		if errors.Is(err, errUsageCursorMoved) {
+			// Another instance is aggregating the same logs.
+			return total, nil
+		}
+		if err != nil || read < usageAggregatePage {
+			return total, err
+		}
+	}
+}
+
+type usageRollupKey struct {
+	apiKeyID    uint
+	bucketStart time.Time
+	method      string
+	route       string
+}
+
+func (s *UsageService) aggregatePage() (int, error) {
+	read := 0
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var cursor db.APIKeyUsageCursor
+		if err := tx.FirstOrCreate(&cursor, db.APIKeyUsageCursor{ID: usageCursorID}).Error; err != nil {
+			return err
+		}
+
+		var logs []db.AccessLogs
+		err := tx.Where("id > ? AND api_key_id IS NOT NULL", cursor.LastLogID).
+			Order("id ASC").Limit(usageAggregatePage).Find(&logs).Error
+		if err != nil || len(logs) == 0 {
+			return err
+		}
+		read = len(logs)
+
+		rollups := make(map[usageRollupKey]*usageRollup)
+		var order []usageRollupKey
+		for i := range logs {
+			log := &logs[i]
+			route := log.Route
+			if route == "" {
+				// Logged before route patterns were recorded.
+				route = log.Path
+			}
+			key := usageRollupKey{
+				apiKeyID:    *log.APIKeyID,
+				bucketStart: log.Timestamp.UTC().Truncate(time.Hour),
+				method:      log.Method,
+				route:       route,
+			}
+			rollup, ok := rollups[key]
+			if !ok {
+				rollup = newUsageRollup()
+				rollups[key] = rollup
+				order = append(order, key)
+			}
+			rollup.add(log.StatusCode, log.Duration)
+		}
+
+		for _, key := range order {
+			var row db.APIKeyUsage
+			err := tx.Where("api_key_id = ? AND bucket_start = ? AND method = ? AND route = ?",
+				key.apiKeyID, key.bucketStart, key.method, key.route).First(&row).Error
+			if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+				return err
+			}
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				row = db.APIKeyUsage{
+					APIKeyID:    key.apiKeyID,
+					BucketStart: key.bucketStart,
+					Method:      key.method,
+					Route:       key.route,
+				}
+			}
+
+			merged := usageRollupFromRow(&row)
+			merged.merge(rollups[key])
+			merged.toRow(&row)
+			if err := tx.Save(&row).Error; err != nil {
+				return err
+			}
+		}
+
+		result := tx.Model(&db.APIKeyUsageCursor{}).
+			Where("id = ? AND last_log_id = ?", usageCursorID, cursor.LastLogID).
+			Updates(map[string]interface{}{
+				"last_log_id": logs[len(logs)-1].ID,
+				"updated_at":  time.Now(),
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errUsageCursorMoved
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return read, nil
+}
+
+// Usage reports on one of the user's keys. from is rounded down to the
+// start of its bucket and to up to the end of its bucket.
+func (s *UsageService) Usage(userID, keyID uint, from, to time.Time, granularity string) (*APIKeyUsageReport, error) {
+	var step time.Duration
+	var maxBuckets int
+	switch granularity {
+	case UsageGranularityHour:
+		step, maxBuckets = time.Hour, maxUsageHourBuckets
+	case UsageGranularityDay:
+		step, maxBuckets = 24*time.Hour, maxUsageDayBuckets
+	default:
+		return nil, ErrInvalidUsageGranularity
+	}
+
+	from = from.UTC().Truncate(step)
+	if end := to.UTC().Truncate(step); end.Before(to) {
+		to = end.Add(step)
+	} else {
+		to = end
+	}
+	if !from.Before(to) {
+		return nil, ErrInvalidUsageRange
+	}
+	if int(to.Sub(from)/step) > maxBuckets {
+		return nil, ErrUsageRangeTooLarge
+	}
+
+	var count int64
+	if err := s.db.Model(&db.APIKey{}).Where("id = ? AND user_id = ?", keyID, userID).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, ErrAPIKeyNotFound
+	}
+
+	var rows []db.APIKeyUsage
+	err := s.db.Where("api_key_id = ? AND bucket_start >= ? AND bucket_start < ?", keyID, from, to).
+		Order("bucket_start ASC").Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	total := newUsageRollup()
+	buckets := make([]*usageRollup, int(to.Sub(from)/step))
+	for i := range buckets {
+		buckets[i] = newUsageRollup()
+	}
+	endpoints := make(map[[2]string]*EndpointUsage)
+	for i := range rows {
+		rollup := usageRollupFromRow(&rows[i])
+		total.merge(rollup)
+		buckets[int(rows[i].BucketStart.UTC().Sub(from)/step)].merge(rollup)
+
+		endpointKey := [2]string{rows[i].Method, rows[i].Route}
+		endpoint, ok := endpoints[endpointKey]
+		if !ok {
+			endpoint = &EndpointUsage{Method: rows[i].Method, Route: rows[i].Route}
+			endpoints[endpointKey] = endpoint
+		}
+		endpoint.Requests += rows[i].RequestCount
+		endpoint.Errors += rows[i].ErrorCount
+	}
+
+	report := &APIKeyUsageReport{
+		APIKeyID:     keyID,
+		Granularity:  granularity,
+		From:         from,
+		To:           to,
+		Totals:       total.stats(),
+		Buckets:      make([]UsageBucket, len(buckets)),
+		TopEndpoints: make([]EndpointUsage, 0, len(endpoints)),
+	}
+	for i, bucket := range buckets {
+		report.Buckets[i] = UsageBucket{Start: from.Add(time.Duration(i) * step), UsageStats: bucket.stats()}
+	}
+	for _, endpoint := range endpoints {
+		report.TopEndpoints = append(report.TopEndpoints, *endpoint)
+	}
+	sort.Slice(report.TopEndpoints, func(i, j int) bool {
+		a, b := report.TopEndpoints[i], report.TopEndpoints[j]
+		if a.Requests != b.Requests {
+			return a.Requests > b.Requests
+		}
+		return a.Method+" "+a.Route < b.Method+" "+b.Route
+	})
+	if len(report.TopEndpoints) > usageTopEndpoints {
+		report.TopEndpoints = report.TopEndpoints[:usageTopEndpoints]
+	}
+	return report, nil
+}
+
+// Start aggregates every interval in the background. It does nothing for a
+// non-positive interval.
+func (s *UsageService) Start(interval time.Duration, onError func(error)) {
+	if interval <= 0 || s.stop != nil {
+		return
+	}
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+				if _, err := s.Aggregate(); err != nil && onError != nil {
+					onError(err)
+				}
+			}
+		}
+	}()
+}
+
+func (s *UsageService) Stop() {
+	if s.stop == nil {
+		return
+	}
+	close(s.stop)
+	<-s.done
+	s.stop = nil
+}
+
+// usageRollup is the in-memory form of an APIKeyUsage row.
+type usageRollup struct {
+	requests      int64
+	errors        int64
+	totalDuration int64
+	maxDuration   int64
+	histogram     []int64
+}
+
+func newUsageRollup() *usageRollup {
+	return &usageRollup{histogram: make([]int64, len(usageLatencyBounds)+1)}
+}
+
+func usageRollupFromRow(row *db.APIKeyUsage) *usageRollup {
+	rollup := newUsageRollup()
+	rollup.requests = row.RequestCount
+	rollup.errors = row.ErrorCount
+	rollup.totalDuration = row.TotalDuration
+	rollup.maxDuration = row.MaxDuration
+	for i, field := range strings.Fields(row.LatencyHistogram) {
+		if i < len(rollup.histogram) {
+			rollup.histogram[i], _ = strconv.ParseInt(field, 10, 64)
+		}
+	}
+	return rollup
+}
+
+func (r *usageRollup) toRow(row *db.APIKeyUsage) {
+	row.RequestCount = r.requests
+	row.ErrorCount = r.errors
+	row.TotalDuration = r.totalDuration
+	row.MaxDuration = r.maxDuration
+
+	fields := make([]string, len(r.histogram))
+	for i, count := range r.histogram {
+		fields[i] = strconv.FormatInt(count, 10)
+	}
+	row.LatencyHistogram = strings.Join(fields, " ")
+}
+
+func (r *usageRollup) add(statusCode int, duration int64) {
+	r.requests++
+	if statusCode >= 400 {
+		r.errors++
+	}
+	r.totalDuration += duration
+	r.maxDuration = max(r.maxDuration, duration)
+
+	bucket := sort.Search(len(usageLatencyBounds), func(i int) bool { return duration <= usageLatencyBounds[i] })
+	r.histogram[bucket]++
+}
+
+func (r *usageRollup) merge(other *usageRollup) {
+	r.requests += other.requests
+	r.errors += other.errors
+	r.totalDuration += other.totalDuration
+	r.maxDuration = max(r.maxDuration, other.maxDuration)
+	for i := range r.histogram {
+		r.histogram[i] += other.histogram[i]
+	}
+}
+
+func (r *usageRollup) stats() UsageStats {
+	return UsageStats{
+		Requests:   r.requests,
+		Errors:     r.errors,
+		LatencyP50: r.percentile(0.50),
+		LatencyP95: r.percentile(0.95),
+		LatencyP99: r.percentile(0.99),
+	}
+}
+
+// percentile never reports more than the slowest request seen, which also
+// stands in for the open-ended last bucket.
+func (r *usageRollup) percentile(p float64) int64 {
+	var counted int64
+	for _, count := range r.histogram {
+		counted += count
+	}
+	if counted == 0 {
+		return 0
+	}
+
+	rank := int64(math.Ceil(p * float64(counted)))
+	var cumulative int64
+	for i, count := range r.histogram {
+		cumulative += count
+		if cumulative >= rank && i < len(usageLatencyBounds) {
+			return min(usageLatencyBounds[i], r.maxDuration)
+		}
+	}
+	return r.maxDuration
+}
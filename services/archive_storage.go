@@ -0,0 +1,67 @@
+package services
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// ArchiveStorage stores audit log archives. Nothing written through an
+// ArchiveWriter counts as archived until Commit succeeds.
+type ArchiveStorage interface {
+	Create(name string) (ArchiveWriter, error)
+}
+
+type ArchiveWriter interface {
+	io.Writer
+	// Commit makes the archive durable and returns where it was stored.
+	Commit() (string, error)
+	Abort() error
+}
+
+// LocalArchiveStorage keeps archives as files in a directory. Files are
+// written under a temporary name and renamed into place on commit.
+type LocalArchiveStorage struct {
+	dir string
+}
+
+func NewLocalArchiveStorage(dir string) *LocalArchiveStorage {
+	return &LocalArchiveStorage{dir: dir}
+}
+
+func (s *LocalArchiveStorage) Create(name string) (ArchiveWriter, error) {
+	if err := os.MkdirAll(s.dir, 0o750); err != nil {
+		return nil, err
+	}
+	file, err := os.CreateTemp(s.dir, name+".tmp-*")
+	if err != nil {
+		return nil, err
+	}
+	return &localArchiveWriter{File: file, path: filepath.Join(s.dir, name)}, nil
+}
+
+type localArchiveWriter struct {
+	*os.File
+	path string
+}
+
+func (w *localArchiveWriter) Commit() (string, error) {
+	if err := w.Sync(); err != nil {
+		w.Abort()
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		os.Remove(w.Name())
+		return "", err
+	}
+	if err := os.Rename(w.Name(), w.path); err != nil {
+		os.Remove(w.Name())
+		return "", err
+	}
+	return w.path, nil
+}
+
+func (w *localArchiveWriter) Abort() error {
+	w.Close()
+	return os.Remove(w.Name())
+}
@@ -0,0 +1,152 @@
+package services
+
+import (
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Brownei/api-generation-api/db"
+	"gorm.io/gorm"
+)
+
+const auditArchiveBatchSize = 5000
+
+// UseArchiveStorage replaces the local directory storage set up from config.
+func (s *AuditLogService) UseArchiveStorage(storage ArchiveStorage) {
+	s.archiveStorage = storage
+}
+
+// ArchiveExpired moves access logs older than the retention window into
+// gzipped NDJSON archives and deletes them, returning how many moved. Only
+// the oldest entries by ID go, so an entry that is still within the window
+// holds back any expired ones written after it.
+func (s *AuditLogService) ArchiveExpired(now time.Time) (int, error) {
+	if s.retention <= 0 {
+		return 0, nil
+	}
+	cutoff := now.Add(-s.retention)
+
+	total := 0
+	for {
+		archived, err := s.archiveBatch(cutoff)
+		total += archived
+		if err != nil || archived < auditArchiveBatchSize {
+			return total, err
+		}
+	}
+}
+
+func (s *AuditLogService) archiveBatch(cutoff time.Time) (int, error) {
+	var logs []db.AccessLogs
+	if err := s.db.Order("id ASC").Limit(auditArchiveBatchSize).Find(&logs).Error; err != nil {
+		return 0, err
+	}
+	expired := 0
+	for expired < len(logs) && logs[expired].Timestamp.Before(cutoff) {
+		expired++
+	}
+	if expired == 0 {
+		return 0, nil
+	}
+	logs = logs[:expired]
+	first, last := logs[0], logs[expired-1]
+
+	location, err := s.writeArchive(fmt.Sprintf("access_logs_%d-%d.ndjson.gz", first.ID, last.ID), logs)
+	if err != nil {
+		return 0, err
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		archive := &db.AuditArchive{
+			FirstLogID:    first.ID,
+			LastLogID:     last.ID,
+			FirstPrevHash: first.PrevHash,
+			LastHash:      last.Hash,
+			Count:         expired,
+			Location:      location,
+		}
+		archive.Signature = s.signArchive(archive)
+		if err := tx.Create(archive).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("id <= ?", last.ID).Delete(&db.AccessLogs{}).Error; err != nil {
+			return err
+		}
+		// The archive record now vouches for these entries.
+		return tx.Where("last_log_id <= ?", last.ID).Delete(&db.AuditCheckpoint{}).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	return expired, nil
+}
+
+// signArchive covers both ends of the archived range, so archives can be
+// checked to follow on from one another.
+func (s *AuditLogService) signArchive(archive *db.AuditArchive) string {
+	mac := hmac.New(sha256.New, s.signingKey)
+	fmt.Fprintf(mac, "%d:%s:%d:%s", archive.FirstLogID, archive.FirstPrevHash, archive.LastLogID, archive.LastHash)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *AuditLogService) writeArchive(name string, logs []db.AccessLogs) (string, error) {
+	writer, err := s.archiveStorage.Create(name)
+	if err != nil {
+		return "", err
+	}
+
+	gz := gzip.NewWriter(writer)
+	encoder := json.NewEncoder(gz)
+	for i := range logs {
+		if err := encoder.Encode(&logs[i]); err != nil {
+			writer.Abort()
+			return "", err
+		}
+	}
+	if err := gz.Close(); err != nil {
+		writer.Abort()
+		return "", err
+	}
+
+	return writer.Commit()
+}
+
+// StartRetention archives expired access logs every interval in the
+// background. It does nothing when retention or the interval is disabled.
+func (s *AuditLogService) StartRetention(interval time.Duration, onError func(error)) {
+	if s.retention <= 0 || interval <= 0 || s.retentionStop != nil {
+		return
+	}
+	s.retentionStop = make(chan struct{})
+	s.retentionDone = make(chan struct{})
+
+	go func() {
+		defer close(s.retentionDone)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.retentionStop:
+				return
+			case <-ticker.C:
+				if _, err := s.ArchiveExpired(time.Now()); err != nil && onError != nil {
+					onError(err)
+				}
+			}
+		}
+	}()
+}
+
+func (s *AuditLogService) StopRetention() {
+	if s.retentionStop == nil {
+		return
+	}
+	close(s.retentionStop)
+	<-s.retentionDone
+	s.retentionStop = nil
+}
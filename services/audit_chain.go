@@ -0,0 +1,274 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Brownei/api-generation-api/db"
+	"gorm.io/gorm"
+)
+
+const (
+	auditChainHeadID = 1
+	// Times a batch is re-chained after another writer moved the head.
+	auditChainMaxRetries = 10
+	auditVerifyPageSize  = 1000
+)
+
+var errAuditChainMoved = errors.New("audit chain head moved")
+
+// AuditChainReport is the outcome of walking the chain. BrokenAtID is the
+// first access log (or checkpoint, see Reason) that doesn't verify.
+type AuditChainReport struct {
+	Valid              bool   `json:"valid"`
+	LogsChecked        int    `json:"logs_checked"`
+	CheckpointsChecked int    `json:"checkpoints_checked"`
+	ArchivesChecked    int    `json:"archives_checked"`
+	BrokenAtID         uint   `json:"broken_at_id,omitempty"`
+	Reason             string `json:"reason,omitempty"`
+}
+
+// appendToChain links the batch to the current head and inserts it. The
+// head is only moved if no other writer moved it first.
+func (s *AuditLogService) appendToChain(batch []*db.AccessLogs) error {
+	for attempt := 0; ; attempt++ {
+		err := s.db.Transaction(func(tx *gorm.DB) error {
+			var head db.AuditChainHead
+			if err := tx.FirstOrCreate(&head, db.AuditChainHead{ID: auditChainHeadID}).Error; err != nil {
+				return err
+			}
+
+			prev := head.Hash
+			for _, log := range batch {
+				log.ID = 0 // set by a rolled back attempt
+				log.PrevHash = prev
+				log.Hash = hashAccessLog(prev, log)
+				prev = log.Hash
+			}
+			if err := tx.CreateInBatches(batch, s.batchSize).Error; err != nil {
+				return err
+			}
+
+			result := tx.Model(&db.AuditChainHead{}).
+				Where("id = ? AND hash = ?", auditChainHeadID, head.Hash).
+				Updates(map[string]interface{}{
+					"hash":        prev,
+					"last_log_id": batch[len(batch)-1].ID,
+					"updated_at":  time.Now(),
+				})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return errAuditChainMoved
+			}
+			return nil
+		})
+		if !errors.Is(err, errAuditChainMoved) || attempt == auditChainMaxRetries {
+			return err
+		}
+	}
+}
+
+// Checkpoint signs the current chain head. It does nothing when the head
+// hasn't moved since the last checkpoint.
+func (s *AuditLogService) Checkpoint() (*db.AuditCheckpoint, error) {
+	var head db.AuditChainHead
+	if err := s.db.Where("id = ?", auditChainHeadID).First(&head).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if head.LastLogID == 0 {
+		return nil, nil
+	}
+
+	var last db.AuditCheckpoint
+	err := s.db.Order("id DESC").First(&last).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	if err == nil && last.LastLogID == head.LastLogID {
+		return nil, nil
+	}
+
+	checkpoint := &db.AuditCheckpoint{
+		LastLogID: head.LastLogID,
+		Hash:      head.Hash,
+		Signature: s.signCheckpoint(head.LastLogID, head.Hash),
+	}
+	if err := s.db.Create(checkpoint).Error; err != nil {
+		return nil, err
+	}
+	return checkpoint, nil
+}
+
+// VerifyChain recomputes every hash in ID order and checks each checkpoint
+// signature against the log it points at. Logs written before chaining was
+// introduced have no hash and are skipped.
+func (s *AuditLogService) VerifyChain() (*AuditChainReport, error) {
+	report := &AuditChainReport{}
+
+	var checkpoints []db.AuditCheckpoint
+	if err := s.db.Order("last_log_id ASC").Find(&checkpoints).Error; err != nil {
+		return nil, err
+	}
+	byLogID := make(map[uint][]db.AuditCheckpoint, len(checkpoints))
+	for _, checkpoint := range checkpoints {
+		if !hmac.Equal([]byte(checkpoint.Signature), []byte(s.signCheckpoint(checkpoint.LastLogID, checkpoint.Hash))) {
+			return report.broken(checkpoint.LastLogID, fmt.Sprintf("checkpoint %d has an invalid signature", checkpoint.ID)), nil
+		}
+		byLogID[checkpoint.LastLogID] = append(byLogID[checkpoint.LastLogID], checkpoint)
+	}
+
+	started := false
+	prev := ""
+
+	// Entries moved out by retention are vouched for by their archive
+	// record. Each archive continues from the one before it, the first from
+	// the start of the chain, and what remains from the newest one.
+	var archives []db.AuditArchive
+	if err := s.db.Order("last_log_id ASC").Find(&archives).Error; err != nil {
+		return nil, err
+	}
+	for _, archive := range archives {
+		if !hmac.Equal([]byte(archive.Signature), []byte(s.signArchive(&archive))) {
+			return report.broken(archive.LastLogID, fmt.Sprintf("archive %d has an invalid signature", archive.ID)), nil
+		}
+		if archive.FirstPrevHash != prev {
+			return report.broken(archive.FirstLogID, fmt.Sprintf("archive %d does not follow the previous archive; archived entries were deleted", archive.ID)), nil
+		}
+		prev = archive.LastHash
+		report.ArchivesChecked++
+	}
+	started = prev != ""
+
+	var lastID uint
+	for {
+		var logs []db.AccessLogs
+		if err := s.db.Where("id > ?", lastID).Order("id ASC").Limit(auditVerifyPageSize).Find(&logs).Error; err != nil {
+			return nil, err
+		}
+
+		for i := range logs {
+			log := &logs[i]
+			lastID = log.ID
+
+			if log.Hash == "" {
+				if started {
+					return report.broken(log.ID, "entry has no hash"), nil
+				}
+				continue
+			}
+			if !started {
+				// Without an archive the first chained entry is the genesis
+				// entry, written when the head was still empty.
+				started = true
+				if log.PrevHash != "" {
+					return report.broken(log.ID, "entry does not start the chain; older entries were deleted"), nil
+				}
+			}
+
+			if log.PrevHash != prev {
+				return report.broken(log.ID, "entry does not link to the previous entry; one was deleted or reordered"), nil
+			}
+			if log.Hash != hashAccessLog(prev, log) {
+				return report.broken(log.ID, "entry contents do not match its hash"), nil
+			}
+			prev = log.Hash
+			report.LogsChecked++
+
+			for _, checkpoint := range byLogID[log.ID] {
+				if checkpoint.Hash != log.Hash {
+					return report.broken(log.ID, fmt.Sprintf("entry does not match checkpoint %d", checkpoint.ID)), nil
+				}
+				report.CheckpointsChecked++
+			}
+			delete(byLogID, log.ID)
+		}
+
+		if len(logs) < auditVerifyPageSize {
+			break
+		}
+	}
+
+	// A checkpoint whose log is gone means entries were deleted.
+	for _, checkpoint := range checkpoints {
+		if _, missing := byLogID[checkpoint.LastLogID]; missing {
+			return report.broken(checkpoint.LastLogID, fmt.Sprintf("entry signed by checkpoint %d is missing", checkpoint.ID)), nil
+		}
+	}
+
+	var head db.AuditChainHead
+	err := s.db.Where("id = ?", auditChainHeadID).First(&head).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	if err == nil && head.Hash != prev {
+		return report.broken(head.LastLogID, "newest entries were deleted"), nil
+	}
+
+	report.Valid = true
+	return report, nil
+}
+
+func (r *AuditChainReport) broken(id uint, reason string) *AuditChainReport {
+	r.Valid = false
+	r.BrokenAtID = id
+	r.Reason = reason
+	return r
+}
+
+// chainedAccessLog fixes the field order and encoding that go into a hash.
+// Fields added after chaining was introduced are omitted when empty so
+// older entries still hash the same.
+type chainedAccessLog struct {
+	PrevHash     string `json:"prev_hash"`
+	UserID       uint   `json:"user_id"`
+	APIKeyID     *uint  `json:"api_key_id"`
+	Method       string `json:"method"`
+	Path         string `json:"path"`
+	StatusCode   int    `json:"status_code"`
+	IPAddress    string `json:"ip_address"`
+	UserAgent    string `json:"user_agent"`
+	Duration     int64  `json:"duration"`
+	Timestamp    int64  `json:"timestamp"`
+	AuthMethod   string `json:"auth_method,omitempty"`
+	RequestID    string `json:"request_id,omitempty"`
+	Route        string `json:"route,omitempty"`
+	ResponseSize int64  `json:"response_size,omitempty"`
+}
+
+func hashAccessLog(prevHash string, log *db.AccessLogs) string {
+	payload, _ := json.Marshal(chainedAccessLog{
+		PrevHash:   prevHash,
+		UserID:     log.UserID,
+		APIKeyID:   log.APIKeyID,
+		Method:     log.Method,
+		Path:       log.Path,
+		StatusCode: log.StatusCode,
+		IPAddress:  log.IPAddress,
+		UserAgent:  log.UserAgent,
+		Duration:   log.Duration,
+		Timestamp:  log.Timestamp.UnixMicro(),
+
+		AuthMethod:   log.AuthMethod,
+		RequestID:    log.RequestID,
+		Route:        log.Route,
+		ResponseSize: log.ResponseSize,
+	})
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *AuditLogService) signCheckpoint(lastLogID uint, hash string) string {
+	mac := hmac.New(sha256.New, s.signingKey)
+	fmt.Fprintf(mac, "%d:%s", lastLogID, hash)
+	return hex.EncodeToString(mac.Sum(nil))
+}
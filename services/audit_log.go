@@ -1,49 +1,338 @@
 package services
 
 import (
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/Brownei/api-generation-api/config"
 	"github.com/Brownei/api-generation-api/db"
 	"gorm.io/gorm"
 )
 
+// How often a failed batch is retried, and the delay before the first retry.
+// The delay doubles on every attempt.
+const (
+	auditLogWriteAttempts = 3
+	auditLogRetryDelay    = 100 * time.Millisecond
+)
+
+// AuditLogService records access logs through a bounded queue drained by a
+// single writer that inserts in batches. When the queue is full entries are
+// dropped and counted rather than blocking the request.
 type AuditLogService struct {
-	db *gorm.DB
+	db            *gorm.DB
+	batchSize     int
+	flushInterval time.Duration
+
+	signingKey         []byte
+	checkpointInterval time.Duration
+	lastCheckpoint     time.Time
+
+	ipMode        string
+	userAgentMode string
+
+	retention      time.Duration
+	archiveStorage ArchiveStorage
+	retentionStop  chan struct{}
+	retentionDone  chan struct{}
+
+	mu     sync.RWMutex
+	closed bool
+	queue  chan *db.AccessLogs
+	done   chan struct{}
+
+	dropped atomic.Int64
+	failed  atomic.Int64
+}
+
+// AuditLogStats counts entries that never reached the database: Dropped
+// because the queue was full or closed, Failed because every insert attempt
+// errored.
+type AuditLogStats struct {
+	Dropped int64
+	Failed  int64
+	Queued  int
+}
+
+func NewAuditLogService(database *gorm.DB, cfg *config.AppConfig) *AuditLogService {
+	s := newAuditLogService(database, cfg)
+	go s.run()
+	return s
+}
+
+// NewAuditLogReader returns a service for querying and verifying the access
+// log that never writes to it: it has no writer, so nothing is flushed or
+// checkpointed on Close, and entries passed to LogRequest are dropped.
+func NewAuditLogReader(database *gorm.DB, cfg *config.AppConfig) *AuditLogService {
+	s := newAuditLogService(database, cfg)
+	s.closed = true
+	close(s.queue)
+	close(s.done)
+	return s
 }
 
-func NewAuditLogService(db *gorm.DB) *AuditLogService {
-	return &AuditLogService{db: db}
+func newAuditLogService(database *gorm.DB, cfg *config.AppConfig) *AuditLogService {
+	s := &AuditLogService{
+		db:            database,
+		batchSize:     max(cfg.AuditLogBatchSize, 1),
+		flushInterval: cfg.AuditLogFlushInterval,
+		queue:         make(chan *db.AccessLogs, max(cfg.AuditLogQueueSize, 1)),
+		done:          make(chan struct{}),
+
+		signingKey:         auditSigningKey(cfg),
+		checkpointInterval: cfg.AuditCheckpointInterval,
+		lastCheckpoint:     time.Now(),
+
+		ipMode:        cfg.AuditIPMode,
+		userAgentMode: cfg.AuditUserAgentMode,
+
+		retention:      cfg.AuditLogRetention,
+		archiveStorage: NewLocalArchiveStorage(cfg.AuditArchiveDir),
+	}
+	if s.flushInterval <= 0 {
+		s.flushInterval = time.Second
+	}
+	return s
 }
 
+// CreateLog writes a single entry synchronously, bypassing the queue.
 func (s *AuditLogService) CreateLog(log *db.AccessLogs) error {
-	return s.db.Create(log).Error
+	return s.appendToChain([]*db.AccessLogs{log})
 }
 
 type AuditLogEntry struct {
-	UserID     uint
-	Method     string
-	Path       string
-	StatusCode int
-	IPAddress  string
-	UserAgent  string
-	Duration   int64
+	UserID       uint
+	AuthMethod   string
+	APIKeyID     *uint
+	RequestID    string
+	Method       string
+	Path         string
+	Route        string
+	StatusCode   int
+	ResponseSize int64
+	IPAddress    string
+	UserAgent    string
+	Duration     int64
 }
 
+// LogRequest queues an entry without blocking. The IP address and user
+// agent are anonymized first according to the configured privacy modes.
 func (s *AuditLogService) LogRequest(entry AuditLogEntry) {
 	log := &db.AccessLogs{
-		UserID:     entry.UserID,
-		Method:     entry.Method,
-		Path:       entry.Path,
-		StatusCode: entry.StatusCode,
-		IPAddress:  entry.IPAddress,
-		UserAgent:  entry.UserAgent,
-		Duration:   entry.Duration,
-		Timestamp:  time.Now(),
-	}
-
-	go func() {
-		if err := s.CreateLog(log); err != nil {
-			_ = err
+		UserID:       entry.UserID,
+		AuthMethod:   entry.AuthMethod,
+		APIKeyID:     entry.APIKeyID,
+		RequestID:    entry.RequestID,
+		Method:       entry.Method,
+		Path:         entry.Path,
+		Route:        entry.Route,
+		StatusCode:   entry.StatusCode,
+		ResponseSize: entry.ResponseSize,
+		IPAddress:    s.anonymizeIP(entry.IPAddress),
+		UserAgent:    s.anonymizeUserAgent(entry.UserAgent),
+		Duration:     entry.Duration,
+		// Stored precision, so the hash still matches after a round trip.
+		Timestamp: time.Now().UTC().Truncate(time.Microsecond),
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		s.dropped.Add(1)
+		return
+	}
+
+	select {
+	case s.queue <- log:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+func (s *AuditLogService) Stats() AuditLogStats {
+	return AuditLogStats{
+		Dropped: s.dropped.Load(),
+		Failed:  s.failed.Load(),
+		Queued:  len(s.queue),
+	}
+}
+
+// Close stops accepting entries and returns once everything queued has been
+// written. It is safe to call more than once.
+func (s *AuditLogService) Close() {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.mu.Unlock()
+
+	<-s.done
+}
+
+func (s *AuditLogService) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*db.AccessLogs, 0, s.batchSize)
+	for {
+		select {
+		case log, ok := <-s.queue:
+			if !ok {
+				s.write(batch)
+				s.Checkpoint()
+				return
+			}
+			batch = append(batch, log)
+			if len(batch) >= s.batchSize {
+				s.write(batch)
+				batch = make([]*db.AccessLogs, 0, s.batchSize)
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				s.write(batch)
+				batch = make([]*db.AccessLogs, 0, s.batchSize)
+			}
+			if s.checkpointInterval > 0 && time.Since(s.lastCheckpoint) >= s.checkpointInterval {
+				if _, err := s.Checkpoint(); err == nil {
+					s.lastCheckpoint = time.Now()
+				}
+			}
+		}
+	}
+}
+
+func auditSigningKey(cfg *config.AppConfig) []byte {
+	for _, key := range []string{cfg.AuditSigningKey, cfg.EncryptionKey} {
+		if key != "" {
+			return []byte(key)
 		}
-	}()
+	}
+	return []byte(cfg.JWTSecret)
+}
+
+func (s *AuditLogService) write(batch []*db.AccessLogs) {
+	if len(batch) == 0 {
+		return
+	}
+
+	delay := auditLogRetryDelay
+	for attempt := 1; ; attempt++ {
+		err := s.appendToChain(batch)
+		if err == nil {
+			return
+		}
+		if attempt == auditLogWriteAttempts {
+			s.failed.Add(int64(len(batch)))
+			return
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+const (
+	DefaultAuditLogPageSize = 50
+	MaxAuditLogPageSize     = 200
+)
+
+// AuditLogQuery filters access logs. Zero values leave a filter off; a nil
+// UserID matches every user. Results are newest first and Cursor is the ID
+// of the last entry of the previous page.
+type AuditLogQuery struct {
+	UserID     *uint
+	APIKeyID   *uint
+	RequestID  string
+	Method     string
+	PathPrefix string
+	StatusMin  int
+	StatusMax  int
+	From       *time.Time
+	To         *time.Time
+	Cursor     uint
+	Limit      int
+}
+
+// QueryLogs returns a page of matching entries and the cursor of the next
+// page, which is zero on the last one.
+func (s *AuditLogService) QueryLogs(q AuditLogQuery) ([]db.AccessLogs, uint, error) {
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultAuditLogPageSize
+	}
+	if limit > MaxAuditLogPageSize {
+		limit = MaxAuditLogPageSize
+	}
+
+	query := s.filter(q)
+	if q.Cursor > 0 {
+		query = query.Where("id < ?", q.Cursor)
+	}
+
+	// Fetch one extra row to know whether another page follows.
+	var logs []db.AccessLogs
+	if err := query.Order("id DESC").Limit(limit + 1).Find(&logs).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var next uint
+	if len(logs) > limit {
+		logs = logs[:limit]
+		next = logs[limit-1].ID
+	}
+	return logs, next, nil
+}
+
+// StreamLogs calls fn for every entry matching q, oldest first, loading a
+// page at a time. Cursor and Limit are ignored.
+func (s *AuditLogService) StreamLogs(q AuditLogQuery, fn func(*db.AccessLogs) error) error {
+	var batch []db.AccessLogs
+	return s.filter(q).FindInBatches(&batch, auditVerifyPageSize, func(tx *gorm.DB, _ int) error {
+		for i := range batch {
+			if err := fn(&batch[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+}
+
+func (s *AuditLogService) filter(q AuditLogQuery) *gorm.DB {
+	query := s.db.Model(&db.AccessLogs{})
+	if q.UserID != nil {
+		query = query.Where("user_id = ?", *q.UserID)
+	}
+	if q.RequestID != "" {
+		query = query.Where("request_id = ?", q.RequestID)
+	}
+	if q.APIKeyID != nil {
+		query = query.Where("api_key_id = ?", *q.APIKeyID)
+	}
+	if q.Method != "" {
+		query = query.Where("method = ?", strings.ToUpper(q.Method))
+	}
+	if q.PathPrefix != "" {
+		query = query.Where(`path LIKE ? ESCAPE '\'`, escapeLike(q.PathPrefix)+"%")
+	}
+	if q.StatusMin > 0 {
+		query = query.Where("status_code >= ?", q.StatusMin)
+	}
+	if q.StatusMax > 0 {
+		query = query.Where("status_code <= ?", q.StatusMax)
+	}
+	if q.From != nil {
+		query = query.Where("timestamp >= ?", *q.From)
+	}
+	if q.To != nil {
+		query = query.Where("timestamp < ?", *q.To)
+	}
+	return query
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
 }
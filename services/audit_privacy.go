@@ -0,0 +1,71 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/netip"
+	"strings"
+)
+
+// How client IPs and user agents are stored in access logs. Unknown modes
+// are treated as AuditPrivacyHash so a typo never stores more than asked.
+const (
+	AuditPrivacyFull     = "full"
+	AuditPrivacyTruncate = "truncate"
+	AuditPrivacyHash     = "hash"
+)
+
+// Bits of the address kept by AuditPrivacyTruncate.
+const (
+	auditIPv4TruncateBits = 24
+	auditIPv6TruncateBits = 48
+)
+
+// anonymizeIP truncates an IP to its network or replaces it with a keyed
+// hash, which still lets entries from one client be correlated.
+func (s *AuditLogService) anonymizeIP(ip string) string {
+	switch s.ipMode {
+	case AuditPrivacyFull, "":
+		return ip
+	case AuditPrivacyTruncate:
+		addr, err := netip.ParseAddr(ip)
+		if err != nil {
+			return ""
+		}
+		bits := auditIPv6TruncateBits
+		if addr.Unmap().Is4() {
+			addr, bits = addr.Unmap(), auditIPv4TruncateBits
+		}
+		prefix, _ := addr.Prefix(bits)
+		return prefix.Addr().String()
+	default:
+		return s.privacyHash("ip", ip)
+	}
+}
+
+// anonymizeUserAgent keeps only the leading product token, e.g.
+// "Mozilla/5.0", or replaces the user agent with a keyed hash.
+func (s *AuditLogService) anonymizeUserAgent(userAgent string) string {
+	switch s.userAgentMode {
+	case AuditPrivacyFull, "":
+		return userAgent
+	case AuditPrivacyTruncate:
+		product, _, _ := strings.Cut(strings.TrimSpace(userAgent), " ")
+		return product
+	default:
+		return s.privacyHash("user-agent", userAgent)
+	}
+}
+
+// privacyHash is keyed so short values such as IPv4 addresses can't be
+// recovered by hashing every candidate.
+func (s *AuditLogService) privacyHash(kind, value string) string {
+	if value == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, s.signingKey)
+	mac.Write([]byte(kind + ":" + value))
+	// Half the digest fits the ip_address column and is plenty to correlate.
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
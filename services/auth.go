@@ -15,36 +15,82 @@ import (
 type AuthService struct {
 	db  *gorm.DB
 	cfg *config.AppConfig
+
+	keyRing       *KeyRing
+	tokenVersions *ttlCache[uint, int]
+	revokedTokens *ttlCache[string, bool]
 }
 
 func NewAuthService(db *gorm.DB, cfg *config.AppConfig) *AuthService {
-	return &AuthService{db: db, cfg: cfg}
+	return &AuthService{
+		db:            db,
+		cfg:           cfg,
+		keyRing:       NewKeyRing(db, cfg),
+		tokenVersions: newTTLCache[uint, int](cfg.TokenRevocationCacheTTL, 100000),
+		revokedTokens: newTTLCache[string, bool](cfg.TokenRevocationCacheTTL, 100000),
+	}
 }
 
+// Claims.ID carries the token's jti and TokenVersion the user's token version
+// at issue time.
 type Claims struct {
-	UserID uint   `json:"user_id"`
-	Email  string `json:"email"`
+	UserID       uint   `json:"user_id"`
+	Email        string `json:"email"`
+	TokenVersion int    `json:"ver"`
+
+	// Set on tokens issued to an API key through the client_credentials
+	// grant, which act as that key with at most Scope.
+	APIKeyID uint   `json:"api_key_id,omitempty"`
+	ClientID string `json:"client_id,omitempty"`
+	Scope    string `json:"scope,omitempty"`
 	jwt.RegisteredClaims
 }
 
 func (s *AuthService) GenerateToken(userID uint, email string) (string, error) {
+	version, err := s.currentTokenVersion(userID)
+	if err != nil && !errors.Is(err, types.ErrUserNotFound) {
+		return "", err
+	}
+
+	jti, err := generateRandomHex(16)
+	if err != nil {
+		return "", err
+	}
+
 	claims := Claims{
-		UserID: userID,
-		Email:  email,
+		UserID:       userID,
+		Email:        email,
+		TokenVersion: version,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
+			ID:        jti,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(s.cfg.AccessTokenTTL)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 	}
 
+	return s.sign(claims)
+}
+
+func (s *AuthService) sign(claims Claims) (string, error) {
+	if s.keyRing.Enabled() {
+		return s.keyRing.Sign(claims)
+	}
+
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return token.SignedString([]byte(s.cfg.JWTSecret))
 }
 
+func (s *AuthService) AccessTokenTTL() time.Duration {
+	return s.cfg.AccessTokenTTL
+}
+
+// KeyRing returns the asymmetric signing keys; it is inert under HS256.
+func (s *AuthService) KeyRing() *KeyRing {
+	return s.keyRing
+}
+
 func (s *AuthService) ValidateToken(tokenString string) (*Claims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(s.cfg.JWTSecret), nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, s.verificationKey)
 
 	if err != nil {
 		return nil, err
@@ -57,6 +103,18 @@ func (s *AuthService) ValidateToken(tokenString string) (*Claims, error) {
 	return nil, types.ErrTokenExpired
 }
 
+// verificationKey only accepts tokens signed the way this instance signs, so
+// switching to an asymmetric algorithm stops the shared secret from working.
+func (s *AuthService) verificationKey(token *jwt.Token) (interface{}, error) {
+	if s.keyRing.Enabled() {
+		return s.keyRing.VerificationKey(token)
+	}
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		return nil, ErrUnknownSigningKey
+	}
+	return []byte(s.cfg.JWTSecret), nil
+}
+
 func (s *AuthService) GetUserByID(userID uint) (*db.User, error) {
 	var user db.User
 	if err := s.db.Where("id = ?", userID).First(&user).Error; err != nil {
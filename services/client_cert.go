@@ -0,0 +1,182 @@
+package services
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/Brownei/api-generation-api/db"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrClientCertNotFound = errors.New("client certificate not found")
+	ErrClientCertExists   = errors.New("client certificate is already registered")
+	ErrClientCertRequired = errors.New("API key requires its registered client certificate")
+	// A certificate bound to a key that requires one is only a second
+	// factor, so it can't authenticate on its own.
+	ErrClientCertNeedsKey = errors.New("client certificate must be presented with its API key")
+	// Certificates are registered from a connection presenting them.
+	ErrClientCertNotPresented = errors.New("no verified client certificate presented on this connection")
+)
+
+type ClientCertService struct {
+	db      *gorm.DB
+	apiKeys *APIKeyService
+}
+
+func NewClientCertService(db *gorm.DB, apiKeys *APIKeyService) *ClientCertService {
+	return &ClientCertService{db: db, apiKeys: apiKeys}
+}
+
+// ClientCertOptions describes a certificate to register. Certificate must
+// be the one verified on the caller's own TLS connection: completing that
+// handshake proves they hold its private key, so nobody can claim a
+// certificate they merely have a copy of. Scopes only apply to
+// certificates not bound to an API key and default like a key's.
+type ClientCertOptions struct {
+	Name        string
+	Certificate *x509.Certificate
+	APIKeyID    *uint
+	Scopes      []string
+}
+
+// CertificateFingerprint is the hex SHA-256 of the certificate's DER form.
+func CertificateFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *ClientCertService) Register(userID uint, opts ClientCertOptions) (*db.ClientCertificate, error) {
+	if opts.Certificate == nil {
+		return nil, ErrClientCertNotPresented
+	}
+	notAfter := opts.Certificate.NotAfter
+	cert := &db.ClientCertificate{
+		UserID:      userID,
+		Name:        opts.Name,
+		APIKeyID:    opts.APIKeyID,
+		Fingerprint: CertificateFingerprint(opts.Certificate),
+		Subject:     opts.Certificate.Subject.String(),
+		NotAfter:    &notAfter,
+	}
+
+	if opts.APIKeyID != nil {
+		var count int64
+		if err := s.db.Model(&db.APIKey{}).Where("id = ? AND user_id = ?", *opts.APIKeyID, userID).Count(&count).Error; err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			return nil, ErrAPIKeyNotFound
+		}
+	} else {
+		scopes := opts.Scopes
+		if len(scopes) == 0 {
+			scopes = DefaultAPIKeyScopes
+		}
+		if err := ValidateScopes(scopes); err != nil {
+			return nil, err
+		}
+		cert.Scopes = JoinScopes(scopes)
+	}
+
+	var count int64
+	if err := s.db.Model(&db.ClientCertificate{}).Where("fingerprint = ?", cert.Fingerprint).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, ErrClientCertExists
+	}
+
+	if err := s.db.Create(cert).Error; err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
+func (s *ClientCertService) List(userID uint) ([]db.ClientCertificate, error) {
+	var certs []db.ClientCertificate
+	if err := s.db.Where("user_id = ?", userID).Order("created_at DESC").Find(&certs).Error; err != nil {
+		return nil, err
+	}
+	return certs, nil
+}
+
+func (s *ClientCertService) Delete(userID, certID uint) error {
+	result := s.db.Where("id = ? AND user_id = ?", certID, userID).Delete(&db.ClientCertificate{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrClientCertNotFound
+	}
+	return nil
+}
+
+// Authenticate resolves a verified client certificate presented on its
+// own. A certificate bound to an API key authenticates as that key, which
+// must be usable from clientIP like any other use of it.
+func (s *ClientCertService) Authenticate(fingerprint, clientIP string) (*db.ClientCertificate, *db.APIKey, error) {
+	var cert db.ClientCertificate
+	if err := s.db.Where("fingerprint = ?", fingerprint).First(&cert).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrClientCertNotFound
+		}
+		return nil, nil, err
+	}
+
+	var apiKey *db.APIKey
+	if cert.APIKeyID != nil {
+		apiKey = &db.APIKey{}
+		if err := s.db.First(apiKey, *cert.APIKeyID).Error; err != nil {
+			return nil, nil, err
+		}
+		if apiKey.RequireClientCert {
+			return nil, nil, ErrClientCertNeedsKey
+		}
+		if apiKey.RequiresSignature() {
+			return nil, nil, ErrSignatureRequired
+		}
+		if err := s.apiKeys.checkUsable(apiKey, clientIP); err != nil {
+			return nil, nil, err
+		}
+		s.apiKeys.recordUse(apiKey.ID, time.Now())
+	}
+
+	s.db.Model(&db.ClientCertificate{}).Where("id = ?", cert.ID).Update("last_used_at", time.Now())
+
+	return &cert, apiKey, nil
+}
+
+// CheckClientCert enforces RequireClientCert on a key authenticated some
+// other way. fingerprint is that of the request's verified client
+// certificate, empty when there is none.
+func (s *APIKeyService) CheckClientCert(apiKey *db.APIKey, fingerprint string) error {
+	if !apiKey.RequireClientCert {
+		return nil
+	}
+	if fingerprint == "" {
+		return ErrClientCertRequired
+	}
+
+	// Rotation moves certificates to the successor, which a key in its
+	// grace period still accepts.
+	keyIDs := []uint{apiKey.ID}
+	if apiKey.SuccessorID != nil {
+		keyIDs = append(keyIDs, *apiKey.SuccessorID)
+	}
+
+	var count int64
+	err := s.db.Model(&db.ClientCertificate{}).
+		Where("fingerprint = ? AND api_key_id IN ?", fingerprint, keyIDs).
+		Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrClientCertRequired
+	}
+	return nil
+}
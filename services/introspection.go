@@ -0,0 +1,185 @@
+package services
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Brownei/api-generation-api/db"
+	"github.com/Brownei/api-generation-api/types"
+)
+
+// Token type hints of RFC 7662; "api_key" is this service's own.
+const (
+	TokenTypeHintAccessToken = "access_token"
+	TokenTypeHintAPIKey      = "api_key"
+)
+
+// Introspection describes a presented access token or API key. Only
+// Active and, for a key that exists but can't be used, KeyStatus are set
+// for credentials that don't authenticate.
+type Introspection struct {
+	Active    bool
+	TokenType string
+	Subject   string
+	Scope     string
+	ClientID  string
+	TokenID   string
+	ExpiresAt *time.Time
+	IssuedAt  *time.Time
+	APIKeyID  uint
+	KeyStatus string
+}
+
+// IntrospectionService tells other services whether a credential is
+// currently good, applying the same checks as authenticating with it.
+type IntrospectionService struct {
+	authService   *AuthService
+	apiKeyService *APIKeyService
+}
+
+func NewIntrospectionService(authService *AuthService, apiKeyService *APIKeyService) *IntrospectionService {
+	return &IntrospectionService{authService: authService, apiKeyService: apiKeyService}
+}
+
+// Introspect tries the token as the kind hint names first and then as the
+// other. clientIP is the address the credential is being used from, checked
+// against the key's IP allowlist; without it a key with an allowlist is
+// inactive. Errors are only returned when the check itself fails.
+func (s *IntrospectionService) Introspect(token, hint, clientIP string) (*Introspection, error) {
+	if token == "" {
+		return &Introspection{}, nil
+	}
+
+	lookups := []func(string, string) (*Introspection, error){s.introspectAccessToken, s.introspectAPIKey}
+	if hint == TokenTypeHintAPIKey {
+		lookups[0], lookups[1] = lookups[1], lookups[0]
+	}
+
+	inactive := &Introspection{}
+	for _, lookup := range lookups {
+		result, err := lookup(token, clientIP)
+		if err != nil {
+			return nil, err
+		}
+		if result.Active {
+			return result, nil
+		}
+		if result.KeyStatus != "" {
+			inactive = result
+		}
+	}
+	return inactive, nil
+}
+
+func (s *IntrospectionService) introspectAccessToken(token, clientIP string) (*Introspection, error) {
+	claims, err := s.authService.ValidateToken(token)
+	if err != nil {
+		return &Introspection{}, nil
+	}
+	if err := s.authService.CheckTokenRevocation(claims); err != nil {
+		if errors.Is(err, types.ErrTokenRevoked) {
+			return &Introspection{}, nil
+		}
+		return nil, err
+	}
+
+	result := &Introspection{
+		Active:    true,
+		TokenType: "Bearer",
+		Subject:   strconv.FormatUint(uint64(claims.UserID), 10),
+		TokenID:   claims.ID,
+		// User sessions aren't restricted by scope.
+		Scope: JoinScopes(AllScopes()),
+	}
+	if claims.ExpiresAt != nil {
+		result.ExpiresAt = &claims.ExpiresAt.Time
+	}
+	if claims.IssuedAt != nil {
+		result.IssuedAt = &claims.IssuedAt.Time
+	}
+
+	if claims.APIKeyID != 0 {
+		apiKey, err := s.apiKeyService.CheckTokenKey(claims.APIKeyID, clientIP)
+		if err != nil {
+			return inactiveKey(err)
+		}
+		result.Scope = JoinScopes(GrantedScopes(apiKey, claims.Scope))
+		result.ClientID = claims.ClientID
+		result.APIKeyID = apiKey.ID
+		result.KeyStatus = apiKey.Status
+	}
+	return result, nil
+}
+
+func (s *IntrospectionService) introspectAPIKey(token, clientIP string) (*Introspection, error) {
+	apiKey, err := s.apiKeyService.ValidateAPIKey(token, clientIP)
+	if err != nil {
+		return inactiveKey(err)
+	}
+
+	return &Introspection{
+		Active:    true,
+		TokenType: "ApiKey",
+		Subject:   strconv.FormatUint(uint64(apiKey.UserID), 10),
+		Scope:     apiKey.Scopes,
+		ClientID:  apiKey.Prefix,
+		ExpiresAt: apiKey.ExpiresAt,
+		IssuedAt:  apiKey.CreatedAt,
+		APIKeyID:  apiKey.ID,
+		KeyStatus: apiKey.Status,
+	}, nil
+}
+
+// inactiveKey turns a failed key check into an inactive result, naming the
+// key's status when that is why it failed.
+func inactiveKey(err error) (*Introspection, error) {
+	if !IsCredentialError(err) {
+		return nil, err
+	}
+
+	result := &Introspection{}
+	switch {
+	case errors.Is(err, ErrAPIKeyRevoked):
+		result.KeyStatus = db.APIKeyStatusRevoked
+	case errors.Is(err, ErrAPIKeyExpired):
+		result.KeyStatus = db.APIKeyStatusExpired
+	case errors.Is(err, ErrAPIKeySuspended):
+		result.KeyStatus = db.APIKeyStatusSuspended
+	}
+	return result, nil
+}
+
+// GrantedScopes is what a client_credentials token may still do: the scopes
+// it was issued with that its key still holds, since the key may have lost
+// some since.
+func GrantedScopes(apiKey *db.APIKey, scope string) []string {
+	granted := []string{}
+	for _, s := range strings.Fields(scope) {
+		if apiKey.HasScope(s) {
+			granted = append(granted, s)
+		}
+	}
+	return granted
+}
+
+// IsCredentialError reports whether err means a presented credential can't
+// be accepted, as opposed to the check itself failing.
+func IsCredentialError(err error) bool {
+	for _, target := range []error{
+		ErrInvalidClient,
+		ErrInvalidAPIKey,
+		ErrAPIKeyRevoked,
+		ErrAPIKeyExpired,
+		ErrAPIKeySuspended,
+		ErrAPIKeyIPNotAllowed,
+		ErrSignatureRequired,
+		ErrClientCertRequired,
+	} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
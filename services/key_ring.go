@@ -0,0 +1,431 @@
+package services
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Brownei/api-generation-api/config"
+	"github.com/Brownei/api-generation-api/db"
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+const (
+	SigningAlgorithmHS256 = "HS256"
+	SigningAlgorithmRS256 = "RS256"
+	SigningAlgorithmES256 = "ES256"
+	SigningAlgorithmEdDSA = "EdDSA"
+)
+
+var (
+	ErrUnsupportedSigningAlgorithm = errors.New("unsupported JWT signing algorithm")
+	ErrNoSigningKey                = errors.New("no active signing key")
+	ErrUnknownSigningKey           = errors.New("unknown signing key")
+
+	errSigningKeyAlreadyRotated = errors.New("signing key already rotated")
+)
+
+const (
+	// How often a running ring re-reads keys and checks whether rotation is due.
+	keyRingCheckInterval = time.Minute
+	// Least time between reloads triggered by tokens with an unknown kid.
+	keyRingMinReloadInterval = 10 * time.Second
+)
+
+type signingKey struct {
+	kid       string
+	method    jwt.SigningMethod
+	private   crypto.Signer
+	createdAt time.Time
+	retiredAt *time.Time
+}
+
+// KeyRing holds the asymmetric keys used to sign and verify access tokens.
+// Keys live in the signing_keys table so every instance signs with the same
+// current key and can verify tokens signed by the others.
+type KeyRing struct {
+	db  *gorm.DB
+	cfg *config.AppConfig
+	box *secretBox
+
+	mu         sync.RWMutex
+	current    *signingKey
+	keys       map[string]*signingKey
+	lastReload time.Time
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+func NewKeyRing(db *gorm.DB, cfg *config.AppConfig) *KeyRing {
+	return &KeyRing{
+		db:   db,
+		cfg:  cfg,
+		box:  newSecretBox(cfg),
+		keys: make(map[string]*signingKey),
+	}
+}
+
+// Enabled reports whether tokens are signed with the ring rather than the
+// shared HS256 secret.
+func (k *KeyRing) Enabled() bool {
+	return k.cfg.JWTSigningAlgorithm != SigningAlgorithmHS256
+}
+
+// Load reads the stored keys and creates a first key when there is no
+// active one for the configured algorithm.
+func (k *KeyRing) Load() error {
+	if !k.Enabled() {
+		return nil
+	}
+	if _, err := signingMethodFor(k.cfg.JWTSigningAlgorithm); err != nil {
+		return err
+	}
+
+	if err := k.reload(); err != nil {
+		return err
+	}
+	if k.currentKey() == nil {
+		return k.Rotate()
+	}
+	return nil
+}
+
+// Rotate makes a freshly generated key the signing key. The previous key is
+// retired but keeps verifying for as long as a token it signed can live.
+func (k *KeyRing) Rotate() error {
+	record, err := k.generate()
+	if err != nil {
+		return err
+	}
+
+	previous := k.currentKey()
+	now := time.Now()
+	err = k.db.Transaction(func(tx *gorm.DB) error {
+		if previous != nil {
+			// Another instance may have rotated the key in the meantime.
+			result := tx.Model(&db.SigningKey{}).
+				Where("kid = ? AND retired_at IS NULL", previous.kid).
+				Update("retired_at", now)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return errSigningKeyAlreadyRotated
+			}
+		}
+
+		// Keys of a previously configured algorithm are retired as well.
+		if err := tx.Model(&db.SigningKey{}).Where("retired_at IS NULL").Update("retired_at", now).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("retired_at < ?", now.Add(-k.retention())).Delete(&db.SigningKey{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(record).Error
+	})
+	if err != nil && !errors.Is(err, errSigningKeyAlreadyRotated) {
+		return err
+	}
+
+	return k.reload()
+}
+
+// Start rotates the signing key every interval in the background and picks
+// up keys rotated by other instances. It does nothing for HS256 or a
+// non-positive interval.
+func (k *KeyRing) Start(interval time.Duration, onError func(error)) {
+	if !k.Enabled() || interval <= 0 || k.stop != nil {
+		return
+	}
+	k.stop = make(chan struct{})
+	k.done = make(chan struct{})
+
+	go func() {
+		defer close(k.done)
+		ticker := time.NewTicker(min(interval, keyRingCheckInterval))
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-k.stop:
+				return
+			case <-ticker.C:
+				if err := k.rotateIfDue(interval); err != nil && onError != nil {
+					onError(err)
+				}
+			}
+		}
+	}()
+}
+
+func (k *KeyRing) Stop() {
+	if k.stop == nil {
+		return
+	}
+	close(k.stop)
+	<-k.done
+	k.stop = nil
+}
+
+func (k *KeyRing) rotateIfDue(interval time.Duration) error {
+	if err := k.reload(); err != nil {
+		return err
+	}
+	current := k.currentKey()
+	if current == nil || time.Since(current.createdAt) >= interval {
+		return k.Rotate()
+	}
+	return nil
+}
+
+// Sign signs the claims with the current key and names it in the kid header.
+func (k *KeyRing) Sign(claims jwt.Claims) (string, error) {
+	current := k.currentKey()
+	if current == nil {
+		return "", ErrNoSigningKey
+	}
+
+	token := jwt.NewWithClaims(current.method, claims)
+	token.Header["kid"] = current.kid
+	return token.SignedString(current.private)
+}
+
+// VerificationKey is a jwt.Keyfunc returning the public key named by the
+// token's kid.
+func (k *KeyRing) VerificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return nil, ErrUnknownSigningKey
+	}
+
+	key := k.lookup(kid)
+	if key == nil && k.reloadAllowed() {
+		// The key may have been created by another instance.
+		if err := k.reload(); err != nil {
+			return nil, err
+		}
+		key = k.lookup(kid)
+	}
+	if key == nil || key.method.Alg() != token.Method.Alg() {
+		return nil, ErrUnknownSigningKey
+	}
+
+	return key.private.Public(), nil
+}
+
+// JWKS returns the public half of every key that can still verify tokens,
+// newest first.
+func (k *KeyRing) JWKS() []JWK {
+	k.mu.RLock()
+	keys := make([]*signingKey, 0, len(k.keys))
+	for _, key := range k.keys {
+		keys = append(keys, key)
+	}
+	k.mu.RUnlock()
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].createdAt.After(keys[j].createdAt)
+	})
+
+	jwks := make([]JWK, 0, len(keys))
+	for _, key := range keys {
+		if jwk, ok := toJWK(key); ok {
+			jwks = append(jwks, jwk)
+		}
+	}
+	return jwks
+}
+
+func (k *KeyRing) currentKey() *signingKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.current
+}
+
+func (k *KeyRing) lookup(kid string) *signingKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.keys[kid]
+}
+
+// retention is how long a retired key is kept: the longest lifetime of
+// the tokens it may have signed, session or client_credentials.
+func (k *KeyRing) retention() time.Duration {
+	return max(k.cfg.AccessTokenTTL, k.cfg.OAuthTokenTTL)
+}
+
+func (k *KeyRing) reloadAllowed() bool {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return time.Since(k.lastReload) >= keyRingMinReloadInterval
+}
+
+func (k *KeyRing) reload() error {
+	var records []db.SigningKey
+	cutoff := time.Now().Add(-k.retention())
+	if err := k.db.Where("retired_at IS NULL OR retired_at > ?", cutoff).
+		Order("created_at DESC, id DESC").
+		Find(&records).Error; err != nil {
+		return err
+	}
+
+	keys := make(map[string]*signingKey, len(records))
+	var current *signingKey
+	for i := range records {
+		key, err := k.decode(&records[i])
+		if err != nil {
+			return fmt.Errorf("signing key %s: %w", records[i].KID, err)
+		}
+		keys[key.kid] = key
+		if current == nil && key.retiredAt == nil && records[i].Algorithm == k.cfg.JWTSigningAlgorithm {
+			current = key
+		}
+	}
+
+	k.mu.Lock()
+	k.keys = keys
+	k.current = current
+	k.lastReload = time.Now()
+	k.mu.Unlock()
+	return nil
+}
+
+func (k *KeyRing) generate() (*db.SigningKey, error) {
+	var private crypto.Signer
+	var err error
+	switch k.cfg.JWTSigningAlgorithm {
+	case SigningAlgorithmRS256:
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	case SigningAlgorithmES256:
+		private, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case SigningAlgorithmEdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedSigningAlgorithm, k.cfg.JWTSigningAlgorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := k.box.Seal(privateDER)
+	if err != nil {
+		return nil, err
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(private.Public())
+	if err != nil {
+		return nil, err
+	}
+	kid, err := generateRandomHex(8)
+	if err != nil {
+		return nil, err
+	}
+
+	return &db.SigningKey{
+		KID:        kid,
+		Algorithm:  k.cfg.JWTSigningAlgorithm,
+		PrivateKey: sealed,
+		PublicKey:  string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})),
+	}, nil
+}
+
+func (k *KeyRing) decode(record *db.SigningKey) (*signingKey, error) {
+	method, err := signingMethodFor(record.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	der, err := k.box.Open(record.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	private, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, ErrUnsupportedSigningAlgorithm
+	}
+
+	return &signingKey{
+		kid:       record.KID,
+		method:    method,
+		private:   private,
+		createdAt: record.CreatedAt,
+		retiredAt: record.RetiredAt,
+	}, nil
+}
+
+func signingMethodFor(algorithm string) (jwt.SigningMethod, error) {
+	switch algorithm {
+	case SigningAlgorithmRS256:
+		return jwt.SigningMethodRS256, nil
+	case SigningAlgorithmES256:
+		return jwt.SigningMethodES256, nil
+	case SigningAlgorithmEdDSA:
+		return jwt.SigningMethodEdDSA, nil
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnsupportedSigningAlgorithm, algorithm)
+}
+
+func toJWK(key *signingKey) (JWK, bool) {
+	jwk := JWK{Kid: key.kid, Use: "sig", Alg: key.method.Alg()}
+
+	switch public := key.private.Public().(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64URL(public.N.Bytes())
+		jwk.E = base64URL(big.NewInt(int64(public.E)).Bytes())
+	case *ecdsa.PublicKey:
+		point, err := public.Bytes()
+		if err != nil {
+			return JWK{}, false
+		}
+		// Uncompressed point: 0x04 || X || Y.
+		size := (len(point) - 1) / 2
+		jwk.Kty = "EC"
+		jwk.Crv = public.Curve.Params().Name
+		jwk.X = base64URL(point[1 : 1+size])
+		jwk.Y = base64URL(point[1+size:])
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = base64URL(public)
+	default:
+		return JWK{}, false
+	}
+	return jwk, true
+}
+
+func base64URL(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,165 @@
+package services
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/Brownei/api-generation-api/config"
+	"github.com/Brownei/api-generation-api/types"
+)
+
+// RateLimitPolicy allows Limit requests per Window, refilled continuously
+// as a token bucket.
+type RateLimitPolicy struct {
+	Limit  int
+	Window time.Duration
+}
+
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	ResetAt    time.Time
+	RetryAfter time.Duration
+}
+
+// RateLimitStore keeps bucket state. The in-memory store is per instance;
+// a shared store (e.g. Redis) can implement this to limit across instances.
+type RateLimitStore interface {
+	Take(key string, policy RateLimitPolicy, now time.Time) (RateLimitResult, error)
+	// Peek reports whether Take would be allowed without taking anything.
+	Peek(key string, policy RateLimitPolicy, now time.Time) (RateLimitResult, error)
+}
+
+type RateLimiter struct {
+	store         RateLimitStore
+	defaultPolicy RateLimitPolicy
+}
+
+func NewRateLimiter(store RateLimitStore, cfg *config.AppConfig) *RateLimiter {
+	return &RateLimiter{
+		store: store,
+		defaultPolicy: RateLimitPolicy{
+			Limit:  cfg.RateLimitRequests,
+			Window: cfg.RateLimitWindow,
+		},
+	}
+}
+
+// PolicyFor returns the principal's own limit when it has one, otherwise
+// the configured default. A nil principal gets the default.
+func (l *RateLimiter) PolicyFor(principal *types.Principal) RateLimitPolicy {
+	policy := l.defaultPolicy
+	if principal == nil {
+		return policy
+	}
+	if principal.RateLimit > 0 {
+		policy.Limit = principal.RateLimit
+	}
+	if principal.RateLimitWindow > 0 {
+		policy.Window = principal.RateLimitWindow
+	}
+	return policy
+}
+
+func (l *RateLimiter) Allow(key string, policy RateLimitPolicy) (RateLimitResult, error) {
+	return l.store.Take(key, policy, time.Now())
+}
+
+// Check reports on key's bucket without taking from it.
+func (l *RateLimiter) Check(key string, policy RateLimitPolicy) (RateLimitResult, error) {
+	return l.store.Peek(key, policy, time.Now())
+}
+
+type tokenBucket struct {
+	tokens   float64
+	updated  time.Time
+	policy   RateLimitPolicy
+	fullTime time.Time
+}
+
+type InMemoryRateLimitStore struct {
+	mu          sync.Mutex
+	buckets     map[string]*tokenBucket
+	lastCleanup time.Time
+}
+
+func NewInMemoryRateLimitStore() *InMemoryRateLimitStore {
+	return &InMemoryRateLimitStore{buckets: make(map[string]*tokenBucket)}
+}
+
+func (s *InMemoryRateLimitStore) Take(key string, policy RateLimitPolicy, now time.Time) (RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bucket := s.refill(key, policy, now)
+	allowed := bucket.tokens >= 1
+	if allowed {
+		bucket.tokens--
+	}
+	return bucket.result(allowed, now), nil
+}
+
+func (s *InMemoryRateLimitStore) Peek(key string, policy RateLimitPolicy, now time.Time) (RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bucket := s.refill(key, policy, now)
+	return bucket.result(bucket.tokens >= 1, now), nil
+}
+
+// refill returns the key's bucket topped up for the time since it was last
+// touched.
+func (s *InMemoryRateLimitStore) refill(key string, policy RateLimitPolicy, now time.Time) *tokenBucket {
+	s.cleanup(now)
+
+	capacity := float64(policy.Limit)
+	rate := capacity / policy.Window.Seconds()
+
+	bucket, ok := s.buckets[key]
+	if !ok || bucket.policy != policy {
+		bucket = &tokenBucket{tokens: capacity, updated: now, policy: policy}
+		s.buckets[key] = bucket
+	}
+
+	elapsed := now.Sub(bucket.updated).Seconds()
+	bucket.tokens = math.Min(capacity, bucket.tokens+elapsed*rate)
+	bucket.updated = now
+	return bucket
+}
+
+func (b *tokenBucket) result(allowed bool, now time.Time) RateLimitResult {
+	capacity := float64(b.policy.Limit)
+	rate := capacity / b.policy.Window.Seconds()
+
+	result := RateLimitResult{Limit: b.policy.Limit, Allowed: allowed}
+	if !allowed {
+		result.RetryAfter = secondsToDuration((1 - b.tokens) / rate)
+	}
+
+	result.Remaining = int(math.Floor(b.tokens))
+	b.fullTime = now.Add(secondsToDuration((capacity - b.tokens) / rate))
+	result.ResetAt = b.fullTime
+
+	return result
+}
+
+// cleanup drops buckets that have refilled completely, at most once a minute,
+// so idle clients don't accumulate.
+func (s *InMemoryRateLimitStore) cleanup(now time.Time) {
+	if now.Sub(s.lastCleanup) < time.Minute {
+		return
+	}
+	s.lastCleanup = now
+
+	for key, bucket := range s.buckets {
+		if now.After(bucket.fullTime) {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(math.Ceil(seconds * float64(time.Second)))
+}
@@ -0,0 +1,68 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	ScopeKeysRead  = "keys:read"
+	ScopeKeysWrite = "keys:write"
+	ScopeUsersRead = "users:read"
+	ScopeAuditRead = "audit:read"
+
+	ScopeTokensIntrospect = "tokens:introspect"
+)
+
+var ErrInvalidScope = errors.New("invalid scope")
+
+// Scopes is the registry of scopes an API key may carry, with a short
+// description of what each one grants.
+var Scopes = map[string]string{
+	ScopeKeysRead:  "List API keys",
+	ScopeKeysWrite: "Create, rotate and revoke API keys",
+	ScopeUsersRead: "Read user profiles",
+	ScopeAuditRead: "Read audit logs",
+
+	ScopeTokensIntrospect: "Introspect access tokens and API keys (admins)",
+}
+
+// DefaultAPIKeyScopes are granted when a key is created without any scopes.
+var DefaultAPIKeyScopes = []string{ScopeKeysRead, ScopeUsersRead}
+
+// AllScopes returns every registered scope in a stable order.
+func AllScopes() []string {
+	scopes := make([]string, 0, len(Scopes))
+	for scope := range Scopes {
+		scopes = append(scopes, scope)
+	}
+	sort.Strings(scopes)
+	return scopes
+}
+
+// ValidateScopes checks every scope against the registry.
+func ValidateScopes(scopes []string) error {
+	for _, scope := range scopes {
+		if _, ok := Scopes[scope]; !ok {
+			return fmt.Errorf("%w: %q", ErrInvalidScope, scope)
+		}
+	}
+	return nil
+}
+
+// JoinScopes normalises scopes into the space separated form stored on a key.
+func JoinScopes(scopes []string) string {
+	seen := make(map[string]bool, len(scopes))
+	unique := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		if scope == "" || seen[scope] {
+			continue
+		}
+		seen[scope] = true
+		unique = append(unique, scope)
+	}
+	sort.Strings(unique)
+	return strings.Join(unique, " ")
+}
@@ -0,0 +1,56 @@
+package services
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+
+	"github.com/Brownei/api-generation-api/config"
+)
+
+var ErrSecretDecryption = errors.New("failed to decrypt stored secret")
+
+// secretBox encrypts secrets at rest with AES-256-GCM. The key is derived
+// from EncryptionKey, or JWTSecret when that isn't set.
+type secretBox struct {
+	aead cipher.AEAD
+}
+
+func newSecretBox(cfg *config.AppConfig) *secretBox {
+	passphrase := cfg.EncryptionKey
+	if passphrase == "" {
+		passphrase = cfg.JWTSecret
+	}
+	key := sha256.Sum256([]byte(passphrase))
+
+	// Neither call can fail with a 32 byte key.
+	block, _ := aes.NewCipher(key[:])
+	aead, _ := cipher.NewGCM(block)
+	return &secretBox{aead: aead}
+}
+
+// Seal returns base64(nonce || ciphertext).
+func (b *secretBox) Seal(plaintext []byte) (string, error) {
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := b.aead.Seal(nonce, nonce, plaintext, nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (b *secretBox) Open(sealed string) ([]byte, error) {
+	raw, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(raw) < b.aead.NonceSize() {
+		return nil, ErrSecretDecryption
+	}
+	nonce, ciphertext := raw[:b.aead.NonceSize()], raw[b.aead.NonceSize():]
+	plaintext, err := b.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, ErrSecretDecryption
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,159 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/Brownei/api-generation-api/db"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrSessionNotFound     = errors.New("session not found")
+	ErrSessionRevoked      = errors.New("session has been revoked")
+	ErrSessionExpired      = errors.New("session has expired")
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected, session revoked")
+)
+
+// Refresh tokens look like "<session public id>.<secret>".
+const refreshTokenSeparator = "."
+
+// CreateSession starts a session for the user and returns its first refresh
+// token. The token is only ever returned here and from RefreshSession.
+func (s *AuthService) CreateSession(userID uint, userAgent, ipAddress string) (*db.Session, string, error) {
+	publicID, err := generateRandomHex(16)
+	if err != nil {
+		return nil, "", err
+	}
+	secret, err := generateRandomKey()
+	if err != nil {
+		return nil, "", err
+	}
+
+	session := &db.Session{
+		PublicID:  publicID,
+		UserID:    userID,
+		TokenHash: hashRefreshSecret(secret),
+		UserAgent: userAgent,
+		IPAddress: ipAddress,
+		ExpiresAt: time.Now().Add(s.cfg.RefreshTokenTTL),
+	}
+
+	if err := s.db.Create(session).Error; err != nil {
+		return nil, "", err
+	}
+
+	return session, publicID + refreshTokenSeparator + secret, nil
+}
+
+// RefreshSession swaps a refresh token for a new one. A token that belongs
+// to the session but is not its current one has already been used, so the
+// whole session is revoked.
+func (s *AuthService) RefreshSession(refreshToken string) (*db.Session, string, error) {
+	session, secret, err := s.findSessionByToken(refreshToken)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if !hmac.Equal([]byte(session.TokenHash), []byte(hashRefreshSecret(secret))) {
+		if session.RevokedAt == nil {
+			now := time.Now()
+			s.db.Model(session).Update("revoked_at", now)
+		}
+		return nil, "", ErrRefreshTokenReused
+	}
+
+	if session.RevokedAt != nil {
+		return nil, "", ErrSessionRevoked
+	}
+	if time.Now().After(session.ExpiresAt) {
+		return nil, "", ErrSessionExpired
+	}
+
+	newSecret, err := generateRandomKey()
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Only swap the hash if nobody else rotated it first.
+	now := time.Now()
+	result := s.db.Model(&db.Session{}).
+		Where("id = ? AND token_hash = ?", session.ID, session.TokenHash).
+		Updates(map[string]interface{}{
+			"token_hash":   hashRefreshSecret(newSecret),
+			"last_used_at": now,
+		})
+	if result.Error != nil {
+		return nil, "", result.Error
+	}
+	if result.RowsAffected == 0 {
+		s.db.Model(session).Update("revoked_at", now)
+		return nil, "", ErrRefreshTokenReused
+	}
+
+	session.LastUsedAt = &now
+	return session, session.PublicID + refreshTokenSeparator + newSecret, nil
+}
+
+// RevokeSessionByToken ends the session a refresh token belongs to.
+func (s *AuthService) RevokeSessionByToken(refreshToken string) error {
+	session, secret, err := s.findSessionByToken(refreshToken)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal([]byte(session.TokenHash), []byte(hashRefreshSecret(secret))) {
+		return ErrInvalidRefreshToken
+	}
+
+	return s.db.Model(session).Where("revoked_at IS NULL").Update("revoked_at", time.Now()).Error
+}
+
+func (s *AuthService) ListSessions(userID uint) ([]db.Session, error) {
+	var sessions []db.Session
+	if err := s.db.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("created_at DESC").
+		Find(&sessions).Error; err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func (s *AuthService) RevokeSession(userID, sessionID uint) error {
+	result := s.db.Model(&db.Session{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", sessionID, userID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+func (s *AuthService) findSessionByToken(refreshToken string) (*db.Session, string, error) {
+	publicID, secret, found := strings.Cut(refreshToken, refreshTokenSeparator)
+	if !found || publicID == "" || secret == "" {
+		return nil, "", ErrInvalidRefreshToken
+	}
+
+	var session db.Session
+	if err := s.db.Where("public_id = ?", publicID).First(&session).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, "", ErrInvalidRefreshToken
+		}
+		return nil, "", err
+	}
+
+	return &session, secret, nil
+}
+
+func hashRefreshSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,119 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"github.com/Brownei/api-generation-api/db"
+	"github.com/Brownei/api-generation-api/types"
+	"gorm.io/gorm"
+)
+
+// CheckTokenRevocation rejects tokens issued before the user's token version
+// was bumped, tokens of deleted users and tokens whose jti was revoked.
+// Lookups are cached for TokenRevocationCacheTTL, so a revocation issued on
+// another instance takes at most that long to apply here.
+func (s *AuthService) CheckTokenRevocation(claims *Claims) error {
+	version, err := s.currentTokenVersion(claims.UserID)
+	if err != nil {
+		if errors.Is(err, types.ErrUserNotFound) {
+			return types.ErrTokenRevoked
+		}
+		return err
+	}
+	if claims.TokenVersion != version {
+		return types.ErrTokenRevoked
+	}
+
+	if claims.ID == "" {
+		return nil
+	}
+
+	revoked, ok := s.revokedTokens.Get(claims.ID)
+	if !ok {
+		var count int64
+		if err := s.db.Model(&db.RevokedToken{}).Where("jti = ?", claims.ID).Count(&count).Error; err != nil {
+			return err
+		}
+		revoked = count > 0
+		s.revokedTokens.Set(claims.ID, revoked)
+	}
+	if revoked {
+		return types.ErrTokenRevoked
+	}
+
+	return nil
+}
+
+// RevokeToken blocks a single access token until it expires.
+func (s *AuthService) RevokeToken(claims *Claims) error {
+	if claims.ID == "" {
+		return nil
+	}
+
+	expiresAt := time.Now().Add(s.cfg.AccessTokenTTL)
+	if claims.ExpiresAt != nil {
+		expiresAt = claims.ExpiresAt.Time
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		// Entries are useless once the token would have expired anyway.
+		if err := tx.Where("expires_at < ?", time.Now()).Delete(&db.RevokedToken{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&db.RevokedToken{
+			JTI:       claims.ID,
+			UserID:    claims.UserID,
+			ExpiresAt: expiresAt,
+		}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	s.revokedTokens.Set(claims.ID, true)
+	return nil
+}
+
+// RevokeAllTokens logs the user out everywhere: every access token issued so
+// far stops validating and every session is ended. Call it on password
+// changes and account deletion.
+func (s *AuthService) RevokeAllTokens(userID uint) error {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&db.User{}).Where("id = ?", userID).
+			Update("token_version", gorm.Expr("token_version + 1"))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return types.ErrUserNotFound
+		}
+
+		return tx.Model(&db.Session{}).
+			Where("user_id = ? AND revoked_at IS NULL", userID).
+			Update("revoked_at", time.Now()).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	s.tokenVersions.Delete(userID)
+	return nil
+}
+
+func (s *AuthService) currentTokenVersion(userID uint) (int, error) {
+	if version, ok := s.tokenVersions.Get(userID); ok {
+		return version, nil
+	}
+
+	var user db.User
+	if err := s.db.Select("id", "token_version").Where("id = ?", userID).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, types.ErrUserNotFound
+		}
+		return 0, err
+	}
+
+	s.tokenVersions.Set(userID, user.TokenVersion)
+	return user.TokenVersion, nil
+}
@@ -3,6 +3,7 @@ package store
 import (
 	"github.com/Brownei/api-generation-api/config"
 	"github.com/Brownei/api-generation-api/controllers"
+	"github.com/Brownei/api-generation-api/middleware"
 	"github.com/Brownei/api-generation-api/services"
 	"go.uber.org/zap"
 
@@ -10,22 +11,51 @@ import (
 )
 
 type Store struct {
-	APIKeyController *controllers.APIKeyController
-	UserController   *controllers.UserController
-	AuthController   *controllers.AuthController
-	AuditLogService  *services.AuditLogService
+	APIKeyController     *controllers.APIKeyController
+	UserController       *controllers.UserController
+	AuthController       *controllers.AuthController
+	AuditLogController   *controllers.AuditLogController
+	ClientCertController *controllers.ClientCertController
+	OAuthController      *controllers.OAuthController
+	AuditLogService      *services.AuditLogService
+	APIKeyService        *services.APIKeyService
+	RateLimiter          *services.RateLimiter
+	KeyRing              *services.KeyRing
+	UsageService         *services.UsageService
+	ClientCertService    *services.ClientCertService
+
+	JWTAuthenticator        *middleware.JWTAuthenticator
+	APIKeyAuthenticator     *middleware.APIKeyAuthenticator
+	SignatureAuthenticator  *middleware.SignatureAuthenticator
+	ClientCertAuthenticator *middleware.ClientCertAuthenticator
 }
 
 func NewStore(db *gorm.DB, cfg *config.AppConfig, logger *zap.SugaredLogger) *Store {
-	apiKeyService := services.NewAPIKeyService(db)
+	apiKeyService := services.NewAPIKeyService(db, cfg)
 	authService := services.NewAuthService(db, cfg)
 	userService := services.NewUserService(db, cfg)
-	auditLogService := services.NewAuditLogService(db)
+	auditLogService := services.NewAuditLogService(db, cfg)
+	usageService := services.NewUsageService(db)
+	clientCertService := services.NewClientCertService(db, apiKeyService)
+	introspectionService := services.NewIntrospectionService(authService, apiKeyService)
 
 	return &Store{
-		APIKeyController: controllers.NewAPIKeyController(apiKeyService, logger),
-		UserController:   controllers.NewUserController(userService, authService, logger),
-		AuthController:   controllers.NewAuthController(userService, authService, logger),
-		AuditLogService:  auditLogService,
+		APIKeyController:     controllers.NewAPIKeyController(apiKeyService, usageService, authService, logger),
+		UserController:       controllers.NewUserController(userService, authService, logger),
+		AuthController:       controllers.NewAuthController(userService, authService, logger),
+		AuditLogController:   controllers.NewAuditLogController(auditLogService, authService, logger),
+		ClientCertController: controllers.NewClientCertController(clientCertService, apiKeyService, logger),
+		OAuthController:      controllers.NewOAuthController(authService, apiKeyService, introspectionService, logger),
+		AuditLogService:      auditLogService,
+		APIKeyService:        apiKeyService,
+		RateLimiter:          services.NewRateLimiter(services.NewInMemoryRateLimitStore(), cfg),
+		KeyRing:              authService.KeyRing(),
+		UsageService:         usageService,
+		ClientCertService:    clientCertService,
+
+		JWTAuthenticator:        middleware.NewJWTAuthenticator(authService, apiKeyService, auditLogService),
+		APIKeyAuthenticator:     middleware.NewAPIKeyAuthenticator(apiKeyService, auditLogService),
+		SignatureAuthenticator:  middleware.NewSignatureAuthenticator(apiKeyService, auditLogService),
+		ClientCertAuthenticator: middleware.NewClientCertAuthenticator(clientCertService, auditLogService),
 	}
 }
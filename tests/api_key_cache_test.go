@@ -0,0 +1,134 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Brownei/api-generation-api/config"
+	"github.com/Brownei/api-generation-api/db"
+	"github.com/Brownei/api-generation-api/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func cachingAPIKeyService(database *gorm.DB) *services.APIKeyService {
+	cfg := config.LoadAppConfig()
+	cfg.APIKeyCacheTTL = time.Minute
+	cfg.APIKeyNegativeCacheTTL = time.Minute
+	return services.NewAPIKeyService(database, cfg)
+}
+
+func TestValidateAPIKey_ServesRepeatLookupsFromCache(t *testing.T) {
+	database := setupTestDB(t)
+	user := createTestUser(t, database)
+	service := cachingAPIKeyService(database)
+
+	apiKey, err := service.GenerateAPIKey(user.ID, "Test Key", nil)
+	require.NoError(t, err)
+	_, err = service.ValidateAPIKey(apiKey.Key, "")
+	require.NoError(t, err)
+
+	// A change made behind the service's back isn't seen until the entry expires.
+	database.Model(&db.APIKey{}).Where("id = ?", apiKey.ID).Update("scopes", services.ScopeAuditRead)
+	validated, err := service.ValidateAPIKey(apiKey.Key, "")
+	require.NoError(t, err)
+	assert.NotEqual(t, []string{services.ScopeAuditRead}, validated.ScopeList())
+}
+
+func TestValidateAPIKey_CachesUnknownKeys(t *testing.T) {
+	database := setupTestDB(t)
+	user := createTestUser(t, database)
+	service := cachingAPIKeyService(database)
+
+	apiKey, err := service.GenerateAPIKey(user.ID, "Test Key", nil)
+	require.NoError(t, err)
+	guess := apiKey.Key + "0"
+
+	_, err = service.ValidateAPIKey(guess, "")
+	assert.ErrorIs(t, err, services.ErrInvalidAPIKey)
+
+	// Even with the database gone the guess is still rejected.
+	sqlDB, err := database.DB()
+	require.NoError(t, err)
+	require.NoError(t, sqlDB.Close())
+	_, err = service.ValidateAPIKey(guess, "")
+	assert.ErrorIs(t, err, services.ErrInvalidAPIKey)
+}
+
+func TestAPIKeyService_RevokeAndRotateEvictCache(t *testing.T) {
+	database := setupTestDB(t)
+	user := createTestUser(t, database)
+	service := cachingAPIKeyService(database)
+
+	revoked, err := service.GenerateAPIKey(user.ID, "Revoked", nil)
+	require.NoError(t, err)
+	expired, err := service.GenerateAPIKey(user.ID, "Expired", nil)
+	require.NoError(t, err)
+	rotated, err := service.GenerateAPIKey(user.ID, "Rotated", nil)
+	require.NoError(t, err)
+	for _, key := range []*db.APIKey{revoked, expired, rotated} {
+		_, err := service.ValidateAPIKey(key.Key, "")
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, service.RevokeAPIKey(user.ID, revoked.ID, ""))
+	_, err = service.RotateAPIKey(user.ID, rotated.ID, 0)
+	require.NoError(t, err)
+	database.Model(&db.APIKey{}).Where("id = ?", expired.ID).Update("expires_at", time.Now().Add(-time.Minute))
+	_, err = service.SweepExpiredKeys(time.Now())
+	require.NoError(t, err)
+
+	for _, key := range []*db.APIKey{revoked, rotated} {
+		_, err := service.ValidateAPIKey(key.Key, "")
+		assert.ErrorIs(t, err, services.ErrAPIKeyRevoked, key.Name)
+	}
+	_, err = service.ValidateAPIKey(expired.Key, "")
+	assert.ErrorIs(t, err, services.ErrAPIKeyExpired)
+}
+
+func TestAPIKeyService_InvalidationBusReachesOtherInstances(t *testing.T) {
+	database := setupTestDB(t)
+	user := createTestUser(t, database)
+	bus := services.NewInMemoryInvalidationBus()
+	first := cachingAPIKeyService(database)
+	first.UseInvalidationBus(bus)
+	second := cachingAPIKeyService(database)
+	second.UseInvalidationBus(bus)
+
+	apiKey, err := first.GenerateAPIKey(user.ID, "Test Key", nil)
+	require.NoError(t, err)
+	_, err = second.ValidateAPIKey(apiKey.Key, "")
+	require.NoError(t, err)
+
+	require.NoError(t, first.RevokeAPIKey(user.ID, apiKey.ID, ""))
+
+	_, err = second.ValidateAPIKey(apiKey.Key, "")
+	assert.ErrorIs(t, err, services.ErrAPIKeyRevoked)
+}
+
+func TestValidateAPIKey_RevocationDuringLookupIsNotCached(t *testing.T) {
+	database := setupTestDB(t)
+	user := createTestUser(t, database)
+	service := cachingAPIKeyService(database)
+
+	apiKey, err := service.GenerateAPIKey(user.ID, "Test Key", nil)
+	require.NoError(t, err)
+
+	// Revoke the key right after the lookup has read it, before it is cached.
+	revoking := false
+	require.NoError(t, database.Callback().Query().After("gorm:query").Register("test:revoke", func(tx *gorm.DB) {
+		if revoking || tx.Statement.Table != "api_keys" {
+			return
+		}
+		revoking = true
+		require.NoError(t, service.RevokeAPIKey(user.ID, apiKey.ID, ""))
+	}))
+
+	_, err = service.ValidateAPIKey(apiKey.Key, "")
+	require.NoError(t, err)
+	require.True(t, revoking)
+
+	_, err = service.ValidateAPIKey(apiKey.Key, "")
+	assert.ErrorIs(t, err, services.ErrAPIKeyRevoked)
+}
@@ -0,0 +1,126 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Brownei/api-generation-api/config"
+	"github.com/Brownei/api-generation-api/db"
+	"github.com/Brownei/api-generation-api/middleware"
+	"github.com/Brownei/api-generation-api/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateAPIKey_EnforcesAllowedIPs(t *testing.T) {
+	database := setupTestDB(t)
+	user := createTestUser(t, database)
+	service := services.NewAPIKeyService(database, config.LoadAppConfig())
+
+	apiKey, err := service.CreateAPIKey(user.ID, services.APIKeyOptions{
+		Name:       "Office",
+		AllowedIPs: []string{"203.0.113.0/24", "2001:db8::1", "198.51.100.7"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"203.0.113.0/24", "2001:db8::1/128", "198.51.100.7/32"}, apiKey.AllowedIPList())
+
+	for _, ip := range []string{"203.0.113.42", "2001:db8::1", "::ffff:198.51.100.7"} {
+		_, err := service.ValidateAPIKey(apiKey.Key, ip)
+		assert.NoError(t, err, ip)
+	}
+
+	for _, ip := range []string{"203.0.114.1", "2001:db8::2", "", "not-an-ip"} {
+		_, err := service.ValidateAPIKey(apiKey.Key, ip)
+		assert.ErrorIs(t, err, services.ErrAPIKeyIPNotAllowed, ip)
+	}
+}
+
+func TestCreateAPIKey_InvalidAllowedIP(t *testing.T) {
+	database := setupTestDB(t)
+	user := createTestUser(t, database)
+	service := services.NewAPIKeyService(database, config.LoadAppConfig())
+
+	_, err := service.CreateAPIKey(user.ID, services.APIKeyOptions{
+		Name:       "Bad",
+		AllowedIPs: []string{"10.0.0.0/33"},
+	})
+
+	assert.ErrorIs(t, err, services.ErrInvalidAllowedIP)
+}
+
+func TestSetAllowedIPs_UpdatesWithoutRotating(t *testing.T) {
+	database := setupTestDB(t)
+	user := createTestUser(t, database)
+	service := cachingAPIKeyService(database)
+
+	apiKey, err := service.GenerateAPIKey(user.ID, "Test Key", nil)
+	require.NoError(t, err)
+	_, err = service.ValidateAPIKey(apiKey.Key, "192.0.2.10")
+	require.NoError(t, err)
+
+	_, err = service.SetAllowedIPs(user.ID, apiKey.ID, []string{"10.0.0.0/8"})
+	require.NoError(t, err)
+
+	// The cached key is evicted, so the new list applies at once.
+	_, err = service.ValidateAPIKey(apiKey.Key, "192.0.2.10")
+	assert.ErrorIs(t, err, services.ErrAPIKeyIPNotAllowed)
+	_, err = service.ValidateAPIKey(apiKey.Key, "10.1.2.3")
+	assert.NoError(t, err)
+
+	_, err = service.SetAllowedIPs(user.ID, apiKey.ID, nil)
+	require.NoError(t, err)
+	_, err = service.ValidateAPIKey(apiKey.Key, "192.0.2.10")
+	assert.NoError(t, err)
+
+	_, err = service.SetAllowedIPs(user.ID+1, apiKey.ID, nil)
+	assert.ErrorIs(t, err, services.ErrAPIKeyNotFound)
+}
+
+func TestRotateAPIKey_KeepsAllowedIPs(t *testing.T) {
+	database := setupTestDB(t)
+	user := createTestUser(t, database)
+	service := services.NewAPIKeyService(database, config.LoadAppConfig())
+
+	apiKey, err := service.CreateAPIKey(user.ID, services.APIKeyOptions{Name: "Office", AllowedIPs: []string{"10.0.0.0/8"}})
+	require.NoError(t, err)
+
+	successor, err := service.RotateAPIKey(user.ID, apiKey.ID, 0)
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.0/8"}, successor.AllowedIPList())
+}
+
+func TestAPIKeyMiddleware_AuditsDisallowedIP(t *testing.T) {
+	database := setupTestDB(t)
+	require.NoError(t, database.AutoMigrate(&db.AccessLogs{}, &db.AuditChainHead{}))
+	user := createTestUser(t, database)
+	service := services.NewAPIKeyService(database, config.LoadAppConfig())
+	auditService := services.NewAuditLogService(database, config.LoadAppConfig())
+	mw := middleware.Authenticate(middleware.NewAPIKeyAuthenticator(service, auditService))
+
+	apiKey, err := service.CreateAPIKey(user.ID, services.APIKeyOptions{Name: "Office", AllowedIPs: []string{"10.0.0.0/8"}})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.RemoteAddr = "192.0.2.10:4321"
+	req.Header.Set("X-API-Key", apiKey.Key)
+	w := httptest.NewRecorder()
+
+	nextCalled := false
+	mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nextCalled = true
+	})).ServeHTTP(w, req)
+	auditService.Close()
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.False(t, nextCalled)
+
+	var log db.AccessLogs
+	require.NoError(t, database.First(&log).Error)
+	assert.Equal(t, user.ID, log.UserID)
+	require.NotNil(t, log.APIKeyID)
+	assert.Equal(t, apiKey.ID, *log.APIKeyID)
+	assert.Equal(t, http.StatusForbidden, log.StatusCode)
+	assert.Equal(t, "192.0.2.10", log.IPAddress)
+}
@@ -0,0 +1,187 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Brownei/api-generation-api/config"
+	"github.com/Brownei/api-generation-api/middleware"
+	"github.com/Brownei/api-generation-api/services"
+	"github.com/Brownei/api-generation-api/types"
+	"github.com/Brownei/api-generation-api/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIKeyMiddleware_ValidHeader(t *testing.T) {
+	database := setupTestDB(t)
+	user := createTestUser(t, database)
+	service := services.NewAPIKeyService(database, config.LoadAppConfig())
+	mw := middleware.Authenticate(middleware.NewAPIKeyAuthenticator(service, nil))
+
+	apiKey, err := service.GenerateAPIKey(user.ID, "Test Key", nil)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.Header.Set("X-API-Key", apiKey.Key)
+	w := httptest.NewRecorder()
+
+	var ctxUserID uint
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctxUserID = r.Context().Value(utils.UserIDKey).(uint)
+	})
+
+	mw(next).ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, user.ID, ctxUserID)
+}
+
+func TestAPIKeyMiddleware_AuthorizationScheme(t *testing.T) {
+	database := setupTestDB(t)
+	user := createTestUser(t, database)
+	service := services.NewAPIKeyService(database, config.LoadAppConfig())
+	mw := middleware.Authenticate(middleware.NewAPIKeyAuthenticator(service, nil))
+
+	apiKey, err := service.GenerateAPIKey(user.ID, "Test Key", nil)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.Header.Set("Authorization", "ApiKey "+apiKey.Key)
+	w := httptest.NewRecorder()
+
+	nextCalled := false
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nextCalled = true
+	})
+
+	mw(next).ServeHTTP(w, req)
+
+	assert.True(t, nextCalled)
+}
+
+func TestAPIKeyMiddleware_MissingKey(t *testing.T) {
+	database := setupTestDB(t)
+	mw := middleware.Authenticate(middleware.NewAPIKeyAuthenticator(services.NewAPIKeyService(database, config.LoadAppConfig()), nil))
+
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	w := httptest.NewRecorder()
+
+	nextCalled := false
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nextCalled = true
+	})
+
+	mw(next).ServeHTTP(w, req)
+
+	assert.False(t, nextCalled)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestAPIKeyMiddleware_RevokedKey(t *testing.T) {
+	database := setupTestDB(t)
+	user := createTestUser(t, database)
+	service := services.NewAPIKeyService(database, config.LoadAppConfig())
+	mw := middleware.Authenticate(middleware.NewAPIKeyAuthenticator(service, nil))
+
+	apiKey, err := service.GenerateAPIKey(user.ID, "Test Key", nil)
+	require.NoError(t, err)
+	require.NoError(t, service.RevokeAPIKey(user.ID, apiKey.ID, ""))
+
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.Header.Set("X-API-Key", apiKey.Key)
+	w := httptest.NewRecorder()
+
+	nextCalled := false
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nextCalled = true
+	})
+
+	mw(next).ServeHTTP(w, req)
+
+	assert.False(t, nextCalled)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+type stubAuthenticator struct {
+	principal *types.Principal
+	err       error
+	calls     int
+}
+
+func (s *stubAuthenticator) Authenticate(r *http.Request) (*types.Principal, error) {
+	s.calls++
+	return s.principal, s.err
+}
+
+func TestAuthenticate_Chain(t *testing.T) {
+	skipped := &stubAuthenticator{err: middleware.ErrNoCredentials}
+	matched := &stubAuthenticator{principal: &types.Principal{UserID: 42, Method: types.AuthMethodAPIKey, APIKeyID: 7}}
+	never := &stubAuthenticator{err: types.ErrInvalidToken}
+
+	var got *types.Principal
+	handler := middleware.Authenticate(skipped, matched, never)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = utils.PrincipalFromContext(r.Context())
+		assert.Equal(t, uint(42), r.Context().Value(utils.UserIDKey))
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/test", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	require.NotNil(t, got)
+	assert.Equal(t, uint(7), got.APIKeyID)
+	assert.Equal(t, 0, never.calls)
+}
+
+func TestAuthenticate_ErrorResponses(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	cases := []struct {
+		err    error
+		status int
+		body   string
+	}{
+		{middleware.ErrNoCredentials, http.StatusUnauthorized, "authentication required"},
+		{types.ErrTokenExpired, http.StatusUnauthorized, "token expired"},
+		{services.ErrAPIKeyRevoked, http.StatusUnauthorized, services.ErrAPIKeyRevoked.Error()},
+		{errors.New("database is down"), http.StatusInternalServerError, "failed to authenticate request"},
+	}
+
+	for _, tc := range cases {
+		w := httptest.NewRecorder()
+		middleware.Authenticate(&stubAuthenticator{err: tc.err})(next).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/test", nil))
+
+		assert.Equal(t, tc.status, w.Code)
+		var body map[string]string
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		assert.Equal(t, tc.body, body["error"])
+	}
+}
+
+func TestAPIKeyAuthenticator_Principal(t *testing.T) {
+	database := setupTestDB(t)
+	user := createTestUser(t, database)
+	service := services.NewAPIKeyService(database, config.LoadAppConfig())
+	apiKey, err := service.CreateAPIKey(user.ID, services.APIKeyOptions{
+		Name:            "Scoped Key",
+		Scopes:          []string{services.ScopeKeysRead},
+		RateLimit:       5,
+		RateLimitWindow: 60,
+	})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.Header.Set("X-API-Key", apiKey.Key)
+	principal, err := middleware.NewAPIKeyAuthenticator(service, nil).Authenticate(req)
+
+	require.NoError(t, err)
+	assert.Equal(t, types.AuthMethodAPIKey, principal.Method)
+	assert.Equal(t, apiKey.ID, principal.APIKeyID)
+	assert.Equal(t, []string{services.ScopeKeysRead}, principal.Scopes)
+	assert.False(t, principal.HasScope(services.ScopeKeysWrite))
+	assert.Equal(t, time.Minute, principal.RateLimitWindow)
+}
@@ -1,9 +1,12 @@
 package tests
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/Brownei/api-generation-api/config"
 	"github.com/Brownei/api-generation-api/db"
 	"github.com/Brownei/api-generation-api/services"
 	"github.com/stretchr/testify/assert"
@@ -15,7 +18,7 @@ import (
 func setupTestDB(t *testing.T) *gorm.DB {
 	database, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
 	require.NoError(t, err)
-	err = database.AutoMigrate(&db.User{}, &db.APIKey{})
+	err = database.AutoMigrate(&db.User{}, &db.APIKey{}, &db.ClientCertificate{})
 	require.NoError(t, err)
 	return database
 }
@@ -34,7 +37,7 @@ func createTestUser(t *testing.T, database *gorm.DB) *db.User {
 func TestGenerateAPIKey_Success(t *testing.T) {
 	database := setupTestDB(t)
 	user := createTestUser(t, database)
-	service := services.NewAPIKeyService(database)
+	service := services.NewAPIKeyService(database, config.LoadAppConfig())
 
 	apiKey, err := service.GenerateAPIKey(user.ID, "Test Key", nil)
 
@@ -42,13 +45,13 @@ func TestGenerateAPIKey_Success(t *testing.T) {
 	assert.NotEmpty(t, apiKey.Key)
 	assert.Equal(t, "Test Key", apiKey.Name)
 	assert.Equal(t, user.ID, apiKey.UserID)
-	assert.False(t, apiKey.IsRevoked)
+	assert.Equal(t, db.APIKeyStatusActive, apiKey.Status)
 }
 
 func TestGenerateAPIKey_WithExpiration(t *testing.T) {
 	database := setupTestDB(t)
 	user := createTestUser(t, database)
-	service := services.NewAPIKeyService(database)
+	service := services.NewAPIKeyService(database, config.LoadAppConfig())
 
 	expiresIn := 30
 	apiKey, err := service.GenerateAPIKey(user.ID, "Test Key", &expiresIn)
@@ -61,7 +64,7 @@ func TestGenerateAPIKey_WithExpiration(t *testing.T) {
 func TestGenerateAPIKey_MaxKeysReached(t *testing.T) {
 	database := setupTestDB(t)
 	user := createTestUser(t, database)
-	service := services.NewAPIKeyService(database)
+	service := services.NewAPIKeyService(database, config.LoadAppConfig())
 
 	for i := 0; i < 3; i++ {
 		_, err := service.GenerateAPIKey(user.ID, "Test Key", nil)
@@ -75,7 +78,7 @@ func TestGenerateAPIKey_MaxKeysReached(t *testing.T) {
 func TestListAPIKeys(t *testing.T) {
 	database := setupTestDB(t)
 	user := createTestUser(t, database)
-	service := services.NewAPIKeyService(database)
+	service := services.NewAPIKeyService(database, config.LoadAppConfig())
 
 	_, err := service.GenerateAPIKey(user.ID, "Key 1", nil)
 	require.NoError(t, err)
@@ -91,7 +94,7 @@ func TestListAPIKeys(t *testing.T) {
 func TestListAPIKeys_Empty(t *testing.T) {
 	database := setupTestDB(t)
 	user := createTestUser(t, database)
-	service := services.NewAPIKeyService(database)
+	service := services.NewAPIKeyService(database, config.LoadAppConfig())
 
 	keys, err := service.ListAPIKeys(user.ID)
 
@@ -108,7 +111,7 @@ func TestListAPIKeys_OtherUserKeys(t *testing.T) {
 		Password: "password123",
 	}
 	database.Create(user2)
-	service := services.NewAPIKeyService(database)
+	service := services.NewAPIKeyService(database, config.LoadAppConfig())
 
 	service.GenerateAPIKey(user1.ID, "Key 1", nil)
 	service.GenerateAPIKey(user2.ID, "Key 2", nil)
@@ -122,26 +125,26 @@ func TestListAPIKeys_OtherUserKeys(t *testing.T) {
 func TestRevokeAPIKey(t *testing.T) {
 	database := setupTestDB(t)
 	user := createTestUser(t, database)
-	service := services.NewAPIKeyService(database)
+	service := services.NewAPIKeyService(database, config.LoadAppConfig())
 
 	apiKey, err := service.GenerateAPIKey(user.ID, "Test Key", nil)
 	require.NoError(t, err)
 
-	err = service.RevokeAPIKey(user.ID, apiKey.ID)
+	err = service.RevokeAPIKey(user.ID, apiKey.ID, "")
 
 	require.NoError(t, err)
 	var revokedKey db.APIKey
 	err = database.First(&revokedKey, apiKey.ID).Error
 	require.NoError(t, err)
-	assert.True(t, revokedKey.IsRevoked)
+	assert.Equal(t, db.APIKeyStatusRevoked, revokedKey.Status)
 }
 
 func TestRevokeAPIKey_NotFound(t *testing.T) {
 	database := setupTestDB(t)
 	user := createTestUser(t, database)
-	service := services.NewAPIKeyService(database)
+	service := services.NewAPIKeyService(database, config.LoadAppConfig())
 
-	err := service.RevokeAPIKey(user.ID, 9999)
+	err := service.RevokeAPIKey(user.ID, 9999, "")
 
 	assert.ErrorIs(t, err, services.ErrAPIKeyNotFound)
 }
@@ -155,12 +158,12 @@ func TestRevokeAPIKey_OtherUserKey(t *testing.T) {
 		Password: "password123",
 	}
 	database.Create(user2)
-	service := services.NewAPIKeyService(database)
+	service := services.NewAPIKeyService(database, config.LoadAppConfig())
 
 	apiKey, err := service.GenerateAPIKey(user2.ID, "Key", nil)
 	require.NoError(t, err)
 
-	err = service.RevokeAPIKey(user1.ID, apiKey.ID)
+	err = service.RevokeAPIKey(user1.ID, apiKey.ID, "")
 
 	assert.ErrorIs(t, err, services.ErrAPIKeyNotFound)
 }
@@ -168,13 +171,13 @@ func TestRevokeAPIKey_OtherUserKey(t *testing.T) {
 func TestRotateAPIKey(t *testing.T) {
 	database := setupTestDB(t)
 	user := createTestUser(t, database)
-	service := services.NewAPIKeyService(database)
+	service := services.NewAPIKeyService(database, config.LoadAppConfig())
 
 	apiKey, err := service.GenerateAPIKey(user.ID, "Test Key", nil)
 	require.NoError(t, err)
 	oldKey := apiKey.Key
 
-	newApiKey, err := service.RotateAPIKey(user.ID, apiKey.ID)
+	newApiKey, err := service.RotateAPIKey(user.ID, apiKey.ID, 0)
 
 	require.NoError(t, err)
 	assert.NotEqual(t, oldKey, newApiKey.Key)
@@ -183,15 +186,15 @@ func TestRotateAPIKey(t *testing.T) {
 	var oldKeyResult db.APIKey
 	err = database.First(&oldKeyResult, apiKey.ID).Error
 	require.NoError(t, err)
-	assert.True(t, oldKeyResult.IsRevoked)
+	assert.Equal(t, db.APIKeyStatusRotated, oldKeyResult.Status)
 }
 
 func TestRotateAPIKey_NotFound(t *testing.T) {
 	database := setupTestDB(t)
 	user := createTestUser(t, database)
-	service := services.NewAPIKeyService(database)
+	service := services.NewAPIKeyService(database, config.LoadAppConfig())
 
-	_, err := service.RotateAPIKey(user.ID, 9999)
+	_, err := service.RotateAPIKey(user.ID, 9999, 0)
 
 	assert.ErrorIs(t, err, services.ErrAPIKeyNotFound)
 }
@@ -199,12 +202,12 @@ func TestRotateAPIKey_NotFound(t *testing.T) {
 func TestValidateAPIKey_Valid(t *testing.T) {
 	database := setupTestDB(t)
 	user := createTestUser(t, database)
-	service := services.NewAPIKeyService(database)
+	service := services.NewAPIKeyService(database, config.LoadAppConfig())
 
 	apiKey, err := service.GenerateAPIKey(user.ID, "Test Key", nil)
 	require.NoError(t, err)
 
-	validatedKey, err := service.ValidateAPIKey(apiKey.Key)
+	validatedKey, err := service.ValidateAPIKey(apiKey.Key, "")
 
 	require.NoError(t, err)
 	assert.Equal(t, apiKey.ID, validatedKey.ID)
@@ -212,9 +215,9 @@ func TestValidateAPIKey_Valid(t *testing.T) {
 
 func TestValidateAPIKey_Invalid(t *testing.T) {
 	database := setupTestDB(t)
-	service := services.NewAPIKeyService(database)
+	service := services.NewAPIKeyService(database, config.LoadAppConfig())
 
-	_, err := service.ValidateAPIKey("invalid-key")
+	_, err := service.ValidateAPIKey("invalid-key", "")
 
 	assert.ErrorIs(t, err, services.ErrInvalidAPIKey)
 }
@@ -222,14 +225,14 @@ func TestValidateAPIKey_Invalid(t *testing.T) {
 func TestValidateAPIKey_Revoked(t *testing.T) {
 	database := setupTestDB(t)
 	user := createTestUser(t, database)
-	service := services.NewAPIKeyService(database)
+	service := services.NewAPIKeyService(database, config.LoadAppConfig())
 
 	apiKey, err := service.GenerateAPIKey(user.ID, "Test Key", nil)
 	require.NoError(t, err)
-	err = service.RevokeAPIKey(user.ID, apiKey.ID)
+	err = service.RevokeAPIKey(user.ID, apiKey.ID, "")
 	require.NoError(t, err)
 
-	_, err = service.ValidateAPIKey(apiKey.Key)
+	_, err = service.ValidateAPIKey(apiKey.Key, "")
 
 	assert.ErrorIs(t, err, services.ErrAPIKeyRevoked)
 }
@@ -237,7 +240,7 @@ func TestValidateAPIKey_Revoked(t *testing.T) {
 func TestValidateAPIKey_Expired(t *testing.T) {
 	database := setupTestDB(t)
 	user := createTestUser(t, database)
-	service := services.NewAPIKeyService(database)
+	service := services.NewAPIKeyService(database, config.LoadAppConfig())
 
 	apiKey, err := service.GenerateAPIKey(user.ID, "Test Key", nil)
 	require.NoError(t, err)
@@ -245,7 +248,7 @@ func TestValidateAPIKey_Expired(t *testing.T) {
 	expiredTime := time.Now().Add(-24 * time.Hour)
 	database.Model(apiKey).Update("expires_at", expiredTime)
 
-	_, err = service.ValidateAPIKey(apiKey.Key)
+	_, err = service.ValidateAPIKey(apiKey.Key, "")
 
 	assert.ErrorIs(t, err, services.ErrAPIKeyExpired)
 }
@@ -253,15 +256,291 @@ func TestValidateAPIKey_Expired(t *testing.T) {
 func TestValidateAPIKey_UpdatesLastUsed(t *testing.T) {
 	database := setupTestDB(t)
 	user := createTestUser(t, database)
-	service := services.NewAPIKeyService(database)
+	service := services.NewAPIKeyService(database, config.LoadAppConfig())
 
 	apiKey, err := service.GenerateAPIKey(user.ID, "Test Key", nil)
 	require.NoError(t, err)
 
-	_, err = service.ValidateAPIKey(apiKey.Key)
+	_, err = service.ValidateAPIKey(apiKey.Key, "")
 	require.NoError(t, err)
 
 	var updatedKey db.APIKey
 	database.First(&updatedKey, apiKey.ID)
 	assert.NotNil(t, updatedKey.LastUsedAt)
 }
+
+func TestValidateAPIKey_BuffersLastUsedUntilFlush(t *testing.T) {
+	database := setupTestDB(t)
+	user := createTestUser(t, database)
+	service := services.NewAPIKeyService(database, config.LoadAppConfig())
+
+	apiKey, err := service.GenerateAPIKey(user.ID, "Test Key", nil)
+	require.NoError(t, err)
+
+	service.StartLastUsedFlush(time.Hour, nil)
+	for i := 0; i < 3; i++ {
+		_, err = service.ValidateAPIKey(apiKey.Key, "")
+		require.NoError(t, err)
+	}
+
+	var stored db.APIKey
+	database.First(&stored, apiKey.ID)
+	assert.Nil(t, stored.LastUsedAt)
+
+	require.NoError(t, service.FlushLastUsed())
+	database.First(&stored, apiKey.ID)
+	require.NotNil(t, stored.LastUsedAt)
+	flushed := *stored.LastUsedAt
+
+	// Stopping writes whatever is still buffered.
+	time.Sleep(10 * time.Millisecond)
+	_, err = service.ValidateAPIKey(apiKey.Key, "")
+	require.NoError(t, err)
+	require.NoError(t, service.StopLastUsedFlush())
+	database.First(&stored, apiKey.ID)
+	assert.True(t, stored.LastUsedAt.After(flushed))
+}
+
+func TestGenerateAPIKey_StoresOnlyPrefixAndHash(t *testing.T) {
+	database := setupTestDB(t)
+	user := createTestUser(t, database)
+	service := services.NewAPIKeyService(database, config.LoadAppConfig())
+
+	apiKey, err := service.GenerateAPIKey(user.ID, "Test Key", nil)
+	require.NoError(t, err)
+
+	prefix, secret, found := strings.Cut(apiKey.Key, "_")
+	require.True(t, found)
+	assert.Equal(t, apiKey.Prefix, prefix)
+
+	var stored db.APIKey
+	require.NoError(t, database.First(&stored, apiKey.ID).Error)
+	assert.Equal(t, prefix, stored.Prefix)
+	assert.NotEmpty(t, stored.KeyHash)
+	assert.NotContains(t, stored.KeyHash, secret)
+	assert.Empty(t, stored.Key)
+}
+
+func TestValidateAPIKey_WrongSecret(t *testing.T) {
+	database := setupTestDB(t)
+	user := createTestUser(t, database)
+	service := services.NewAPIKeyService(database, config.LoadAppConfig())
+
+	apiKey, err := service.GenerateAPIKey(user.ID, "Test Key", nil)
+	require.NoError(t, err)
+
+	_, err = service.ValidateAPIKey(apiKey.Prefix+"_"+strings.Repeat("0", 64), "")
+
+	assert.ErrorIs(t, err, services.ErrInvalidAPIKey)
+}
+
+func TestValidateAPIKey_DifferentPepper(t *testing.T) {
+	database := setupTestDB(t)
+	user := createTestUser(t, database)
+	cfg := config.LoadAppConfig()
+	cfg.APIKeyPepper = "pepper-one"
+	service := services.NewAPIKeyService(database, cfg)
+
+	apiKey, err := service.GenerateAPIKey(user.ID, "Test Key", nil)
+	require.NoError(t, err)
+
+	otherCfg := config.LoadAppConfig()
+	otherCfg.APIKeyPepper = "pepper-two"
+	_, err = services.NewAPIKeyService(database, otherCfg).ValidateAPIKey(apiKey.Key, "")
+
+	assert.ErrorIs(t, err, services.ErrInvalidAPIKey)
+}
+
+func TestMigratePlaintextKeys(t *testing.T) {
+	database := setupTestDB(t)
+	user := createTestUser(t, database)
+	service := services.NewAPIKeyService(database, config.LoadAppConfig())
+
+	legacyKey := strings.Repeat("ab", 32)
+	require.NoError(t, database.Exec("ALTER TABLE api_keys ADD COLUMN key varchar(255)").Error)
+	require.NoError(t, database.Exec(
+		"INSERT INTO api_keys (key, user_id, name) VALUES (?, ?, ?)",
+		legacyKey, user.ID, "Legacy Key",
+	).Error)
+
+	migrated, err := service.MigratePlaintextKeys()
+
+	require.NoError(t, err)
+	assert.Equal(t, 1, migrated)
+	columns, err := database.Migrator().ColumnTypes("api_keys")
+	require.NoError(t, err)
+	for _, column := range columns {
+		assert.NotEqual(t, "key", column.Name())
+	}
+
+	validatedKey, err := service.ValidateAPIKey(legacyKey, "")
+	require.NoError(t, err)
+	assert.Equal(t, "Legacy Key", validatedKey.Name)
+	assert.Equal(t, legacyKey[:12], validatedKey.Prefix)
+}
+
+func TestRotateAPIKey_GracePeriod(t *testing.T) {
+	database := setupTestDB(t)
+	user := createTestUser(t, database)
+	service := services.NewAPIKeyService(database, config.LoadAppConfig())
+
+	apiKey, err := service.GenerateAPIKey(user.ID, "Test Key", nil)
+	require.NoError(t, err)
+
+	newApiKey, err := service.RotateAPIKey(user.ID, apiKey.ID, time.Hour)
+	require.NoError(t, err)
+
+	_, err = service.ValidateAPIKey(apiKey.Key, "")
+	assert.NoError(t, err)
+	_, err = service.ValidateAPIKey(newApiKey.Key, "")
+	assert.NoError(t, err)
+
+	var oldKeyResult db.APIKey
+	require.NoError(t, database.First(&oldKeyResult, apiKey.ID).Error)
+	assert.Equal(t, db.APIKeyStatusActive, oldKeyResult.Status)
+	require.NotNil(t, oldKeyResult.SuccessorID)
+	assert.Equal(t, newApiKey.ID, *oldKeyResult.SuccessorID)
+	require.NotNil(t, oldKeyResult.GraceExpiresAt)
+}
+
+func TestRotateAPIKey_GracePeriodElapsed(t *testing.T) {
+	database := setupTestDB(t)
+	user := createTestUser(t, database)
+	service := services.NewAPIKeyService(database, config.LoadAppConfig())
+
+	apiKey, err := service.GenerateAPIKey(user.ID, "Test Key", nil)
+	require.NoError(t, err)
+	_, err = service.RotateAPIKey(user.ID, apiKey.ID, time.Hour)
+	require.NoError(t, err)
+
+	database.Model(&db.APIKey{}).Where("id = ?", apiKey.ID).Update("grace_expires_at", time.Now().Add(-time.Minute))
+
+	_, err = service.ValidateAPIKey(apiKey.Key, "")
+	assert.ErrorIs(t, err, services.ErrAPIKeyRevoked)
+
+	var oldKeyResult db.APIKey
+	require.NoError(t, database.First(&oldKeyResult, apiKey.ID).Error)
+	assert.Equal(t, db.APIKeyStatusRotated, oldKeyResult.Status)
+}
+
+func TestRotateAPIKey_AlreadyRotated(t *testing.T) {
+	database := setupTestDB(t)
+	user := createTestUser(t, database)
+	service := services.NewAPIKeyService(database, config.LoadAppConfig())
+
+	apiKey, err := service.GenerateAPIKey(user.ID, "Test Key", nil)
+	require.NoError(t, err)
+	_, err = service.RotateAPIKey(user.ID, apiKey.ID, time.Hour)
+	require.NoError(t, err)
+
+	_, err = service.RotateAPIKey(user.ID, apiKey.ID, time.Hour)
+
+	assert.ErrorIs(t, err, services.ErrAPIKeyAlreadyRotated)
+}
+
+func TestRotateAPIKey_PastExpiry(t *testing.T) {
+	database := setupTestDB(t)
+	user := createTestUser(t, database)
+	service := services.NewAPIKeyService(database, config.LoadAppConfig())
+
+	apiKey, err := service.GenerateAPIKey(user.ID, "Test Key", nil)
+	require.NoError(t, err)
+	require.NoError(t, database.Model(&db.APIKey{}).Where("id = ?", apiKey.ID).
+		Update("expires_at", time.Now().Add(-time.Minute)).Error)
+
+	_, err = service.RotateAPIKey(user.ID, apiKey.ID, time.Hour)
+
+	assert.ErrorIs(t, err, services.ErrAPIKeyExpired)
+}
+
+func TestRotateAPIKey_KeepsExpiry(t *testing.T) {
+	database := setupTestDB(t)
+	user := createTestUser(t, database)
+	service := services.NewAPIKeyService(database, config.LoadAppConfig())
+
+	expiresIn := 30
+	apiKey, err := service.GenerateAPIKey(user.ID, "Test Key", &expiresIn)
+	require.NoError(t, err)
+	require.NotNil(t, apiKey.ExpiresAt)
+
+	successor, err := service.RotateAPIKey(user.ID, apiKey.ID, time.Hour)
+
+	require.NoError(t, err)
+	require.NotNil(t, successor.ExpiresAt)
+	assert.WithinDuration(t, *apiKey.ExpiresAt, *successor.ExpiresAt, time.Second)
+}
+
+func TestRotateAPIKey_AtMaxKeys(t *testing.T) {
+	database := setupTestDB(t)
+	user := createTestUser(t, database)
+	service := services.NewAPIKeyService(database, config.LoadAppConfig())
+
+	var apiKey *db.APIKey
+	for i := 0; i < services.MaxActiveAPIKeys; i++ {
+		var err error
+		apiKey, err = service.GenerateAPIKey(user.ID, "Test Key", nil)
+		require.NoError(t, err)
+	}
+
+	_, err := service.RotateAPIKey(user.ID, apiKey.ID, time.Hour)
+
+	assert.NoError(t, err)
+}
+
+func TestSweepExpiredKeys_MarksExpiredAcrossUsers(t *testing.T) {
+	database := setupTestDB(t)
+	user := createTestUser(t, database)
+	other := &db.User{Name: "Other", Email: "other@example.com", Password: "password123"}
+	require.NoError(t, database.Create(other).Error)
+	service := services.NewAPIKeyService(database, config.LoadAppConfig())
+
+	expiring, err := service.GenerateAPIKey(user.ID, "Expiring", nil)
+	require.NoError(t, err)
+	othersExpiring, err := service.GenerateAPIKey(other.ID, "Expiring", nil)
+	require.NoError(t, err)
+	live, err := service.GenerateAPIKey(user.ID, "Live", nil)
+	require.NoError(t, err)
+	rotated, err := service.GenerateAPIKey(other.ID, "Rotated", nil)
+	require.NoError(t, err)
+	_, err = service.RotateAPIKey(other.ID, rotated.ID, time.Hour)
+	require.NoError(t, err)
+
+	past := time.Now().Add(-time.Minute)
+	database.Model(&db.APIKey{}).Where("id IN ?", []uint{expiring.ID, othersExpiring.ID}).Update("expires_at", past)
+	database.Model(&db.APIKey{}).Where("id = ?", rotated.ID).Update("grace_expires_at", past)
+
+	result, err := service.SweepExpiredKeys(time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, services.KeySweepResult{Expired: 2, Rotated: 1}, result)
+
+	var expiredKey, liveKey, rotatedKey db.APIKey
+	require.NoError(t, database.First(&expiredKey, expiring.ID).Error)
+	assert.Equal(t, db.APIKeyStatusExpired, expiredKey.Status)
+	require.NoError(t, database.First(&liveKey, live.ID).Error)
+	assert.Equal(t, db.APIKeyStatusActive, liveKey.Status)
+	require.NoError(t, database.First(&rotatedKey, rotated.ID).Error)
+	assert.Equal(t, db.APIKeyStatusRotated, rotatedKey.Status)
+
+	// A second sweep finds nothing new.
+	result, err = service.SweepExpiredKeys(time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, services.KeySweepResult{}, result)
+}
+
+func TestCreateAPIKey_ExpiredKeysDontCountTowardsLimit(t *testing.T) {
+	database := setupTestDB(t)
+	user := createTestUser(t, database)
+	service := services.NewAPIKeyService(database, config.LoadAppConfig())
+
+	for i := 0; i < services.MaxActiveAPIKeys; i++ {
+		_, err := service.GenerateAPIKey(user.ID, fmt.Sprintf("Key %d", i), nil)
+		require.NoError(t, err)
+	}
+	_, err := service.GenerateAPIKey(user.ID, "One Too Many", nil)
+	assert.ErrorIs(t, err, services.ErrTooManyAPIKeys)
+
+	// Expired but not yet swept.
+	database.Model(&db.APIKey{}).Where("name = ?", "Key 0").Update("expires_at", time.Now().Add(-time.Minute))
+	_, err = service.GenerateAPIKey(user.ID, "Replacement", nil)
+	assert.NoError(t, err)
+}
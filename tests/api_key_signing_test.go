@@ -0,0 +1,138 @@
+package tests
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Brownei/api-generation-api/config"
+	"github.com/Brownei/api-generation-api/db"
+	"github.com/Brownei/api-generation-api/middleware"
+	"github.com/Brownei/api-generation-api/services"
+	"github.com/Brownei/api-generation-api/types"
+	"github.com/Brownei/api-generation-api/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func signedRequest(apiKey *db.APIKey, method, path, body, nonce string, at time.Time) services.SignedRequest {
+	req := services.SignedRequest{
+		KeyID:     apiKey.Prefix,
+		Method:    method,
+		Path:      path,
+		Timestamp: strconv.FormatInt(at.Unix(), 10),
+		BodyHash:  services.HashBody([]byte(body)),
+		Nonce:     nonce,
+	}
+	req.Signature = services.SignRequest(apiKey.SigningSecret, req)
+	return req
+}
+
+func TestVerifySignedRequest(t *testing.T) {
+	database := setupTestDB(t)
+	user := createTestUser(t, database)
+	service := services.NewAPIKeyService(database, config.LoadAppConfig())
+
+	apiKey, err := service.CreateAPIKey(user.ID, services.APIKeyOptions{Name: "Signer", Signing: true})
+	require.NoError(t, err)
+	require.NotEmpty(t, apiKey.SigningSecret)
+
+	var stored db.APIKey
+	require.NoError(t, database.First(&stored, apiKey.ID).Error)
+	assert.NotContains(t, stored.SealedSigningSecret, apiKey.SigningSecret)
+
+	req := signedRequest(apiKey, "POST", "/v1/api/users/1", `{"a":1}`, "nonce-1", time.Now())
+	verified, err := service.VerifySignedRequest(req, "")
+	require.NoError(t, err)
+	assert.Equal(t, apiKey.ID, verified.ID)
+
+	_, err = service.VerifySignedRequest(req, "")
+	assert.ErrorIs(t, err, services.ErrNonceReused)
+
+	tampered := signedRequest(apiKey, "POST", "/v1/api/users/1", `{"a":1}`, "nonce-2", time.Now())
+	tampered.BodyHash = services.HashBody([]byte(`{"a":2}`))
+	_, err = service.VerifySignedRequest(tampered, "")
+	assert.ErrorIs(t, err, services.ErrInvalidSignature)
+
+	stale := signedRequest(apiKey, "GET", "/v1/api/users/1", "", "nonce-3", time.Now().Add(-10*time.Minute))
+	_, err = service.VerifySignedRequest(stale, "")
+	assert.ErrorIs(t, err, services.ErrSignatureExpired)
+
+	// A signing key can't be sent as a bearer key.
+	_, err = service.ValidateAPIKey(apiKey.Key, "")
+	assert.ErrorIs(t, err, services.ErrSignatureRequired)
+}
+
+func TestVerifySignedRequest_RevokedKey(t *testing.T) {
+	database := setupTestDB(t)
+	user := createTestUser(t, database)
+	service := services.NewAPIKeyService(database, config.LoadAppConfig())
+
+	apiKey, err := service.CreateAPIKey(user.ID, services.APIKeyOptions{Name: "Signer", Signing: true})
+	require.NoError(t, err)
+	require.NoError(t, service.RevokeAPIKey(user.ID, apiKey.ID, ""))
+
+	_, err = service.VerifySignedRequest(signedRequest(apiKey, "GET", "/", "", "nonce", time.Now()), "")
+
+	assert.ErrorIs(t, err, services.ErrAPIKeyRevoked)
+}
+
+func TestRotateAPIKey_IssuesNewSigningSecret(t *testing.T) {
+	database := setupTestDB(t)
+	user := createTestUser(t, database)
+	service := services.NewAPIKeyService(database, config.LoadAppConfig())
+
+	apiKey, err := service.CreateAPIKey(user.ID, services.APIKeyOptions{Name: "Signer", Signing: true})
+	require.NoError(t, err)
+
+	successor, err := service.RotateAPIKey(user.ID, apiKey.ID, 0)
+
+	require.NoError(t, err)
+	assert.True(t, successor.RequiresSignature())
+	assert.NotEmpty(t, successor.SigningSecret)
+	assert.NotEqual(t, apiKey.SigningSecret, successor.SigningSecret)
+}
+
+func TestSignatureMiddleware(t *testing.T) {
+	database := setupTestDB(t)
+	user := createTestUser(t, database)
+	service := services.NewAPIKeyService(database, config.LoadAppConfig())
+	mw := middleware.Authenticate(middleware.NewSignatureAuthenticator(service, nil), middleware.NewAPIKeyAuthenticator(service, nil))
+
+	apiKey, err := service.CreateAPIKey(user.ID, services.APIKeyOptions{Name: "Signer", Signing: true})
+	require.NoError(t, err)
+
+	body := `{"name":"x"}`
+	signed := signedRequest(apiKey, http.MethodPost, "/test?page=2", body, "abc123", time.Now())
+	req := httptest.NewRequest(http.MethodPost, "/test?page=2", strings.NewReader(body))
+	req.Header.Set(middleware.SignatureKeyIDHeader, signed.KeyID)
+	req.Header.Set(middleware.SignatureTimestampHeader, signed.Timestamp)
+	req.Header.Set(middleware.SignatureNonceHeader, signed.Nonce)
+	req.Header.Set(middleware.SignatureHeader, signed.Signature)
+	w := httptest.NewRecorder()
+
+	var principal *types.Principal
+	var seenBody string
+	mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, _ = utils.PrincipalFromContext(r.Context())
+		raw, _ := io.ReadAll(r.Body)
+		seenBody = string(raw)
+	})).ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	require.NotNil(t, principal)
+	assert.Equal(t, types.AuthMethodSignature, principal.Method)
+	assert.Equal(t, apiKey.ID, principal.APIKeyID)
+	assert.Equal(t, body, seenBody)
+
+	// Sending the key itself is refused.
+	req = httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.Header.Set("X-API-Key", apiKey.Key)
+	w = httptest.NewRecorder()
+	mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
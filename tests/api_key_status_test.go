@@ -0,0 +1,182 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Brownei/api-generation-api/config"
+	"github.com/Brownei/api-generation-api/controllers"
+	"github.com/Brownei/api-generation-api/db"
+	"github.com/Brownei/api-generation-api/services"
+	"github.com/Brownei/api-generation-api/utils"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestRevokeAPIKey_RecordsReason(t *testing.T) {
+	database := setupTestDB(t)
+	user := createTestUser(t, database)
+	service := services.NewAPIKeyService(database, config.LoadAppConfig())
+
+	apiKey, err := service.GenerateAPIKey(user.ID, "Test Key", nil)
+	require.NoError(t, err)
+
+	require.NoError(t, service.RevokeAPIKey(user.ID, apiKey.ID, "leaked in a build log"))
+	// Revoking again keeps the original record.
+	require.NoError(t, service.RevokeAPIKey(user.ID, apiKey.ID, "second attempt"))
+
+	var revoked db.APIKey
+	require.NoError(t, database.First(&revoked, apiKey.ID).Error)
+	assert.Equal(t, db.APIKeyStatusRevoked, revoked.Status)
+	assert.Equal(t, "leaked in a build log", revoked.RevocationReason)
+	require.NotNil(t, revoked.RevokedBy)
+	assert.Equal(t, user.ID, *revoked.RevokedBy)
+	assert.NotNil(t, revoked.RevokedAt)
+
+	_, err = service.ValidateAPIKey(apiKey.Key, "")
+	assert.ErrorIs(t, err, services.ErrAPIKeyRevoked)
+}
+
+func TestSuspendAPIKey_BlocksUntilReactivated(t *testing.T) {
+	database := setupTestDB(t)
+	user := createTestUser(t, database)
+	service := services.NewAPIKeyService(database, config.LoadAppConfig())
+
+	apiKey, err := service.GenerateAPIKey(user.ID, "Test Key", nil)
+	require.NoError(t, err)
+
+	require.NoError(t, service.SuspendAPIKey(99, apiKey.ID, "abuse report"))
+	_, err = service.ValidateAPIKey(apiKey.Key, "")
+	assert.ErrorIs(t, err, services.ErrAPIKeySuspended)
+
+	require.NoError(t, service.ReactivateAPIKey(apiKey.ID))
+	_, err = service.ValidateAPIKey(apiKey.Key, "")
+	assert.NoError(t, err)
+
+	var reactivated db.APIKey
+	require.NoError(t, database.First(&reactivated, apiKey.ID).Error)
+	assert.Equal(t, db.APIKeyStatusActive, reactivated.Status)
+	assert.Nil(t, reactivated.RevokedAt)
+	assert.Empty(t, reactivated.RevocationReason)
+
+	assert.ErrorIs(t, service.ReactivateAPIKey(apiKey.ID), services.ErrAPIKeyNotSuspended)
+	assert.ErrorIs(t, service.ReactivateAPIKey(9999), services.ErrAPIKeyNotFound)
+}
+
+func TestReactivateAPIKey_AtMaxKeys(t *testing.T) {
+	database := setupTestDB(t)
+	user := createTestUser(t, database)
+	service := services.NewAPIKeyService(database, config.LoadAppConfig())
+
+	suspended, err := service.GenerateAPIKey(user.ID, "Suspended Key", nil)
+	require.NoError(t, err)
+	require.NoError(t, service.SuspendAPIKey(99, suspended.ID, "abuse report"))
+	// A suspended key doesn't count, so the user can replace it.
+	for i := 0; i < services.MaxActiveAPIKeys; i++ {
+		_, err := service.GenerateAPIKey(user.ID, "Replacement Key", nil)
+		require.NoError(t, err)
+	}
+
+	err = service.ReactivateAPIKey(suspended.ID)
+
+	assert.ErrorIs(t, err, services.ErrTooManyAPIKeys)
+	var apiKey db.APIKey
+	require.NoError(t, database.First(&apiKey, suspended.ID).Error)
+	assert.Equal(t, db.APIKeyStatusSuspended, apiKey.Status)
+}
+
+func TestSuspendAPIKey_RevokedKeyCannotBeSuspended(t *testing.T) {
+	database := setupTestDB(t)
+	user := createTestUser(t, database)
+	service := services.NewAPIKeyService(database, config.LoadAppConfig())
+
+	apiKey, err := service.GenerateAPIKey(user.ID, "Test Key", nil)
+	require.NoError(t, err)
+	require.NoError(t, service.RevokeAPIKey(user.ID, apiKey.ID, ""))
+
+	err = service.SuspendAPIKey(99, apiKey.ID, "")
+
+	assert.ErrorIs(t, err, services.ErrAPIKeyNotActive)
+}
+
+func TestMigrateKeyStatus_ConvertsLegacyFlags(t *testing.T) {
+	database := setupTestDB(t)
+	user := createTestUser(t, database)
+	service := services.NewAPIKeyService(database, config.LoadAppConfig())
+
+	revoked, err := service.GenerateAPIKey(user.ID, "Revoked", nil)
+	require.NoError(t, err)
+	expired, err := service.GenerateAPIKey(user.ID, "Expired", nil)
+	require.NoError(t, err)
+	live, err := service.GenerateAPIKey(user.ID, "Live", nil)
+	require.NoError(t, err)
+
+	require.NoError(t, database.Exec("ALTER TABLE api_keys ADD COLUMN is_revoked boolean DEFAULT false").Error)
+	require.NoError(t, database.Exec("ALTER TABLE api_keys ADD COLUMN is_expired boolean DEFAULT false").Error)
+	require.NoError(t, database.Exec("UPDATE api_keys SET is_revoked = true WHERE id = ?", revoked.ID).Error)
+	require.NoError(t, database.Exec("UPDATE api_keys SET is_expired = true WHERE id = ?", expired.ID).Error)
+
+	migrated, err := service.MigrateKeyStatus()
+
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), migrated)
+	assert.False(t, database.Migrator().HasColumn("api_keys", "is_revoked"))
+	assert.False(t, database.Migrator().HasColumn("api_keys", "is_expired"))
+
+	statuses := map[uint]string{}
+	for _, id := range []uint{revoked.ID, expired.ID, live.ID} {
+		var key db.APIKey
+		require.NoError(t, database.First(&key, id).Error)
+		statuses[id] = key.Status
+	}
+	assert.Equal(t, map[uint]string{
+		revoked.ID: db.APIKeyStatusRevoked,
+		expired.ID: db.APIKeyStatusExpired,
+		live.ID:    db.APIKeyStatusActive,
+	}, statuses)
+
+	// Nothing left to convert on the next start.
+	migrated, err = service.MigrateKeyStatus()
+	require.NoError(t, err)
+	assert.Zero(t, migrated)
+}
+
+func TestAPIKeyController_SuspendAPIKey_AdminOnly(t *testing.T) {
+	database := setupTestDB(t)
+	user := createTestUser(t, database)
+	admin := &db.User{Name: "Admin", Email: "admin@example.com", Password: "password123", IsAdmin: true}
+	require.NoError(t, database.Create(admin).Error)
+
+	logger, _ := zap.NewDevelopment()
+	apiKeyService := services.NewAPIKeyService(database, config.LoadAppConfig())
+	controller := controllers.NewAPIKeyController(apiKeyService, services.NewUsageService(database), services.NewAuthService(database, config.LoadAppConfig()), logger.Sugar())
+	router := chi.NewRouter()
+	router.Post("/admin/api-keys/{id}/suspend", controller.SuspendAPIKey)
+
+	apiKey, err := apiKeyService.GenerateAPIKey(user.ID, "Test Key", nil)
+	require.NoError(t, err)
+
+	suspend := func(asUser uint) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/admin/api-keys/%d/suspend", apiKey.ID), strings.NewReader(`{"reason":"abuse report"}`))
+		req = req.WithContext(context.WithValue(req.Context(), utils.UserIDKey, asUser))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusForbidden, suspend(user.ID).Code)
+	assert.Equal(t, http.StatusOK, suspend(admin.ID).Code)
+
+	var suspended db.APIKey
+	require.NoError(t, database.First(&suspended, apiKey.ID).Error)
+	assert.Equal(t, db.APIKeyStatusSuspended, suspended.Status)
+	assert.Equal(t, "abuse report", suspended.RevocationReason)
+	require.NotNil(t, suspended.RevokedBy)
+	assert.Equal(t, admin.ID, *suspended.RevokedBy)
+}
@@ -0,0 +1,137 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Brownei/api-generation-api/config"
+	"github.com/Brownei/api-generation-api/controllers"
+	"github.com/Brownei/api-generation-api/db"
+	"github.com/Brownei/api-generation-api/services"
+	"github.com/Brownei/api-generation-api/utils"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+func setupUsageTest(t *testing.T) (*gorm.DB, *services.UsageService, *db.User, *db.APIKey) {
+	database := setupTestDB(t)
+	require.NoError(t, database.AutoMigrate(&db.AccessLogs{}, &db.APIKeyUsage{}, &db.APIKeyUsageCursor{}))
+	user := createTestUser(t, database)
+	apiKey, err := services.NewAPIKeyService(database, config.LoadAppConfig()).GenerateAPIKey(user.ID, "Usage Key", nil)
+	require.NoError(t, err)
+	return database, services.NewUsageService(database), user, apiKey
+}
+
+func TestUsageService_AggregatesIncrementally(t *testing.T) {
+	database, usageService, user, apiKey := setupUsageTest(t)
+	hour := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	seedAccessLogs(t, database,
+		db.AccessLogs{UserID: user.ID, APIKeyID: &apiKey.ID, Method: "GET", Path: "/v1/api/users/1", Route: "/v1/api/users/{id}", StatusCode: 200, Duration: 3, Timestamp: hour.Add(5 * time.Minute)},
+		db.AccessLogs{UserID: user.ID, APIKeyID: &apiKey.ID, Method: "GET", Path: "/v1/api/users/2", Route: "/v1/api/users/{id}", StatusCode: 404, Duration: 40, Timestamp: hour.Add(10 * time.Minute)},
+		db.AccessLogs{UserID: user.ID, Method: "GET", Path: "/v1/api/users/1", Route: "/v1/api/users/{id}", StatusCode: 200, Duration: 1, Timestamp: hour.Add(15 * time.Minute)},
+	)
+
+	read, err := usageService.Aggregate()
+	require.NoError(t, err)
+	assert.Equal(t, 2, read)
+
+	seedAccessLogs(t, database,
+		db.AccessLogs{UserID: user.ID, APIKeyID: &apiKey.ID, Method: "GET", Path: "/v1/api/users/3", Route: "/v1/api/users/{id}", StatusCode: 500, Duration: 900, Timestamp: hour.Add(20 * time.Minute)},
+		db.AccessLogs{UserID: user.ID, APIKeyID: &apiKey.ID, Method: "GET", Path: "/v1/api/api-key", Route: "/v1/api/api-key/", StatusCode: 200, Duration: 8, Timestamp: hour.Add(90 * time.Minute)},
+	)
+	read, err = usageService.Aggregate()
+	require.NoError(t, err)
+	assert.Equal(t, 2, read)
+
+	// Nothing new: the cursor keeps logs from being counted twice.
+	read, err = usageService.Aggregate()
+	require.NoError(t, err)
+	assert.Equal(t, 0, read)
+
+	var rows []db.APIKeyUsage
+	require.NoError(t, database.Order("bucket_start ASC").Find(&rows).Error)
+	require.Len(t, rows, 2)
+	assert.Equal(t, int64(3), rows[0].RequestCount)
+	assert.Equal(t, int64(2), rows[0].ErrorCount)
+	assert.Equal(t, int64(900), rows[0].MaxDuration)
+
+	report, err := usageService.Usage(user.ID, apiKey.ID, hour, hour.Add(2*time.Hour), services.UsageGranularityHour)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), report.Totals.Requests)
+	assert.Equal(t, int64(2), report.Totals.Errors)
+	assert.Equal(t, int64(10), report.Totals.LatencyP50)
+	assert.Equal(t, int64(900), report.Totals.LatencyP99)
+	require.Len(t, report.Buckets, 2)
+	assert.Equal(t, int64(3), report.Buckets[0].Requests)
+	assert.Equal(t, int64(1), report.Buckets[1].Requests)
+	require.Len(t, report.TopEndpoints, 2)
+	assert.Equal(t, "/v1/api/users/{id}", report.TopEndpoints[0].Route)
+	assert.Equal(t, int64(3), report.TopEndpoints[0].Requests)
+
+	daily, err := usageService.Usage(user.ID, apiKey.ID, hour, hour.Add(time.Hour), services.UsageGranularityDay)
+	require.NoError(t, err)
+	require.Len(t, daily.Buckets, 1)
+	assert.Equal(t, time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), daily.Buckets[0].Start)
+	assert.Equal(t, int64(4), daily.Buckets[0].Requests)
+}
+
+func TestUsageService_Usage_RejectsOtherUsersAndBadInput(t *testing.T) {
+	_, usageService, user, apiKey := setupUsageTest(t)
+	now := time.Now()
+
+	_, err := usageService.Usage(user.ID+1, apiKey.ID, now.Add(-time.Hour), now, services.UsageGranularityHour)
+	assert.ErrorIs(t, err, services.ErrAPIKeyNotFound)
+
+	_, err = usageService.Usage(user.ID, apiKey.ID, now.Add(-time.Hour), now, "minute")
+	assert.ErrorIs(t, err, services.ErrInvalidUsageGranularity)
+
+	_, err = usageService.Usage(user.ID, apiKey.ID, now, now.Add(-time.Hour), services.UsageGranularityHour)
+	assert.ErrorIs(t, err, services.ErrInvalidUsageRange)
+
+	_, err = usageService.Usage(user.ID, apiKey.ID, now.Add(-60*24*time.Hour), now, services.UsageGranularityHour)
+	assert.ErrorIs(t, err, services.ErrUsageRangeTooLarge)
+}
+
+func TestAPIKeyController_GetAPIKeyUsage(t *testing.T) {
+	database, usageService, user, apiKey := setupUsageTest(t)
+	seedAccessLogs(t, database,
+		db.AccessLogs{UserID: user.ID, APIKeyID: &apiKey.ID, Method: "GET", Path: "/v1/api/users/1", StatusCode: 200, Duration: 12},
+	)
+	_, err := usageService.Aggregate()
+	require.NoError(t, err)
+
+	logger, _ := zap.NewDevelopment()
+	controller := controllers.NewAPIKeyController(services.NewAPIKeyService(database, config.LoadAppConfig()), usageService, services.NewAuthService(database, config.LoadAppConfig()), logger.Sugar())
+	router := chi.NewRouter()
+	router.Get("/api-key/{id}/usage", controller.GetAPIKeyUsage)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req = req.WithContext(context.WithValue(req.Context(), utils.UserIDKey, user.ID))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("/api-key/1/usage")
+	require.Equal(t, http.StatusOK, w.Code)
+	var report services.APIKeyUsageReport
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&report))
+	assert.Equal(t, services.UsageGranularityHour, report.Granularity)
+	// The last day, widened to whole hours, including the current one.
+	assert.Len(t, report.Buckets, 25)
+	assert.Equal(t, int64(1), report.Totals.Requests)
+	require.Len(t, report.TopEndpoints, 1)
+	assert.Equal(t, "/v1/api/users/1", report.TopEndpoints[0].Route)
+
+	assert.Equal(t, http.StatusBadRequest, get("/api-key/1/usage?granularity=week").Code)
+	assert.Equal(t, http.StatusBadRequest, get("/api-key/1/usage?from=yesterday").Code)
+	assert.Equal(t, http.StatusNotFound, get("/api-key/99/usage").Code)
+}
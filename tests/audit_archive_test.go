@@ -0,0 +1,190 @@
+package tests
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Brownei/api-generation-api/config"
+	"github.com/Brownei/api-generation-api/controllers"
+	"github.com/Brownei/api-generation-api/db"
+	"github.com/Brownei/api-generation-api/dto"
+	"github.com/Brownei/api-generation-api/services"
+	"github.com/Brownei/api-generation-api/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestArchiveExpired_MovesOldEntriesToArchive(t *testing.T) {
+	database := setupAuditDB(t)
+	cfg := config.LoadAppConfig()
+	cfg.AuditLogRetention = 24 * time.Hour
+	cfg.AuditArchiveDir = t.TempDir()
+	writeChainedLogs(t, database, 5)
+	auditService := services.NewAuditLogService(database, cfg)
+
+	// The first three entries fall outside the retention window.
+	old := time.Now().Add(-48 * time.Hour)
+	for id := 1; id <= 3; id++ {
+		var log db.AccessLogs
+		require.NoError(t, database.First(&log, id).Error)
+		database.Delete(&log)
+		log.Timestamp = old.UTC().Truncate(time.Microsecond)
+		database.Create(&log)
+	}
+
+	archived, err := auditService.ArchiveExpired(time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, 3, archived)
+
+	var remaining int64
+	database.Model(&db.AccessLogs{}).Count(&remaining)
+	assert.Equal(t, int64(2), remaining)
+
+	var archive db.AuditArchive
+	require.NoError(t, database.First(&archive).Error)
+	assert.Equal(t, uint(1), archive.FirstLogID)
+	assert.Equal(t, uint(3), archive.LastLogID)
+
+	file, err := os.Open(archive.Location)
+	require.NoError(t, err)
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	require.NoError(t, err)
+	lines := 0
+	scanner := bufio.NewScanner(gz)
+	for scanner.Scan() {
+		var log db.AccessLogs
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &log))
+		lines++
+	}
+	assert.Equal(t, 3, lines)
+
+	_, err = auditService.ArchiveExpired(time.Now())
+	require.NoError(t, err)
+	database.Model(&db.AccessLogs{}).Count(&remaining)
+	assert.Equal(t, int64(2), remaining)
+}
+
+func TestArchiveExpired_DisabledRetention(t *testing.T) {
+	database := setupAuditDB(t)
+	cfg := config.LoadAppConfig()
+	cfg.AuditLogRetention = 0
+	writeChainedLogs(t, database, 2)
+
+	archived, err := services.NewAuditLogService(database, cfg).ArchiveExpired(time.Now().Add(24 * 365 * time.Hour))
+
+	require.NoError(t, err)
+	assert.Equal(t, 0, archived)
+}
+
+func TestVerifyChain_AfterArchival(t *testing.T) {
+	database := setupAuditDB(t)
+	cfg := config.LoadAppConfig()
+	cfg.AuditLogRetention = time.Hour
+	cfg.AuditArchiveDir = t.TempDir()
+	writeChainedLogs(t, database, 4)
+	auditService := services.NewAuditLogService(database, cfg)
+
+	// Everything is older than an hour from the job's point of view.
+	_, err := auditService.ArchiveExpired(time.Now().Add(2 * time.Hour))
+	require.NoError(t, err)
+	writeChainedLogs(t, database, 2)
+
+	report, err := auditService.VerifyChain()
+	require.NoError(t, err)
+	assert.True(t, report.Valid, report.Reason)
+	assert.Equal(t, 2, report.LogsChecked)
+	assert.Equal(t, 1, report.ArchivesChecked)
+
+	// Deleting the entry right after the archive is still caught.
+	database.Delete(&db.AccessLogs{}, 5)
+	report, err = auditService.VerifyChain()
+	require.NoError(t, err)
+	assert.False(t, report.Valid)
+}
+
+func TestVerifyChain_DetectsDeletedArchive(t *testing.T) {
+	database := setupAuditDB(t)
+	cfg := config.LoadAppConfig()
+	cfg.AuditLogRetention = time.Hour
+	cfg.AuditArchiveDir = t.TempDir()
+	auditService := services.NewAuditLogService(database, cfg)
+
+	writeChainedLogs(t, database, 3)
+	_, err := auditService.ArchiveExpired(time.Now().Add(2 * time.Hour))
+	require.NoError(t, err)
+	writeChainedLogs(t, database, 3)
+	_, err = auditService.ArchiveExpired(time.Now().Add(2 * time.Hour))
+	require.NoError(t, err)
+	writeChainedLogs(t, database, 2)
+
+	report, err := auditService.VerifyChain()
+	require.NoError(t, err)
+	require.True(t, report.Valid, report.Reason)
+	assert.Equal(t, 2, report.ArchivesChecked)
+
+	var oldest db.AuditArchive
+	require.NoError(t, database.Order("last_log_id ASC").First(&oldest).Error)
+	database.Delete(&oldest)
+
+	report, err = auditService.VerifyChain()
+	require.NoError(t, err)
+	assert.False(t, report.Valid)
+	assert.Equal(t, uint(4), report.BrokenAtID)
+}
+
+func TestAuditLogController_Export(t *testing.T) {
+	database := setupAuditDB(t)
+	user := &db.User{Name: "User", Email: "user@example.com", Password: "password123"}
+	require.NoError(t, database.Create(user).Error)
+	keyID := uint(4)
+	seedAccessLogs(t, database,
+		db.AccessLogs{UserID: user.ID, APIKeyID: &keyID, Method: "GET", Path: "/v1/api/api-key", StatusCode: 200, UserAgent: "agent, with comma"},
+		db.AccessLogs{UserID: user.ID, Method: "POST", Path: "/v1/api/api-key", StatusCode: 201},
+		db.AccessLogs{UserID: user.ID + 1, Method: "GET", Path: "/v1/api/api-key", StatusCode: 200},
+	)
+	logger, _ := zap.NewDevelopment()
+	controller := controllers.NewAuditLogController(
+		services.NewAuditLogService(database, config.LoadAppConfig()),
+		services.NewAuthService(database, config.LoadAppConfig()),
+		logger.Sugar(),
+	)
+
+	export := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/v1/api/audit-logs/export"+query, nil)
+		req = req.WithContext(context.WithValue(req.Context(), utils.UserIDKey, user.ID))
+		w := httptest.NewRecorder()
+		controller.ExportAuditLogs(w, req)
+		return w
+	}
+
+	w := export("?format=csv")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/csv", w.Header().Get("Content-Type"))
+	records, err := csv.NewReader(w.Body).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 3) // header plus the caller's two entries
+	assert.Equal(t, "id", records[0][0])
+	assert.Equal(t, "4", records[1][4])
+	assert.Equal(t, "agent, with comma", records[1][12])
+
+	w = export("?method=post")
+	require.Equal(t, http.StatusOK, w.Code)
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	require.Len(t, lines, 1)
+	var entry dto.AuditLogResponse
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &entry))
+	assert.Equal(t, "POST", entry.Method)
+
+	assert.Equal(t, http.StatusBadRequest, export("?format=xml").Code)
+}
@@ -0,0 +1,145 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Brownei/api-generation-api/config"
+	"github.com/Brownei/api-generation-api/controllers"
+	"github.com/Brownei/api-generation-api/db"
+	"github.com/Brownei/api-generation-api/services"
+	"github.com/Brownei/api-generation-api/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+func writeChainedLogs(t *testing.T, database *gorm.DB, n int) *services.AuditLogService {
+	cfg := config.LoadAppConfig()
+	cfg.AuditLogBatchSize = 3
+	writer := services.NewAuditLogService(database, cfg)
+	for i := 0; i < n; i++ {
+		writer.LogRequest(services.AuditLogEntry{UserID: 1, Method: "GET", Path: "/v1/api/api-key", StatusCode: 200, UserAgent: "TestAgent"})
+	}
+	writer.Close()
+
+	return services.NewAuditLogService(database, cfg)
+}
+
+func TestVerifyChain_Intact(t *testing.T) {
+	database := setupAuditDB(t)
+	auditService := writeChainedLogs(t, database, 10)
+
+	report, err := auditService.VerifyChain()
+
+	require.NoError(t, err)
+	assert.True(t, report.Valid)
+	assert.Equal(t, 10, report.LogsChecked)
+	// Closing the writer signed the head.
+	assert.Equal(t, 1, report.CheckpointsChecked)
+}
+
+func TestVerifyChain_DetectsEditedEntry(t *testing.T) {
+	database := setupAuditDB(t)
+	auditService := writeChainedLogs(t, database, 5)
+
+	database.Model(&db.AccessLogs{}).Where("id = ?", 3).Update("status_code", 500)
+
+	report, err := auditService.VerifyChain()
+	require.NoError(t, err)
+	assert.False(t, report.Valid)
+	assert.Equal(t, uint(3), report.BrokenAtID)
+}
+
+func TestVerifyChain_DetectsDeletedEntries(t *testing.T) {
+	database := setupAuditDB(t)
+	auditService := writeChainedLogs(t, database, 5)
+
+	database.Delete(&db.AccessLogs{}, 2)
+	report, err := auditService.VerifyChain()
+	require.NoError(t, err)
+	assert.False(t, report.Valid)
+	assert.Equal(t, uint(3), report.BrokenAtID)
+
+	// Dropping the tail and rewinding the head is caught by the checkpoint.
+	database = setupAuditDB(t)
+	auditService = writeChainedLogs(t, database, 5)
+	var fourth db.AccessLogs
+	require.NoError(t, database.First(&fourth, 4).Error)
+	database.Delete(&db.AccessLogs{}, 5)
+	database.Model(&db.AuditChainHead{}).Where("id = 1").Updates(map[string]interface{}{"hash": fourth.Hash, "last_log_id": 4})
+
+	report, err = auditService.VerifyChain()
+	require.NoError(t, err)
+	assert.False(t, report.Valid)
+	assert.Equal(t, uint(5), report.BrokenAtID)
+}
+
+func TestVerifyChain_DetectsDeletedOldestEntries(t *testing.T) {
+	database := setupAuditDB(t)
+	auditService := writeChainedLogs(t, database, 5)
+
+	database.Delete(&db.AccessLogs{}, []uint{1, 2})
+	report, err := auditService.VerifyChain()
+
+	require.NoError(t, err)
+	assert.False(t, report.Valid)
+	assert.Equal(t, uint(3), report.BrokenAtID)
+}
+
+func TestVerifyChain_DetectsForgedCheckpoint(t *testing.T) {
+	database := setupAuditDB(t)
+	auditService := writeChainedLogs(t, database, 3)
+
+	database.Model(&db.AuditCheckpoint{}).Where("1 = 1").Update("signature", "forged")
+
+	report, err := auditService.VerifyChain()
+	require.NoError(t, err)
+	assert.False(t, report.Valid)
+	assert.Contains(t, report.Reason, "invalid signature")
+}
+
+func TestAuditLogReader_VerifiesWithoutWriting(t *testing.T) {
+	database := setupAuditDB(t)
+	writeChainedLogs(t, database, 5)
+	// A head no checkpoint covers yet, which closing a writer would sign.
+	database.Where("1 = 1").Delete(&db.AuditCheckpoint{})
+	database.Model(&db.AccessLogs{}).Where("id = ?", 5).Update("path", "/tampered")
+
+	reader := services.NewAuditLogReader(database, config.LoadAppConfig())
+	report, err := reader.VerifyChain()
+	reader.Close()
+
+	require.NoError(t, err)
+	assert.False(t, report.Valid)
+	var checkpoints int64
+	database.Model(&db.AuditCheckpoint{}).Count(&checkpoints)
+	assert.Zero(t, checkpoints)
+}
+
+func TestAuditLogController_VerifyRequiresAdmin(t *testing.T) {
+	database := setupAuditDB(t)
+	user := &db.User{Name: "User", Email: "user@example.com", Password: "password123"}
+	require.NoError(t, database.Create(user).Error)
+	logger, _ := zap.NewDevelopment()
+	controller := controllers.NewAuditLogController(
+		writeChainedLogs(t, database, 1),
+		services.NewAuthService(database, config.LoadAppConfig()),
+		logger.Sugar(),
+	)
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/api/audit-logs/verify", nil)
+	req = req.WithContext(context.WithValue(req.Context(), utils.UserIDKey, user.ID))
+	w := httptest.NewRecorder()
+	controller.VerifyAuditChain(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	database.Model(user).Update("is_admin", true)
+	w = httptest.NewRecorder()
+	controller.VerifyAuditChain(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"valid":true`)
+}
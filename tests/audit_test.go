@@ -2,17 +2,25 @@ package tests
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 	"time"
 
+	"github.com/Brownei/api-generation-api/config"
+	"github.com/Brownei/api-generation-api/controllers"
 	"github.com/Brownei/api-generation-api/db"
+	"github.com/Brownei/api-generation-api/dto"
 	"github.com/Brownei/api-generation-api/middleware"
 	"github.com/Brownei/api-generation-api/services"
+	"github.com/Brownei/api-generation-api/types"
 	"github.com/Brownei/api-generation-api/utils"
+	"github.com/go-chi/chi/v5"
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
@@ -20,7 +28,7 @@ import (
 func setupAuditDB(t *testing.T) *gorm.DB {
 	database, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
 	require.NoError(t, err)
-	err = database.AutoMigrate(&db.User{}, &db.AccessLogs{})
+	err = database.AutoMigrate(&db.User{}, &db.AccessLogs{}, &db.AuditChainHead{}, &db.AuditCheckpoint{}, &db.AuditArchive{})
 	require.NoError(t, err)
 	return database
 }
@@ -35,7 +43,7 @@ func TestAuditLogService_LogRequest(t *testing.T) {
 	}
 	database.Create(user)
 
-	auditService := services.NewAuditLogService(database)
+	auditService := services.NewAuditLogService(database, config.LoadAppConfig())
 
 	auditService.LogRequest(services.AuditLogEntry{
 		UserID:     user.ID,
@@ -47,7 +55,7 @@ func TestAuditLogService_LogRequest(t *testing.T) {
 		Duration:   50,
 	})
 
-	time.Sleep(100 * time.Millisecond)
+	auditService.Close()
 
 	var log db.AccessLogs
 	err := database.Last(&log).Error
@@ -69,7 +77,7 @@ func TestAuditMiddleware_LogsRequest(t *testing.T) {
 	}
 	database.Create(user)
 
-	auditService := services.NewAuditLogService(database)
+	auditService := services.NewAuditLogService(database, config.LoadAppConfig())
 	mw := middleware.AuditLogMiddleware(auditService)
 
 	nextCalled := false
@@ -89,7 +97,7 @@ func TestAuditMiddleware_LogsRequest(t *testing.T) {
 	assert.True(t, nextCalled)
 	assert.Equal(t, http.StatusOK, w.Code)
 
-	time.Sleep(100 * time.Millisecond)
+	auditService.Close()
 
 	var log db.AccessLogs
 	err := database.Last(&log).Error
@@ -102,7 +110,7 @@ func TestAuditMiddleware_LogsRequest(t *testing.T) {
 
 func TestAuditMiddleware_SkipsUnauthenticated(t *testing.T) {
 	database := setupAuditDB(t)
-	auditService := services.NewAuditLogService(database)
+	auditService := services.NewAuditLogService(database, config.LoadAppConfig())
 	mw := middleware.AuditLogMiddleware(auditService)
 
 	nextCalled := false
@@ -123,3 +131,234 @@ func TestAuditMiddleware_SkipsUnauthenticated(t *testing.T) {
 	database.Model(&db.AccessLogs{}).Count(&count)
 	assert.Equal(t, int64(0), count)
 }
+
+func seedAccessLogs(t *testing.T, database *gorm.DB, logs ...db.AccessLogs) {
+	for i := range logs {
+		if logs[i].Timestamp.IsZero() {
+			logs[i].Timestamp = time.Now()
+		}
+		require.NoError(t, database.Create(&logs[i]).Error)
+	}
+}
+
+func TestAuditMiddleware_RecordsAPIKey(t *testing.T) {
+	database := setupAuditDB(t)
+	auditService := services.NewAuditLogService(database, config.LoadAppConfig())
+	handler := middleware.AuditLogMiddleware(auditService)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	principal := &types.Principal{UserID: 1, Method: types.AuthMethodAPIKey, APIKeyID: 9}
+	req := httptest.NewRequest(http.MethodGet, "/v1/api/api-key", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), req.WithContext(utils.WithPrincipal(req.Context(), principal)))
+
+	auditService.Close()
+
+	var log db.AccessLogs
+	require.NoError(t, database.Last(&log).Error)
+	require.NotNil(t, log.APIKeyID)
+	assert.Equal(t, uint(9), *log.APIKeyID)
+}
+
+func TestAuditMiddleware_RecordsRequestContext(t *testing.T) {
+	database := setupAuditDB(t)
+	auditService := services.NewAuditLogService(database, config.LoadAppConfig())
+
+	router := chi.NewRouter()
+	router.Use(chimiddleware.RequestID)
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal := &types.Principal{UserID: 1, Method: types.AuthMethodAPIKey, APIKeyID: 9}
+			next.ServeHTTP(w, r.WithContext(utils.WithPrincipal(r.Context(), principal)))
+		})
+	})
+	router.Use(middleware.AuditLogMiddleware(auditService))
+	router.Get("/v1/api/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/api/users/42", nil)
+	req.Header.Set(chimiddleware.RequestIDHeader, "req-123")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	auditService.Close()
+
+	var log db.AccessLogs
+	require.NoError(t, database.Last(&log).Error)
+	assert.Equal(t, "api_key", log.AuthMethod)
+	assert.Equal(t, "req-123", log.RequestID)
+	assert.Equal(t, "/v1/api/users/42", log.Path)
+	assert.Equal(t, "/v1/api/users/{id}", log.Route)
+	assert.Equal(t, int64(5), log.ResponseSize)
+
+	logs, _, err := auditService.QueryLogs(services.AuditLogQuery{RequestID: "req-123"})
+	require.NoError(t, err)
+	assert.Len(t, logs, 1)
+}
+
+func TestAuditLogService_PrivacyModes(t *testing.T) {
+	logWith := func(ipMode, userAgentMode, ip string) db.AccessLogs {
+		database := setupAuditDB(t)
+		cfg := config.LoadAppConfig()
+		cfg.AuditIPMode = ipMode
+		cfg.AuditUserAgentMode = userAgentMode
+		auditService := services.NewAuditLogService(database, cfg)
+		auditService.LogRequest(services.AuditLogEntry{
+			UserID: 1, Method: "GET", Path: "/", StatusCode: 200,
+			IPAddress: ip, UserAgent: "Mozilla/5.0 (X11; Linux x86_64) Firefox/130.0",
+		})
+		auditService.Close()
+
+		var log db.AccessLogs
+		require.NoError(t, database.Last(&log).Error)
+		return log
+	}
+
+	full := logWith(services.AuditPrivacyFull, services.AuditPrivacyFull, "203.0.113.77")
+	assert.Equal(t, "203.0.113.77", full.IPAddress)
+	assert.Equal(t, "Mozilla/5.0 (X11; Linux x86_64) Firefox/130.0", full.UserAgent)
+
+	truncated := logWith(services.AuditPrivacyTruncate, services.AuditPrivacyTruncate, "203.0.113.77")
+	assert.Equal(t, "203.0.113.0", truncated.IPAddress)
+	assert.Equal(t, "Mozilla/5.0", truncated.UserAgent)
+	assert.Equal(t, "2001:db8:1::", logWith(services.AuditPrivacyTruncate, "", "2001:db8:1:2::5").IPAddress)
+
+	hashed := logWith(services.AuditPrivacyHash, services.AuditPrivacyHash, "203.0.113.77")
+	assert.Len(t, hashed.IPAddress, 32)
+	assert.NotContains(t, hashed.UserAgent, "Mozilla")
+	assert.Equal(t, hashed.IPAddress, logWith(services.AuditPrivacyHash, "", "203.0.113.77").IPAddress)
+	assert.NotEqual(t, hashed.IPAddress, logWith(services.AuditPrivacyHash, "", "203.0.113.78").IPAddress)
+}
+
+func TestAuditLogService_QueryLogs_Filters(t *testing.T) {
+	database := setupAuditDB(t)
+	keyID := uint(3)
+	hourAgo := time.Now().Add(-time.Hour)
+	seedAccessLogs(t, database,
+		db.AccessLogs{UserID: 1, Method: "GET", Path: "/v1/api/api-key", StatusCode: 200},
+		db.AccessLogs{UserID: 1, Method: "POST", Path: "/v1/api/api-key", StatusCode: 201, APIKeyID: &keyID},
+		db.AccessLogs{UserID: 1, Method: "GET", Path: "/v1/api/users/1", StatusCode: 404},
+		db.AccessLogs{UserID: 1, Method: "GET", Path: "/v1/api/api_key", StatusCode: 200, Timestamp: hourAgo.Add(-time.Hour)},
+		db.AccessLogs{UserID: 2, Method: "GET", Path: "/v1/api/api-key", StatusCode: 500},
+	)
+	auditService := services.NewAuditLogService(database, config.LoadAppConfig())
+	userID := uint(1)
+
+	count := func(q services.AuditLogQuery) int {
+		logs, _, err := auditService.QueryLogs(q)
+		require.NoError(t, err)
+		return len(logs)
+	}
+
+	assert.Equal(t, 5, count(services.AuditLogQuery{}))
+	assert.Equal(t, 4, count(services.AuditLogQuery{UserID: &userID}))
+	assert.Equal(t, 1, count(services.AuditLogQuery{Method: "post"}))
+	// "_" is matched literally, not as a wildcard.
+	assert.Equal(t, 3, count(services.AuditLogQuery{PathPrefix: "/v1/api/api-"}))
+	assert.Equal(t, 2, count(services.AuditLogQuery{StatusMin: 400}))
+	assert.Equal(t, 3, count(services.AuditLogQuery{StatusMin: 200, StatusMax: 299}))
+	assert.Equal(t, 1, count(services.AuditLogQuery{APIKeyID: &keyID}))
+	assert.Equal(t, 4, count(services.AuditLogQuery{From: &hourAgo}))
+	assert.Equal(t, 1, count(services.AuditLogQuery{To: &hourAgo}))
+}
+
+func TestAuditLogService_QueryLogs_Pagination(t *testing.T) {
+	database := setupAuditDB(t)
+	for i := 0; i < 5; i++ {
+		seedAccessLogs(t, database, db.AccessLogs{UserID: 1, Method: "GET", Path: "/v1/api/api-key", StatusCode: 200})
+	}
+	auditService := services.NewAuditLogService(database, config.LoadAppConfig())
+
+	var seen []uint
+	cursor := uint(0)
+	for pages := 0; pages < 5; pages++ {
+		logs, next, err := auditService.QueryLogs(services.AuditLogQuery{Limit: 2, Cursor: cursor})
+		require.NoError(t, err)
+		for _, log := range logs {
+			seen = append(seen, log.ID)
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+
+	assert.Equal(t, []uint{5, 4, 3, 2, 1}, seen)
+}
+
+func TestAuditLogController_ScopesToCaller(t *testing.T) {
+	database := setupAuditDB(t)
+	user := &db.User{Name: "User", Email: "user@example.com", Password: "password123"}
+	admin := &db.User{Name: "Admin", Email: "admin@example.com", Password: "password123", IsAdmin: true}
+	require.NoError(t, database.Create(user).Error)
+	require.NoError(t, database.Create(admin).Error)
+	seedAccessLogs(t, database,
+		db.AccessLogs{UserID: user.ID, Method: "GET", Path: "/v1/api/api-key", StatusCode: 200},
+		db.AccessLogs{UserID: admin.ID, Method: "GET", Path: "/v1/api/api-key", StatusCode: 200},
+	)
+
+	logger, _ := zap.NewDevelopment()
+	controller := controllers.NewAuditLogController(
+		services.NewAuditLogService(database, config.LoadAppConfig()),
+		services.NewAuthService(database, config.LoadAppConfig()),
+		logger.Sugar(),
+	)
+
+	list := func(caller *db.User, query string) dto.AuditLogListResponse {
+		req := httptest.NewRequest(http.MethodGet, "/v1/api/audit-logs"+query, nil)
+		req = req.WithContext(context.WithValue(req.Context(), utils.UserIDKey, caller.ID))
+		w := httptest.NewRecorder()
+		controller.ListAuditLogs(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var response dto.AuditLogListResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return response
+	}
+
+	// Asking for someone else's entries still only returns your own.
+	own := list(user, "?user_id=2")
+	require.Len(t, own.Data, 1)
+	assert.Equal(t, user.ID, own.Data[0].UserID)
+
+	assert.Len(t, list(admin, "").Data, 2)
+	assert.Len(t, list(admin, "?user_id=1").Data, 1)
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/api/audit-logs?from=yesterday", nil)
+	req = req.WithContext(context.WithValue(req.Context(), utils.UserIDKey, user.ID))
+	w := httptest.NewRecorder()
+	controller.ListAuditLogs(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestAuditLogService_BatchesAndFlushesOnClose(t *testing.T) {
+	database := setupAuditDB(t)
+	cfg := config.LoadAppConfig()
+	cfg.AuditLogBatchSize = 7
+	cfg.AuditLogFlushInterval = time.Hour
+	auditService := services.NewAuditLogService(database, cfg)
+
+	for i := 0; i < 20; i++ {
+		auditService.LogRequest(services.AuditLogEntry{UserID: 1, Method: "GET", Path: "/v1/api/api-key", StatusCode: 200})
+	}
+	auditService.Close()
+
+	var count int64
+	database.Model(&db.AccessLogs{}).Count(&count)
+	assert.Equal(t, int64(20), count)
+	assert.Equal(t, services.AuditLogStats{}, auditService.Stats())
+}
+
+func TestAuditLogService_CountsDropsAndFailures(t *testing.T) {
+	database, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	// No access_logs table, so every insert fails.
+	auditService := services.NewAuditLogService(database, config.LoadAppConfig())
+
+	auditService.LogRequest(services.AuditLogEntry{UserID: 1, Method: "GET", Path: "/", StatusCode: 200})
+	auditService.LogRequest(services.AuditLogEntry{UserID: 1, Method: "GET", Path: "/", StatusCode: 200})
+	auditService.Close()
+	auditService.LogRequest(services.AuditLogEntry{UserID: 1, Method: "GET", Path: "/", StatusCode: 200})
+
+	stats := auditService.Stats()
+	assert.Equal(t, int64(2), stats.Failed)
+	assert.Equal(t, int64(1), stats.Dropped)
+}
@@ -0,0 +1,310 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Brownei/api-generation-api/config"
+	"github.com/Brownei/api-generation-api/controllers"
+	"github.com/Brownei/api-generation-api/dto"
+	"github.com/Brownei/api-generation-api/middleware"
+	"github.com/Brownei/api-generation-api/services"
+	"github.com/Brownei/api-generation-api/types"
+	"github.com/Brownei/api-generation-api/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// testCA is a throwaway certificate authority for issuing client and
+// server certificates.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns a certificate for commonName and its PEM encoded
+// certificate and key.
+func (ca *testCA) issue(t *testing.T, commonName string, usage x509.ExtKeyUsage) (tls.Certificate, []byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	require.NoError(t, err)
+	return pair, certPEM, keyPEM
+}
+
+// mtlsServer serves an endpoint that reports how the request authenticated,
+// asking for client certificates signed by ca.
+func mtlsServer(t *testing.T, ca *testCA, authenticators ...middleware.Authenticator) *httptest.Server {
+	handler := middleware.Authenticate(authenticators...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, _ := utils.PrincipalFromContext(r.Context())
+		w.Write([]byte(principal.Method))
+	}))
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	server := httptest.NewUnstartedServer(handler)
+	server.TLS = &tls.Config{ClientCAs: pool, ClientAuth: tls.VerifyClientCertIfGiven}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func mtlsGet(t *testing.T, server *httptest.Server, clientCert *tls.Certificate, apiKey string) (int, string) {
+	// A fresh transport each time, so no connection is reused with another
+	// call's certificate.
+	transport := server.Client().Transport.(*http.Transport).Clone()
+	if clientCert != nil {
+		transport.TLSClientConfig.Certificates = []tls.Certificate{*clientCert}
+	}
+	client := &http.Client{Transport: transport}
+	req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	require.NoError(t, err)
+	if apiKey != "" {
+		req.Header.Set("X-API-Key", apiKey)
+	}
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, string(body)
+}
+
+func TestClientCert_AuthenticatesOnItsOwn(t *testing.T) {
+	database := setupTestDB(t)
+	user := createTestUser(t, database)
+	apiKeyService := services.NewAPIKeyService(database, config.LoadAppConfig())
+	certService := services.NewClientCertService(database, apiKeyService)
+	ca := newTestCA(t)
+	clientCert, _, _ := ca.issue(t, "partner", x509.ExtKeyUsageClientAuth)
+	unregistered, _, _ := ca.issue(t, "stranger", x509.ExtKeyUsageClientAuth)
+
+	cert, err := certService.Register(user.ID, services.ClientCertOptions{Name: "Partner", Certificate: clientCert.Leaf})
+	require.NoError(t, err)
+	assert.Equal(t, "CN=partner", cert.Subject)
+	assert.Equal(t, services.DefaultAPIKeyScopes, cert.ScopeList())
+
+	server := mtlsServer(t, ca,
+		middleware.NewAPIKeyAuthenticator(apiKeyService, nil),
+		middleware.NewClientCertAuthenticator(certService, nil),
+	)
+
+	status, body := mtlsGet(t, server, &clientCert, "")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "client_cert", body)
+
+	status, _ = mtlsGet(t, server, &unregistered, "")
+	assert.Equal(t, http.StatusUnauthorized, status)
+	status, _ = mtlsGet(t, server, nil, "")
+	assert.Equal(t, http.StatusUnauthorized, status)
+}
+
+func TestClientCert_RequiredAlongsideKey(t *testing.T) {
+	database := setupTestDB(t)
+	user := createTestUser(t, database)
+	apiKeyService := services.NewAPIKeyService(database, config.LoadAppConfig())
+	certService := services.NewClientCertService(database, apiKeyService)
+	ca := newTestCA(t)
+	clientCert, _, _ := ca.issue(t, "partner", x509.ExtKeyUsageClientAuth)
+	otherCert, _, _ := ca.issue(t, "other", x509.ExtKeyUsageClientAuth)
+
+	apiKey, err := apiKeyService.CreateAPIKey(user.ID, services.APIKeyOptions{Name: "Partner", RequireClientCert: true})
+	require.NoError(t, err)
+	_, err = certService.Register(user.ID, services.ClientCertOptions{Name: "Partner", Certificate: clientCert.Leaf, APIKeyID: &apiKey.ID})
+	require.NoError(t, err)
+	// Registered, but to the user rather than this key.
+	_, err = certService.Register(user.ID, services.ClientCertOptions{Name: "Other", Certificate: otherCert.Leaf})
+	require.NoError(t, err)
+
+	server := mtlsServer(t, ca,
+		middleware.NewAPIKeyAuthenticator(apiKeyService, nil),
+		middleware.NewClientCertAuthenticator(certService, nil),
+	)
+
+	status, body := mtlsGet(t, server, &clientCert, apiKey.Key)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "api_key", body)
+
+	status, _ = mtlsGet(t, server, nil, apiKey.Key)
+	assert.Equal(t, http.StatusUnauthorized, status)
+	status, _ = mtlsGet(t, server, &otherCert, apiKey.Key)
+	assert.Equal(t, http.StatusUnauthorized, status)
+	// The certificate is only a second factor for this key.
+	status, _ = mtlsGet(t, server, &clientCert, "")
+	assert.Equal(t, http.StatusUnauthorized, status)
+}
+
+func TestClientCert_BoundKeyWithoutRequirement(t *testing.T) {
+	database := setupTestDB(t)
+	user := createTestUser(t, database)
+	apiKeyService := services.NewAPIKeyService(database, config.LoadAppConfig())
+	certService := services.NewClientCertService(database, apiKeyService)
+	ca := newTestCA(t)
+	clientCert, _, _ := ca.issue(t, "partner", x509.ExtKeyUsageClientAuth)
+
+	apiKey, err := apiKeyService.CreateAPIKey(user.ID, services.APIKeyOptions{Name: "Partner", Scopes: []string{services.ScopeUsersRead}})
+	require.NoError(t, err)
+	fingerprint := services.CertificateFingerprint(clientCert.Leaf)
+	cert, err := certService.Register(user.ID, services.ClientCertOptions{Name: "Partner", Certificate: clientCert.Leaf, APIKeyID: &apiKey.ID})
+	require.NoError(t, err)
+	assert.Equal(t, fingerprint, cert.Fingerprint)
+
+	_, err = certService.Register(user.ID, services.ClientCertOptions{Name: "Again", Certificate: clientCert.Leaf})
+	assert.ErrorIs(t, err, services.ErrClientCertExists)
+
+	_, key, err := certService.Authenticate(fingerprint, "")
+	require.NoError(t, err)
+	assert.Equal(t, apiKey.ID, key.ID)
+
+	require.NoError(t, apiKeyService.RevokeAPIKey(user.ID, apiKey.ID, ""))
+	_, _, err = certService.Authenticate(fingerprint, "")
+	assert.ErrorIs(t, err, services.ErrAPIKeyRevoked)
+}
+
+func TestClientCertController_RegistersPresentedCertificate(t *testing.T) {
+	database := setupTestDB(t)
+	user := createTestUser(t, database)
+	apiKeyService := services.NewAPIKeyService(database, config.LoadAppConfig())
+	certService := services.NewClientCertService(database, apiKeyService)
+	controller := controllers.NewClientCertController(certService, apiKeyService, zap.NewNop().Sugar())
+	ca := newTestCA(t)
+	clientCert, _, _ := ca.issue(t, "partner", x509.ExtKeyUsageClientAuth)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.WithContext(context.WithValue(r.Context(), utils.UserIDKey, user.ID))
+		controller.RegisterClientCert(w, r)
+	}))
+	server.TLS = &tls.Config{ClientCAs: pool, ClientAuth: tls.VerifyClientCertIfGiven}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	register := func(cert *tls.Certificate) int {
+		transport := server.Client().Transport.(*http.Transport).Clone()
+		if cert != nil {
+			transport.TLSClientConfig.Certificates = []tls.Certificate{*cert}
+		}
+		resp, err := (&http.Client{Transport: transport}).Post(server.URL, "application/json", strings.NewReader(`{"name":"Partner"}`))
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// Without the certificate on the connection there is nothing to register.
+	assert.Equal(t, http.StatusBadRequest, register(nil))
+	assert.Equal(t, http.StatusCreated, register(&clientCert))
+
+	certs, err := certService.List(user.ID)
+	require.NoError(t, err)
+	require.Len(t, certs, 1)
+	assert.Equal(t, services.CertificateFingerprint(clientCert.Leaf), certs[0].Fingerprint)
+}
+
+func TestClientCertController_BindingToStrongerKey(t *testing.T) {
+	database := setupTestDB(t)
+	user := createTestUser(t, database)
+	apiKeyService := services.NewAPIKeyService(database, config.LoadAppConfig())
+	certService := services.NewClientCertService(database, apiKeyService)
+	controller := controllers.NewClientCertController(certService, apiKeyService, zap.NewNop().Sugar())
+
+	stronger, err := apiKeyService.CreateAPIKey(user.ID, services.APIKeyOptions{
+		Name:   "Stronger Key",
+		Scopes: []string{services.ScopeKeysWrite, services.ScopeAuditRead},
+	})
+	require.NoError(t, err)
+
+	body, _ := json.Marshal(dto.RegisterClientCertRequest{Name: "Partner", APIKeyID: &stronger.ID})
+	caller := &types.Principal{UserID: user.ID, Method: types.AuthMethodAPIKey, Scopes: []string{services.ScopeKeysWrite}}
+	req := httptest.NewRequest(http.MethodPost, "/client-certificates", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+
+	controller.RegisterClientCert(w, req.WithContext(utils.WithPrincipal(req.Context(), caller)))
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestServerTLSConfig_LoadsClientCAs(t *testing.T) {
+	ca := newTestCA(t)
+	_, certPEM, keyPEM := ca.issue(t, "localhost", x509.ExtKeyUsageServerAuth)
+
+	dir := t.TempDir()
+	write := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, data, 0o600))
+		return path
+	}
+	cfg := &config.AppConfig{
+		TLSCertFile:     write("server.pem", certPEM),
+		TLSKeyFile:      write("server-key.pem", keyPEM),
+		TLSClientCAFile: write("ca.pem", ca.pem),
+	}
+
+	require.True(t, cfg.TLSEnabled())
+	tlsConfig, err := cfg.ServerTLSConfig()
+
+	require.NoError(t, err)
+	assert.Len(t, tlsConfig.Certificates, 1)
+	assert.Equal(t, tls.VerifyClientCertIfGiven, tlsConfig.ClientAuth)
+	assert.NotNil(t, tlsConfig.ClientCAs)
+
+	cfg.TLSClientCAFile = write("empty.pem", []byte("not a certificate"))
+	_, err = cfg.ServerTLSConfig()
+	assert.Error(t, err)
+}
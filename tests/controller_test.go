@@ -6,7 +6,9 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/Brownei/api-generation-api/config"
 	"github.com/Brownei/api-generation-api/controllers"
@@ -24,7 +26,7 @@ import (
 func setupControllerTestDB(t *testing.T) *gorm.DB {
 	database, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
 	require.NoError(t, err)
-	err = database.AutoMigrate(&db.User{})
+	err = database.AutoMigrate(&db.User{}, &db.Session{})
 	require.NoError(t, err)
 	return database
 }
@@ -61,6 +63,12 @@ func TestAuthController_Login_Success(t *testing.T) {
 	authController.Login(w, req)
 
 	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response dto.TokenResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.NotEmpty(t, response.AccessToken)
+	assert.NotEmpty(t, response.RefreshToken)
+	assert.Equal(t, "Bearer", response.TokenType)
 }
 
 func TestAuthController_Login_UserNotFound(t *testing.T) {
@@ -166,7 +174,16 @@ func TestAuthController_Register_Success(t *testing.T) {
 
 	authController.Register(w, req)
 
-	assert.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, http.StatusOK, w.Code)
+	var response dto.TokenResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.NotEmpty(t, response.AccessToken)
+	assert.NotEmpty(t, response.RefreshToken)
+	assert.Equal(t, "Bearer", response.TokenType)
+
+	var sessions int64
+	database.Model(&db.Session{}).Count(&sessions)
+	assert.Equal(t, int64(1), sessions)
 }
 
 func TestAuthController_Register_UserAlreadyExists(t *testing.T) {
@@ -222,8 +239,8 @@ func TestAPIKeyController_CreateAPIKey_Success(t *testing.T) {
 	}
 	database.Create(user)
 
-	apiKeyService := services.NewAPIKeyService(database)
-	apiKeyController := controllers.NewAPIKeyController(apiKeyService, sugar)
+	apiKeyService := services.NewAPIKeyService(database, config.LoadAppConfig())
+	apiKeyController := controllers.NewAPIKeyController(apiKeyService, services.NewUsageService(database), authService, sugar)
 
 	body := dto.CreateAPIKeyRequest{
 		Name: "Test Key",
@@ -239,3 +256,29 @@ func TestAPIKeyController_CreateAPIKey_Success(t *testing.T) {
 
 	assert.Equal(t, http.StatusCreated, w.Code)
 }
+
+func TestAPIKeyController_RotateAPIKey_GracePeriod(t *testing.T) {
+	database := setupTestDB(t)
+	user := createTestUser(t, database)
+	logger, _ := zap.NewDevelopment()
+	apiKeyService := services.NewAPIKeyService(database, config.LoadAppConfig())
+	apiKeyController := controllers.NewAPIKeyController(apiKeyService, services.NewUsageService(database), services.NewAuthService(database, config.LoadAppConfig()), logger.Sugar())
+
+	apiKey, err := apiKeyService.GenerateAPIKey(user.ID, "Test Key", nil)
+	require.NoError(t, err)
+
+	jsonBody, _ := json.Marshal(dto.RotateAPIKeyRequest{GracePeriod: 3600})
+	req := httptest.NewRequest(http.MethodPost, "/api-key/1/rotate", bytes.NewBuffer(jsonBody))
+	req.SetPathValue("id", strconv.FormatUint(uint64(apiKey.ID), 10))
+	req = req.WithContext(context.WithValue(req.Context(), utils.UserIDKey, user.ID))
+	w := httptest.NewRecorder()
+
+	apiKeyController.RotateAPIKey(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var response dto.RotateAPIKeyResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.NotEmpty(t, response.Key)
+	assert.Equal(t, apiKey.ID, response.RotatedFromID)
+	assert.True(t, response.PreviousKeyExpiresAt.After(time.Now().Add(59*time.Minute)))
+}
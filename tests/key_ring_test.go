@@ -0,0 +1,141 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Brownei/api-generation-api/config"
+	"github.com/Brownei/api-generation-api/controllers"
+	"github.com/Brownei/api-generation-api/db"
+	"github.com/Brownei/api-generation-api/dto"
+	"github.com/Brownei/api-generation-api/services"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupKeyRingTest(t *testing.T, algorithm string) (*gorm.DB, *services.AuthService, *config.AppConfig) {
+	database, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, database.AutoMigrate(&db.User{}, &db.SigningKey{}))
+
+	cfg := config.LoadAppConfig()
+	cfg.JWTSigningAlgorithm = algorithm
+	authService := services.NewAuthService(database, cfg)
+	require.NoError(t, authService.KeyRing().Load())
+	return database, authService, cfg
+}
+
+func TestKeyRing_SignsAndVerifies(t *testing.T) {
+	for _, algorithm := range []string{services.SigningAlgorithmRS256, services.SigningAlgorithmES256, services.SigningAlgorithmEdDSA} {
+		t.Run(algorithm, func(t *testing.T) {
+			_, authService, _ := setupKeyRingTest(t, algorithm)
+
+			token, err := authService.GenerateToken(1, "test@example.com")
+			require.NoError(t, err)
+
+			parsed, _, err := jwt.NewParser().ParseUnverified(token, &services.Claims{})
+			require.NoError(t, err)
+			assert.Equal(t, algorithm, parsed.Method.Alg())
+			assert.NotEmpty(t, parsed.Header["kid"])
+
+			claims, err := authService.ValidateToken(token)
+			require.NoError(t, err)
+			assert.Equal(t, uint(1), claims.UserID)
+		})
+	}
+}
+
+func TestKeyRing_RotationKeepsOldTokensValid(t *testing.T) {
+	database, authService, _ := setupKeyRingTest(t, services.SigningAlgorithmES256)
+	oldToken, err := authService.GenerateToken(1, "test@example.com")
+	require.NoError(t, err)
+
+	require.NoError(t, authService.KeyRing().Rotate())
+	newToken, err := authService.GenerateToken(1, "test@example.com")
+	require.NoError(t, err)
+
+	_, err = authService.ValidateToken(oldToken)
+	assert.NoError(t, err)
+	_, err = authService.ValidateToken(newToken)
+	assert.NoError(t, err)
+
+	var active int64
+	database.Model(&db.SigningKey{}).Where("retired_at IS NULL").Count(&active)
+	assert.Equal(t, int64(1), active)
+	assert.Len(t, authService.KeyRing().JWKS(), 2)
+}
+
+func TestKeyRing_RetiredKeyOutlivesAccessTokenTTLForClientTokens(t *testing.T) {
+	database, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, database.AutoMigrate(&db.User{}, &db.SigningKey{}))
+	cfg := config.LoadAppConfig()
+	cfg.JWTSigningAlgorithm = services.SigningAlgorithmES256
+	cfg.AccessTokenTTL = time.Minute
+	cfg.OAuthTokenTTL = time.Hour
+	authService := services.NewAuthService(database, cfg)
+	require.NoError(t, authService.KeyRing().Load())
+	require.NoError(t, database.Create(&db.User{Email: "test@example.com", Password: "hashed"}).Error)
+
+	oldToken, _, err := authService.GenerateClientToken(&db.APIKey{ID: 1, UserID: 1, Prefix: "ak_test"}, nil)
+	require.NoError(t, err)
+	require.NoError(t, authService.KeyRing().Rotate())
+	// Retired past the access token lifetime, within the OAuth one.
+	require.NoError(t, database.Model(&db.SigningKey{}).Where("retired_at IS NOT NULL").
+		Update("retired_at", time.Now().Add(-10*time.Minute)).Error)
+	require.NoError(t, authService.KeyRing().Rotate())
+
+	other := services.NewAuthService(database, cfg)
+	require.NoError(t, other.KeyRing().Load())
+
+	_, err = other.ValidateToken(oldToken)
+	assert.NoError(t, err)
+}
+
+func TestKeyRing_SharedAcrossInstances(t *testing.T) {
+	database, authService, cfg := setupKeyRingTest(t, services.SigningAlgorithmRS256)
+	token, err := authService.GenerateToken(1, "test@example.com")
+	require.NoError(t, err)
+
+	other := services.NewAuthService(database, cfg)
+	require.NoError(t, other.KeyRing().Load())
+
+	_, err = other.ValidateToken(token)
+	assert.NoError(t, err)
+}
+
+func TestKeyRing_RejectsHS256Tokens(t *testing.T) {
+	_, authService, cfg := setupKeyRingTest(t, services.SigningAlgorithmEdDSA)
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, services.Claims{UserID: 1})
+	signed, err := token.SignedString([]byte(cfg.JWTSecret))
+	require.NoError(t, err)
+
+	_, err = authService.ValidateToken(signed)
+	assert.Error(t, err)
+}
+
+func TestAuthController_JWKS(t *testing.T) {
+	_, authService, _ := setupKeyRingTest(t, services.SigningAlgorithmRS256)
+	logger, _ := zap.NewDevelopment()
+	authController := controllers.NewAuthController(nil, authService, logger.Sugar())
+
+	req := httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil)
+	w := httptest.NewRecorder()
+	authController.JWKS(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var response dto.JWKSResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	require.Len(t, response.Keys, 1)
+	assert.Equal(t, "RSA", response.Keys[0].Kty)
+	assert.Equal(t, "RS256", response.Keys[0].Alg)
+	assert.NotEmpty(t, response.Keys[0].N)
+	assert.Equal(t, "AQAB", response.Keys[0].E)
+}
@@ -7,8 +7,10 @@ import (
 	"time"
 
 	"github.com/Brownei/api-generation-api/config"
+	"github.com/Brownei/api-generation-api/db"
 	"github.com/Brownei/api-generation-api/middleware"
 	"github.com/Brownei/api-generation-api/services"
+	"github.com/Brownei/api-generation-api/utils"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/stretchr/testify/assert"
 	"gorm.io/driver/sqlite"
@@ -16,12 +18,18 @@ import (
 )
 
 func setupAuthService(t *testing.T) (*services.AuthService, *config.AppConfig) {
-	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	database, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
 	if err != nil {
 		t.Fatal(err)
 	}
+	if err := database.AutoMigrate(&db.User{}, &db.Session{}, &db.RevokedToken{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.Create(&db.User{ID: 1, Name: "Test User", Email: "test@example.com", Password: "hashed"}).Error; err != nil {
+		t.Fatal(err)
+	}
 	cfg := config.LoadAppConfig()
-	return services.NewAuthService(db, cfg), cfg
+	return services.NewAuthService(database, cfg), cfg
 }
 
 func generateTestToken(t *testing.T, authService *services.AuthService, cfg *config.AppConfig, userID uint, email string, expired bool) string {
@@ -51,7 +59,7 @@ func generateTestToken(t *testing.T, authService *services.AuthService, cfg *con
 
 func TestAuthMiddleware_NoAuthHeader(t *testing.T) {
 	authService, _ := setupAuthService(t)
-	mw := middleware.NewAuthMiddleware(authService)
+	mw := middleware.Authenticate(middleware.NewJWTAuthenticator(authService, nil, nil))
 
 	req := httptest.NewRequest(http.MethodGet, "/test", nil)
 	w := httptest.NewRecorder()
@@ -61,7 +69,7 @@ func TestAuthMiddleware_NoAuthHeader(t *testing.T) {
 		nextCalled = true
 	})
 
-	mw.Authenticate(next).ServeHTTP(w, req)
+	mw(next).ServeHTTP(w, req)
 
 	assert.False(t, nextCalled)
 	assert.Equal(t, http.StatusUnauthorized, w.Code)
@@ -69,7 +77,7 @@ func TestAuthMiddleware_NoAuthHeader(t *testing.T) {
 
 func TestAuthMiddleware_InvalidFormat(t *testing.T) {
 	authService, _ := setupAuthService(t)
-	mw := middleware.NewAuthMiddleware(authService)
+	mw := middleware.Authenticate(middleware.NewJWTAuthenticator(authService, nil, nil))
 
 	req := httptest.NewRequest(http.MethodGet, "/test", nil)
 	req.Header.Set("Authorization", "InvalidFormat")
@@ -80,7 +88,7 @@ func TestAuthMiddleware_InvalidFormat(t *testing.T) {
 		nextCalled = true
 	})
 
-	mw.Authenticate(next).ServeHTTP(w, req)
+	mw(next).ServeHTTP(w, req)
 
 	assert.False(t, nextCalled)
 	assert.Equal(t, http.StatusUnauthorized, w.Code)
@@ -88,7 +96,7 @@ func TestAuthMiddleware_InvalidFormat(t *testing.T) {
 
 func TestAuthMiddleware_ValidToken(t *testing.T) {
 	authService, cfg := setupAuthService(t)
-	mw := middleware.NewAuthMiddleware(authService)
+	mw := middleware.Authenticate(middleware.NewJWTAuthenticator(authService, nil, nil))
 
 	token := generateTestToken(t, authService, cfg, 1, "test@example.com", false)
 	req := httptest.NewRequest(http.MethodGet, "/test", nil)
@@ -99,10 +107,10 @@ func TestAuthMiddleware_ValidToken(t *testing.T) {
 	var ctxUserID uint
 	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		nextCalled = true
-		ctxUserID = r.Context().Value("user_id").(uint)
+		ctxUserID = r.Context().Value(utils.UserIDKey).(uint)
 	})
 
-	mw.Authenticate(next).ServeHTTP(w, req)
+	mw(next).ServeHTTP(w, req)
 
 	assert.True(t, nextCalled)
 	assert.Equal(t, uint(1), ctxUserID)
@@ -110,7 +118,7 @@ func TestAuthMiddleware_ValidToken(t *testing.T) {
 
 func TestAuthMiddleware_ExpiredToken(t *testing.T) {
 	authService, cfg := setupAuthService(t)
-	mw := middleware.NewAuthMiddleware(authService)
+	mw := middleware.Authenticate(middleware.NewJWTAuthenticator(authService, nil, nil))
 
 	token := generateTestToken(t, authService, cfg, 1, "test@example.com", true)
 	req := httptest.NewRequest(http.MethodGet, "/test", nil)
@@ -122,15 +130,16 @@ func TestAuthMiddleware_ExpiredToken(t *testing.T) {
 		nextCalled = true
 	})
 
-	mw.Authenticate(next).ServeHTTP(w, req)
+	mw(next).ServeHTTP(w, req)
 
 	assert.False(t, nextCalled)
 	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "token expired")
 }
 
 func TestAuthMiddleware_InvalidToken(t *testing.T) {
 	authService, _ := setupAuthService(t)
-	mw := middleware.NewAuthMiddleware(authService)
+	mw := middleware.Authenticate(middleware.NewJWTAuthenticator(authService, nil, nil))
 
 	req := httptest.NewRequest(http.MethodGet, "/test", nil)
 	req.Header.Set("Authorization", "Bearer invalid.token.here")
@@ -141,7 +150,7 @@ func TestAuthMiddleware_InvalidToken(t *testing.T) {
 		nextCalled = true
 	})
 
-	mw.Authenticate(next).ServeHTTP(w, req)
+	mw(next).ServeHTTP(w, req)
 
 	assert.False(t, nextCalled)
 	assert.Equal(t, http.StatusUnauthorized, w.Code)
@@ -0,0 +1,283 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Brownei/api-generation-api/config"
+	"github.com/Brownei/api-generation-api/controllers"
+	"github.com/Brownei/api-generation-api/db"
+	"github.com/Brownei/api-generation-api/dto"
+	"github.com/Brownei/api-generation-api/middleware"
+	"github.com/Brownei/api-generation-api/services"
+	"github.com/Brownei/api-generation-api/types"
+	"github.com/Brownei/api-generation-api/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type oauthTest struct {
+	database      *gorm.DB
+	user          *db.User
+	authService   *services.AuthService
+	apiKeyService *services.APIKeyService
+	controller    *controllers.OAuthController
+}
+
+func setupOAuthTest(t *testing.T) *oauthTest {
+	database := setupTestDB(t)
+	require.NoError(t, database.AutoMigrate(&db.RevokedToken{}))
+	cfg := config.LoadAppConfig()
+	authService := services.NewAuthService(database, cfg)
+	apiKeyService := services.NewAPIKeyService(database, cfg)
+	return &oauthTest{
+		database:      database,
+		user:          createTestUser(t, database),
+		authService:   authService,
+		apiKeyService: apiKeyService,
+		controller:    controllers.NewOAuthController(authService, apiKeyService, services.NewIntrospectionService(authService, apiKeyService), zap.NewNop().Sugar()),
+	}
+}
+
+func (o *oauthTest) requestToken(form url.Values, basicID, basicSecret string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/v1/oauth/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if basicID != "" {
+		req.SetBasicAuth(url.QueryEscape(basicID), url.QueryEscape(basicSecret))
+	}
+	w := httptest.NewRecorder()
+	o.controller.Token(w, req)
+	return w
+}
+
+func (o *oauthTest) authenticate(token string) (int, *types.Principal) {
+	var principal *types.Principal
+	mw := middleware.Authenticate(middleware.NewJWTAuthenticator(o.authService, o.apiKeyService, nil))
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, _ = utils.PrincipalFromContext(r.Context())
+	})).ServeHTTP(w, req)
+	return w.Code, principal
+}
+
+func TestOAuthToken_ClientCredentials(t *testing.T) {
+	o := setupOAuthTest(t)
+	apiKey, err := o.apiKeyService.CreateAPIKey(o.user.ID, services.APIKeyOptions{
+		Name:   "Service",
+		Scopes: []string{services.ScopeKeysRead, services.ScopeUsersRead},
+	})
+	require.NoError(t, err)
+	_, secret, _ := strings.Cut(apiKey.Key, "_")
+
+	w := o.requestToken(url.Values{"grant_type": {"client_credentials"}}, apiKey.Prefix, secret)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+	var response dto.ClientTokenResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "Bearer", response.TokenType)
+	assert.Equal(t, 300, response.ExpiresIn)
+	assert.Equal(t, "keys:read users:read", response.Scope)
+
+	claims, err := o.authService.ValidateToken(response.AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, apiKey.ID, claims.APIKeyID)
+	assert.Equal(t, apiKey.Prefix, claims.ClientID)
+	assert.Equal(t, o.user.ID, claims.UserID)
+
+	status, principal := o.authenticate(response.AccessToken)
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, types.AuthMethodJWT, principal.Method)
+	assert.Equal(t, apiKey.ID, principal.APIKeyID)
+	assert.Equal(t, claims.ID, principal.TokenID)
+	assert.True(t, principal.HasScope(services.ScopeUsersRead))
+	assert.False(t, principal.HasScope(services.ScopeKeysWrite))
+}
+
+func TestOAuthToken_FormCredentialsAndNarrowedScope(t *testing.T) {
+	o := setupOAuthTest(t)
+	apiKey, err := o.apiKeyService.CreateAPIKey(o.user.ID, services.APIKeyOptions{Name: "Service"})
+	require.NoError(t, err)
+
+	// The whole key is accepted as the secret too.
+	w := o.requestToken(url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {apiKey.Prefix},
+		"client_secret": {apiKey.Key},
+		"scope":         {services.ScopeUsersRead},
+	}, "", "")
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var response dto.ClientTokenResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, services.ScopeUsersRead, response.Scope)
+
+	_, principal := o.authenticate(response.AccessToken)
+	require.NotNil(t, principal)
+	assert.Equal(t, []string{services.ScopeUsersRead}, principal.Scopes)
+
+	w = o.requestToken(url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {apiKey.Prefix},
+		"client_secret": {apiKey.Key},
+		"scope":         {services.ScopeAuditRead},
+	}, "", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid_scope")
+}
+
+func TestOAuthToken_Errors(t *testing.T) {
+	o := setupOAuthTest(t)
+	apiKey, err := o.apiKeyService.CreateAPIKey(o.user.ID, services.APIKeyOptions{Name: "Service"})
+	require.NoError(t, err)
+	other, err := o.apiKeyService.CreateAPIKey(o.user.ID, services.APIKeyOptions{Name: "Other"})
+	require.NoError(t, err)
+	signer, err := o.apiKeyService.CreateAPIKey(o.user.ID, services.APIKeyOptions{Name: "Signer", Signing: true})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		form   url.Values
+		id     string
+		secret string
+		status int
+		code   string
+	}{
+		{"missing grant", url.Values{}, apiKey.Prefix, apiKey.Key, http.StatusBadRequest, "invalid_request"},
+		{"other grant", url.Values{"grant_type": {"password"}}, apiKey.Prefix, apiKey.Key, http.StatusBadRequest, "unsupported_grant_type"},
+		{"no credentials", url.Values{"grant_type": {"client_credentials"}}, "", "", http.StatusUnauthorized, "invalid_client"},
+		{"wrong secret", url.Values{"grant_type": {"client_credentials"}}, apiKey.Prefix, "nope", http.StatusUnauthorized, "invalid_client"},
+		{"another key's secret", url.Values{"grant_type": {"client_credentials"}}, apiKey.Prefix, other.Key, http.StatusUnauthorized, "invalid_client"},
+		{"signing key", url.Values{"grant_type": {"client_credentials"}}, signer.Prefix, signer.Key, http.StatusUnauthorized, "invalid_client"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := o.requestToken(tt.form, tt.id, tt.secret)
+			assert.Equal(t, tt.status, w.Code)
+			var response dto.OAuthErrorResponse
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			assert.Equal(t, tt.code, response.Error)
+		})
+	}
+}
+
+func TestOAuthToken_StopsWorkingWithItsKey(t *testing.T) {
+	o := setupOAuthTest(t)
+	apiKey, err := o.apiKeyService.CreateAPIKey(o.user.ID, services.APIKeyOptions{Name: "Service"})
+	require.NoError(t, err)
+
+	w := o.requestToken(url.Values{"grant_type": {"client_credentials"}}, apiKey.Prefix, apiKey.Key)
+	require.Equal(t, http.StatusOK, w.Code)
+	var response dto.ClientTokenResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+
+	require.NoError(t, o.apiKeyService.SuspendAPIKey(o.user.ID, apiKey.ID, ""))
+	status, _ := o.authenticate(response.AccessToken)
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	// Authenticators built without an API key service refuse key tokens.
+	mw := middleware.Authenticate(middleware.NewJWTAuthenticator(o.authService, nil, nil))
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.Header.Set("Authorization", "Bearer "+response.AccessToken)
+	rec := httptest.NewRecorder()
+	mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func (o *oauthTest) introspect(t *testing.T, asUser uint, form url.Values) (int, dto.IntrospectionResponse) {
+	req := httptest.NewRequest(http.MethodPost, "/v1/oauth/introspect", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req = req.WithContext(context.WithValue(req.Context(), utils.UserIDKey, asUser))
+	w := httptest.NewRecorder()
+	o.controller.Introspect(w, req)
+
+	var response dto.IntrospectionResponse
+	if w.Code == http.StatusOK {
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	}
+	return w.Code, response
+}
+
+func TestIntrospect_TokensAndKeys(t *testing.T) {
+	o := setupOAuthTest(t)
+	admin := &db.User{Name: "Admin", Email: "admin@example.com", Password: "password123", IsAdmin: true}
+	require.NoError(t, o.database.Create(admin).Error)
+
+	apiKey, err := o.apiKeyService.CreateAPIKey(o.user.ID, services.APIKeyOptions{Name: "Service", Scopes: []string{services.ScopeUsersRead}})
+	require.NoError(t, err)
+	userToken, err := o.authService.GenerateToken(o.user.ID, o.user.Email)
+	require.NoError(t, err)
+	w := o.requestToken(url.Values{"grant_type": {"client_credentials"}}, apiKey.Prefix, apiKey.Key)
+	var issued dto.ClientTokenResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &issued))
+
+	status, _ := o.introspect(t, o.user.ID, url.Values{"token": {apiKey.Key}})
+	assert.Equal(t, http.StatusForbidden, status)
+
+	status, result := o.introspect(t, admin.ID, url.Values{"token": {apiKey.Key}, "token_type_hint": {"api_key"}})
+	require.Equal(t, http.StatusOK, status)
+	assert.True(t, result.Active)
+	assert.Equal(t, "ApiKey", result.TokenType)
+	assert.Equal(t, strconv.FormatUint(uint64(o.user.ID), 10), result.Subject)
+	assert.Equal(t, services.ScopeUsersRead, result.Scope)
+	assert.Equal(t, apiKey.Prefix, result.ClientID)
+	assert.Equal(t, db.APIKeyStatusActive, result.KeyStatus)
+	assert.NotZero(t, result.IssuedAt)
+
+	// A user session, found without a hint.
+	_, result = o.introspect(t, admin.ID, url.Values{"token": {userToken}})
+	assert.True(t, result.Active)
+	assert.Equal(t, "Bearer", result.TokenType)
+	assert.NotZero(t, result.ExpiresAt)
+	assert.Empty(t, result.KeyStatus)
+
+	// A key's token, found despite the wrong hint.
+	_, result = o.introspect(t, admin.ID, url.Values{"token": {issued.AccessToken}, "token_type_hint": {"api_key"}})
+	assert.True(t, result.Active)
+	assert.Equal(t, apiKey.Prefix, result.ClientID)
+	assert.Equal(t, apiKey.ID, result.APIKeyID)
+	assert.Equal(t, services.ScopeUsersRead, result.Scope)
+
+	_, result = o.introspect(t, admin.ID, url.Values{"token": {"not-a-credential"}})
+	assert.Equal(t, dto.IntrospectionResponse{}, result)
+
+	status, _ = o.introspect(t, admin.ID, url.Values{})
+	assert.Equal(t, http.StatusBadRequest, status)
+}
+
+func TestIntrospect_InactiveKeys(t *testing.T) {
+	o := setupOAuthTest(t)
+	admin := &db.User{Name: "Admin", Email: "admin@example.com", Password: "password123", IsAdmin: true}
+	require.NoError(t, o.database.Create(admin).Error)
+
+	apiKey, err := o.apiKeyService.CreateAPIKey(o.user.ID, services.APIKeyOptions{Name: "Service"})
+	require.NoError(t, err)
+	w := o.requestToken(url.Values{"grant_type": {"client_credentials"}}, apiKey.Prefix, apiKey.Key)
+	var issued dto.ClientTokenResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &issued))
+	require.NoError(t, o.apiKeyService.SuspendAPIKey(admin.ID, apiKey.ID, ""))
+
+	for _, token := range []string{apiKey.Key, issued.AccessToken} {
+		_, result := o.introspect(t, admin.ID, url.Values{"token": {token}})
+		assert.Equal(t, dto.IntrospectionResponse{KeyStatus: db.APIKeyStatusSuspended}, result)
+	}
+
+	// Allowlisted keys are only active from the address the gateway saw.
+	restricted, err := o.apiKeyService.CreateAPIKey(o.user.ID, services.APIKeyOptions{Name: "Office", AllowedIPs: []string{"10.0.0.0/8"}})
+	require.NoError(t, err)
+	_, result := o.introspect(t, admin.ID, url.Values{"token": {restricted.Key}})
+	assert.False(t, result.Active)
+	_, result = o.introspect(t, admin.ID, url.Values{"token": {restricted.Key}, "client_ip": {"10.1.2.3"}})
+	assert.True(t, result.Active)
+}
@@ -0,0 +1,112 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Brownei/api-generation-api/config"
+	"github.com/Brownei/api-generation-api/middleware"
+	"github.com/Brownei/api-generation-api/services"
+	"github.com/Brownei/api-generation-api/types"
+	"github.com/Brownei/api-generation-api/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInMemoryRateLimitStore_TokenBucket(t *testing.T) {
+	store := services.NewInMemoryRateLimitStore()
+	policy := services.RateLimitPolicy{Limit: 2, Window: time.Minute}
+	now := time.Now()
+
+	first, err := store.Take("client", policy, now)
+	require.NoError(t, err)
+	assert.True(t, first.Allowed)
+	assert.Equal(t, 1, first.Remaining)
+
+	second, _ := store.Take("client", policy, now)
+	assert.True(t, second.Allowed)
+	assert.Equal(t, 0, second.Remaining)
+
+	third, _ := store.Take("client", policy, now)
+	assert.False(t, third.Allowed)
+	assert.Equal(t, 30*time.Second, third.RetryAfter)
+
+	refilled, _ := store.Take("client", policy, now.Add(30*time.Second))
+	assert.True(t, refilled.Allowed)
+
+	other, _ := store.Take("other-client", policy, now)
+	assert.True(t, other.Allowed)
+}
+
+func TestRateLimiter_PolicyFor(t *testing.T) {
+	cfg := config.LoadAppConfig()
+	cfg.RateLimitRequests = 60
+	cfg.RateLimitWindow = time.Minute
+	limiter := services.NewRateLimiter(services.NewInMemoryRateLimitStore(), cfg)
+
+	assert.Equal(t, services.RateLimitPolicy{Limit: 60, Window: time.Minute}, limiter.PolicyFor(nil))
+	assert.Equal(t,
+		services.RateLimitPolicy{Limit: 10, Window: time.Hour},
+		limiter.PolicyFor(&types.Principal{RateLimit: 10, RateLimitWindow: time.Hour}),
+	)
+}
+
+func TestRateLimitMiddleware_RejectsOverLimit(t *testing.T) {
+	cfg := config.LoadAppConfig()
+	cfg.RateLimitRequests = 100
+	limiter := services.NewRateLimiter(services.NewInMemoryRateLimitStore(), cfg)
+
+	handler := middleware.RateLimit(limiter)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	principal := &types.Principal{UserID: 1, APIKeyID: 7, RateLimit: 1, RateLimitWindow: time.Minute}
+	newRequest := func() *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		return req.WithContext(utils.WithPrincipal(req.Context(), principal))
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, newRequest())
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "1", w.Header().Get("X-RateLimit-Limit"))
+	assert.Equal(t, "0", w.Header().Get("X-RateLimit-Remaining"))
+	assert.NotEmpty(t, w.Header().Get("X-RateLimit-Reset"))
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, newRequest())
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+
+	// Requests without the key fall back to the client IP and have their own budget.
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/test", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "100", w.Header().Get("X-RateLimit-Limit"))
+}
+
+func TestRateLimitAuthFailures_ChargesClientIP(t *testing.T) {
+	cfg := config.LoadAppConfig()
+	cfg.RateLimitRequests = 2
+	cfg.RateLimitWindow = time.Minute
+	limiter := services.NewRateLimiter(services.NewInMemoryRateLimitStore(), cfg)
+
+	reached := 0
+	handler := middleware.RateLimitAuthFailures(limiter)(
+		middleware.Authenticate(&stubAuthenticator{err: services.ErrInvalidAPIKey})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			reached++
+		})),
+	)
+
+	statuses := []int{}
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/test", nil))
+		statuses = append(statuses, w.Code)
+	}
+
+	assert.Equal(t, []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests}, statuses)
+	assert.Zero(t, reached)
+}
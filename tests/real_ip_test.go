@@ -0,0 +1,55 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Brownei/api-generation-api/middleware"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func resolveIP(t *testing.T, proxies []string, remoteAddr string, forwardedFor ...string) string {
+	trusted, err := middleware.ParseTrustedProxies(proxies)
+	require.NoError(t, err)
+
+	var got string
+	handler := middleware.RealIP(trusted)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.RemoteAddr
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = remoteAddr
+	for _, value := range forwardedFor {
+		req.Header.Add("X-Forwarded-For", value)
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	return got
+}
+
+func TestRealIP_IgnoresHeadersFromUntrustedPeers(t *testing.T) {
+	assert.Equal(t, "203.0.113.7", resolveIP(t, nil, "203.0.113.7:4321", "198.51.100.1"))
+	assert.Equal(t, "203.0.113.7", resolveIP(t, []string{"10.0.0.0/8"}, "203.0.113.7:4321", "198.51.100.1"))
+}
+
+func TestRealIP_WalksTrustedProxyChain(t *testing.T) {
+	proxies := []string{"10.0.0.0/8", "192.0.2.10"}
+
+	// The client prepended a spoofed hop; only the hops our proxies added count.
+	assert.Equal(t, "198.51.100.1", resolveIP(t, proxies, "10.1.2.3:80", "1.1.1.1, 198.51.100.1, 192.0.2.10"))
+	assert.Equal(t, "198.51.100.1", resolveIP(t, proxies, "10.1.2.3:80", "1.1.1.1", "198.51.100.1"))
+
+	// Every hop is trusted, so the furthest one is the best we know.
+	assert.Equal(t, "10.9.9.9", resolveIP(t, proxies, "10.1.2.3:80", "10.9.9.9"))
+
+	// Garbage stops the walk at the last trustworthy address.
+	assert.Equal(t, "10.1.2.3", resolveIP(t, proxies, "10.1.2.3:80", "not-an-ip"))
+}
+
+func TestParseTrustedProxies_RejectsInvalid(t *testing.T) {
+	_, err := middleware.ParseTrustedProxies([]string{"10.0.0.0/33"})
+	assert.Error(t, err)
+	_, err = middleware.ParseTrustedProxies([]string{"proxy.internal"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,149 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/Brownei/api-generation-api/config"
+	"github.com/Brownei/api-generation-api/controllers"
+	"github.com/Brownei/api-generation-api/db"
+	"github.com/Brownei/api-generation-api/dto"
+	"github.com/Brownei/api-generation-api/middleware"
+	"github.com/Brownei/api-generation-api/services"
+	"github.com/Brownei/api-generation-api/types"
+	"github.com/Brownei/api-generation-api/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestCreateAPIKey_WithScopes(t *testing.T) {
+	database := setupTestDB(t)
+	user := createTestUser(t, database)
+	service := services.NewAPIKeyService(database, config.LoadAppConfig())
+
+	apiKey, err := service.CreateAPIKey(user.ID, services.APIKeyOptions{
+		Name:   "Scoped Key",
+		Scopes: []string{services.ScopeAuditRead, services.ScopeKeysRead, services.ScopeKeysRead},
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{services.ScopeAuditRead, services.ScopeKeysRead}, apiKey.ScopeList())
+}
+
+func TestCreateAPIKey_DefaultScopes(t *testing.T) {
+	database := setupTestDB(t)
+	user := createTestUser(t, database)
+	service := services.NewAPIKeyService(database, config.LoadAppConfig())
+
+	apiKey, err := service.GenerateAPIKey(user.ID, "Default Key", nil)
+
+	require.NoError(t, err)
+	assert.True(t, apiKey.HasScope(services.ScopeKeysRead))
+	assert.True(t, apiKey.HasScope(services.ScopeUsersRead))
+	assert.False(t, apiKey.HasScope(services.ScopeKeysWrite))
+}
+
+func TestCreateAPIKey_InvalidScope(t *testing.T) {
+	database := setupTestDB(t)
+	user := createTestUser(t, database)
+	service := services.NewAPIKeyService(database, config.LoadAppConfig())
+
+	_, err := service.CreateAPIKey(user.ID, services.APIKeyOptions{
+		Name:   "Bad Key",
+		Scopes: []string{"everything:all"},
+	})
+
+	assert.ErrorIs(t, err, services.ErrInvalidScope)
+}
+
+func TestRotateAPIKey_KeepsScopes(t *testing.T) {
+	database := setupTestDB(t)
+	user := createTestUser(t, database)
+	service := services.NewAPIKeyService(database, config.LoadAppConfig())
+
+	apiKey, err := service.CreateAPIKey(user.ID, services.APIKeyOptions{
+		Name:   "Scoped Key",
+		Scopes: []string{services.ScopeAuditRead},
+	})
+	require.NoError(t, err)
+
+	rotated, err := service.RotateAPIKey(user.ID, apiKey.ID, 0)
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{services.ScopeAuditRead}, rotated.ScopeList())
+}
+
+func TestRequireScope(t *testing.T) {
+	handler := middleware.RequireScope(services.ScopeKeysWrite)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	serve := func(principal *types.Principal) int {
+		req := httptest.NewRequest(http.MethodPost, "/api-key", nil)
+		if principal != nil {
+			req = req.WithContext(utils.WithPrincipal(req.Context(), principal))
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// User sessions carry no scopes and are unrestricted.
+	assert.Equal(t, http.StatusOK, serve(&types.Principal{UserID: 1, Method: types.AuthMethodJWT}))
+	assert.Equal(t, http.StatusForbidden, serve(&types.Principal{UserID: 1, Method: types.AuthMethodAPIKey, Scopes: []string{services.ScopeKeysRead}}))
+	assert.Equal(t, http.StatusOK, serve(&types.Principal{UserID: 1, Method: types.AuthMethodAPIKey, Scopes: []string{services.ScopeKeysWrite}}))
+	assert.Equal(t, http.StatusUnauthorized, serve(nil))
+}
+
+func TestAPIKeyController_CreateAPIKey_ScopeEscalation(t *testing.T) {
+	database := setupTestDB(t)
+	user := createTestUser(t, database)
+	logger, _ := zap.NewDevelopment()
+	apiKeyController := controllers.NewAPIKeyController(services.NewAPIKeyService(database, config.LoadAppConfig()), services.NewUsageService(database), services.NewAuthService(database, config.LoadAppConfig()), logger.Sugar())
+
+	body, _ := json.Marshal(dto.CreateAPIKeyRequest{
+		Name:   "Escalated Key",
+		Scopes: []string{services.ScopeAuditRead},
+	})
+
+	caller := &types.Principal{UserID: user.ID, Method: types.AuthMethodAPIKey, Scopes: []string{services.ScopeKeysWrite}}
+	req := httptest.NewRequest(http.MethodPost, "/api-key", bytes.NewBuffer(body))
+	ctx := utils.WithPrincipal(req.Context(), caller)
+	w := httptest.NewRecorder()
+
+	apiKeyController.CreateAPIKey(w, req.WithContext(ctx))
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestAPIKeyController_RotateAPIKey_ScopeEscalation(t *testing.T) {
+	database := setupTestDB(t)
+	user := createTestUser(t, database)
+	apiKeyService := services.NewAPIKeyService(database, config.LoadAppConfig())
+	logger, _ := zap.NewDevelopment()
+	apiKeyController := controllers.NewAPIKeyController(apiKeyService, services.NewUsageService(database), services.NewAuthService(database, config.LoadAppConfig()), logger.Sugar())
+
+	stronger, err := apiKeyService.CreateAPIKey(user.ID, services.APIKeyOptions{
+		Name:   "Stronger Key",
+		Scopes: []string{services.ScopeKeysWrite, services.ScopeAuditRead},
+	})
+	require.NoError(t, err)
+
+	caller := &types.Principal{UserID: user.ID, Method: types.AuthMethodAPIKey, Scopes: []string{services.ScopeKeysWrite}}
+	req := httptest.NewRequest(http.MethodPost, "/api-key/rotate", nil)
+	req.SetPathValue("id", strconv.FormatUint(uint64(stronger.ID), 10))
+	ctx := utils.WithPrincipal(req.Context(), caller)
+	w := httptest.NewRecorder()
+
+	apiKeyController.RotateAPIKey(w, req.WithContext(ctx))
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	var successors int64
+	database.Model(&db.APIKey{}).Where("user_id = ?", user.ID).Count(&successors)
+	assert.Equal(t, int64(1), successors)
+}
@@ -0,0 +1,121 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Brownei/api-generation-api/config"
+	"github.com/Brownei/api-generation-api/controllers"
+	"github.com/Brownei/api-generation-api/db"
+	"github.com/Brownei/api-generation-api/dto"
+	"github.com/Brownei/api-generation-api/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupSessionTest(t *testing.T) (*gorm.DB, *services.AuthService, *db.User) {
+	database, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, database.AutoMigrate(&db.User{}, &db.Session{}))
+
+	user := &db.User{Name: "Test User", Email: "test@example.com", Password: "password123"}
+	require.NoError(t, database.Create(user).Error)
+
+	return database, services.NewAuthService(database, config.LoadAppConfig()), user
+}
+
+func TestRefreshSession_RotatesToken(t *testing.T) {
+	_, authService, user := setupSessionTest(t)
+
+	session, refreshToken, err := authService.CreateSession(user.ID, "TestAgent", "10.0.0.1")
+	require.NoError(t, err)
+
+	refreshed, newRefreshToken, err := authService.RefreshSession(refreshToken)
+
+	require.NoError(t, err)
+	assert.Equal(t, session.ID, refreshed.ID)
+	assert.NotEqual(t, refreshToken, newRefreshToken)
+
+	_, _, err = authService.RefreshSession(newRefreshToken)
+	assert.NoError(t, err)
+}
+
+func TestRefreshSession_ReuseRevokesSession(t *testing.T) {
+	_, authService, user := setupSessionTest(t)
+
+	_, refreshToken, err := authService.CreateSession(user.ID, "TestAgent", "10.0.0.1")
+	require.NoError(t, err)
+	_, newRefreshToken, err := authService.RefreshSession(refreshToken)
+	require.NoError(t, err)
+
+	_, _, err = authService.RefreshSession(refreshToken)
+	assert.ErrorIs(t, err, services.ErrRefreshTokenReused)
+
+	// The legitimate holder is logged out too.
+	_, _, err = authService.RefreshSession(newRefreshToken)
+	assert.ErrorIs(t, err, services.ErrSessionRevoked)
+}
+
+func TestRefreshSession_InvalidToken(t *testing.T) {
+	_, authService, _ := setupSessionTest(t)
+
+	_, _, err := authService.RefreshSession("not-a-token")
+
+	assert.ErrorIs(t, err, services.ErrInvalidRefreshToken)
+}
+
+func TestSessions_ListAndRevoke(t *testing.T) {
+	database, authService, user := setupSessionTest(t)
+	other := &db.User{Name: "Other", Email: "other@example.com", Password: "password123"}
+	require.NoError(t, database.Create(other).Error)
+
+	first, _, err := authService.CreateSession(user.ID, "Laptop", "10.0.0.1")
+	require.NoError(t, err)
+	_, secondToken, err := authService.CreateSession(user.ID, "Phone", "10.0.0.2")
+	require.NoError(t, err)
+
+	sessions, err := authService.ListSessions(user.ID)
+	require.NoError(t, err)
+	assert.Len(t, sessions, 2)
+
+	assert.ErrorIs(t, authService.RevokeSession(other.ID, first.ID), services.ErrSessionNotFound)
+	require.NoError(t, authService.RevokeSession(user.ID, first.ID))
+	require.NoError(t, authService.RevokeSessionByToken(secondToken))
+
+	sessions, err = authService.ListSessions(user.ID)
+	require.NoError(t, err)
+	assert.Empty(t, sessions)
+}
+
+func TestAuthController_Refresh(t *testing.T) {
+	database, authService, user := setupSessionTest(t)
+	logger, _ := zap.NewDevelopment()
+	authController := controllers.NewAuthController(services.NewUserService(database, config.LoadAppConfig()), authService, logger.Sugar())
+
+	_, refreshToken, err := authService.CreateSession(user.ID, "TestAgent", "10.0.0.1")
+	require.NoError(t, err)
+
+	jsonBody, _ := json.Marshal(dto.RefreshTokenRequest{RefreshToken: refreshToken})
+	req := httptest.NewRequest(http.MethodPost, "/auth/refresh", bytes.NewBuffer(jsonBody))
+	w := httptest.NewRecorder()
+
+	authController.Refresh(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var response dto.TokenResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.NotEmpty(t, response.AccessToken)
+	assert.NotEqual(t, refreshToken, response.RefreshToken)
+
+	// Replaying the original token is rejected.
+	req = httptest.NewRequest(http.MethodPost, "/auth/refresh", bytes.NewBuffer(jsonBody))
+	w = httptest.NewRecorder()
+	authController.Refresh(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
@@ -0,0 +1,98 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Brownei/api-generation-api/config"
+	"github.com/Brownei/api-generation-api/db"
+	"github.com/Brownei/api-generation-api/middleware"
+	"github.com/Brownei/api-generation-api/services"
+	"github.com/Brownei/api-generation-api/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func setupRevocationTest(t *testing.T) (*gorm.DB, *services.AuthService, *db.User) {
+	database, authService, user := setupSessionTest(t)
+	require.NoError(t, database.AutoMigrate(&db.RevokedToken{}))
+	return database, authService, user
+}
+
+func issueToken(t *testing.T, authService *services.AuthService, user *db.User) *services.Claims {
+	token, err := authService.GenerateToken(user.ID, user.Email)
+	require.NoError(t, err)
+	claims, err := authService.ValidateToken(token)
+	require.NoError(t, err)
+	return claims
+}
+
+func TestCheckTokenRevocation_FreshTokenPasses(t *testing.T) {
+	_, authService, user := setupRevocationTest(t)
+
+	claims := issueToken(t, authService, user)
+
+	assert.NotEmpty(t, claims.ID)
+	assert.NoError(t, authService.CheckTokenRevocation(claims))
+}
+
+func TestRevokeToken_RejectsOnlyThatToken(t *testing.T) {
+	_, authService, user := setupRevocationTest(t)
+	revoked := issueToken(t, authService, user)
+	other := issueToken(t, authService, user)
+
+	require.NoError(t, authService.RevokeToken(revoked))
+
+	assert.ErrorIs(t, authService.CheckTokenRevocation(revoked), types.ErrTokenRevoked)
+	assert.NoError(t, authService.CheckTokenRevocation(other))
+}
+
+func TestRevokeAllTokens_BumpsVersionAndEndsSessions(t *testing.T) {
+	_, authService, user := setupRevocationTest(t)
+	before := issueToken(t, authService, user)
+	_, refreshToken, err := authService.CreateSession(user.ID, "TestAgent", "10.0.0.1")
+	require.NoError(t, err)
+
+	require.NoError(t, authService.RevokeAllTokens(user.ID))
+
+	assert.ErrorIs(t, authService.CheckTokenRevocation(before), types.ErrTokenRevoked)
+	_, _, err = authService.RefreshSession(refreshToken)
+	assert.ErrorIs(t, err, services.ErrSessionRevoked)
+
+	// Tokens issued afterwards carry the new version.
+	after := issueToken(t, authService, user)
+	assert.NoError(t, authService.CheckTokenRevocation(after))
+}
+
+func TestCheckTokenRevocation_DeletedUser(t *testing.T) {
+	database, authService, user := setupRevocationTest(t)
+	claims := issueToken(t, authService, user)
+
+	require.NoError(t, database.Delete(user).Error)
+	// A new service so the cached token version doesn't mask the deletion.
+	fresh := services.NewAuthService(database, config.LoadAppConfig())
+
+	assert.ErrorIs(t, fresh.CheckTokenRevocation(claims), types.ErrTokenRevoked)
+}
+
+func TestAuthMiddleware_RevokedToken(t *testing.T) {
+	_, authService, user := setupRevocationTest(t)
+	token, err := authService.GenerateToken(user.ID, user.Email)
+	require.NoError(t, err)
+	require.NoError(t, authService.RevokeAllTokens(user.ID))
+
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	nextCalled := false
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nextCalled = true
+	})
+
+	middleware.Authenticate(middleware.NewJWTAuthenticator(authService, nil, nil))(next).ServeHTTP(w, req)
+
+	assert.False(t, nextCalled)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
@@ -6,5 +6,7 @@ var (
 	ErrInvalidCredentials = errors.New("invalid credentials")
 	ErrUserNotFound       = errors.New("user not found")
 	ErrTokenExpired       = errors.New("token expired")
+	ErrTokenRevoked       = errors.New("token has been revoked")
+	ErrInvalidToken       = errors.New("invalid token")
 	ErrUserFound          = errors.New("this user is already found")
 )
@@ -0,0 +1,42 @@
+package types
+
+import "time"
+
+type AuthMethod string
+
+const (
+	AuthMethodJWT    AuthMethod = "jwt"
+	AuthMethodAPIKey AuthMethod = "api_key"
+	// An API key in signing mode, authenticated by a request signature.
+	AuthMethodSignature AuthMethod = "signature"
+	// A registered TLS client certificate presented on its own.
+	AuthMethodClientCert AuthMethod = "client_cert"
+)
+
+// Principal is whoever authenticated the request.
+type Principal struct {
+	UserID uint
+	Email  string
+	Method AuthMethod
+	// APIKeyID is set for API key principals and TokenID (the jti) for JWTs.
+	APIKeyID uint
+	TokenID  string
+	// Scopes restricts what the principal may do; nil means unrestricted,
+	// which is what user sessions get.
+	Scopes []string
+	// Per-credential request budget; zero values fall back to the default.
+	RateLimit       int
+	RateLimitWindow time.Duration
+}
+
+func (p *Principal) HasScope(scope string) bool {
+	if p.Scopes == nil {
+		return true
+	}
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
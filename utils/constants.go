@@ -1,3 +1,9 @@
 package utils
 
-const UserIDKey string = "userID"
+type contextKey string
+
+// UserIDKey holds the authenticated user's ID as a uint.
+const UserIDKey contextKey = "userID"
+
+// PrincipalKey holds the *types.Principal that authenticated the request.
+const PrincipalKey contextKey = "principal"
@@ -0,0 +1,18 @@
+package utils
+
+import (
+	"context"
+
+	"github.com/Brownei/api-generation-api/types"
+)
+
+// WithPrincipal stores the principal, and its user ID under UserIDKey, in ctx.
+func WithPrincipal(ctx context.Context, principal *types.Principal) context.Context {
+	ctx = context.WithValue(ctx, PrincipalKey, principal)
+	return context.WithValue(ctx, UserIDKey, principal.UserID)
+}
+
+func PrincipalFromContext(ctx context.Context) (*types.Principal, bool) {
+	principal, ok := ctx.Value(PrincipalKey).(*types.Principal)
+	return principal, ok && principal != nil
+}